@@ -0,0 +1,102 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// When msg_pair_batch_interval_ms is set, new message pairs are kept in
+// memory and written in a single transaction once the interval passes, which
+// is a lot cheaper for SQLite than one transaction per bridged message.
+// Lookups check the buffered pairs first, and every other query on the table
+// flushes them before running.
+
+const msgIdPairBatchMaxSize = 500
+
+var (
+	pendingPairsMu    sync.Mutex
+	pendingPairs      []MsgIdPair
+	pendingPairsTimer *time.Timer
+)
+
+func msgIdPairBatchingEnabled() bool {
+	return state.State.Config().MsgPairBatchIntervalMs > 0
+}
+
+// msgIdQueuePair buffers the pair, replacing a buffered pair of the same message.
+func msgIdQueuePair(pair MsgIdPair) {
+	pendingPairsMu.Lock()
+
+	replaced := false
+	for i := range pendingPairs {
+		if pendingPairs[i].ID == pair.ID && pendingPairs[i].WaChatId == pair.WaChatId {
+			pendingPairs[i] = pair
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		pendingPairs = append(pendingPairs, pair)
+	}
+
+	flushNow := len(pendingPairs) >= msgIdPairBatchMaxSize
+	if !flushNow && pendingPairsTimer == nil {
+		interval := time.Duration(state.State.Config().MsgPairBatchIntervalMs) * time.Millisecond
+		pendingPairsTimer = time.AfterFunc(interval, func() { MsgIdFlushPairs() })
+	}
+
+	pendingPairsMu.Unlock()
+
+	if flushNow {
+		MsgIdFlushPairs()
+	}
+}
+
+// MsgIdFlushPairs writes the buffered message pairs to the database. It must
+// be called before shutting down so that no pairs are lost.
+func MsgIdFlushPairs() error {
+	pendingPairsMu.Lock()
+	defer pendingPairsMu.Unlock()
+
+	if pendingPairsTimer != nil {
+		pendingPairsTimer.Stop()
+		pendingPairsTimer = nil
+	}
+	if len(pendingPairs) == 0 {
+		return nil
+	}
+
+	db := state.State.Database
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(pendingPairs, 100).Error
+	})
+	if err != nil {
+		state.State.Logger.Error("failed to write buffered message pairs to the database",
+			zap.Int("pairs", len(pendingPairs)),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	pendingPairs = nil
+	return nil
+}
+
+// msgIdFindPendingPair returns a copy of the first buffered pair matching fn.
+func msgIdFindPendingPair(fn func(pair *MsgIdPair) bool) (MsgIdPair, bool) {
+	pendingPairsMu.Lock()
+	defer pendingPairsMu.Unlock()
+
+	for i := range pendingPairs {
+		if fn(&pendingPairs[i]) {
+			return pendingPairs[i], true
+		}
+	}
+	return MsgIdPair{}, false
+}
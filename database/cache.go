@@ -0,0 +1,160 @@
+package database
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+)
+
+// When msg_pair_cache_size is set, recently looked up message pairs are kept
+// in memory for msg_pair_cache_ttl_seconds, so that replies, edits and
+// reactions to the same few messages of an active chat don't query the
+// database every time. Only found pairs are cached, and every query that
+// deletes or moves pairs invalidates the affected entries.
+
+type msgIdCacheEntry struct {
+	pair      MsgIdPair
+	expiresAt time.Time
+}
+
+var (
+	msgIdCacheMu     sync.Mutex
+	msgIdCacheLRU    = list.New()
+	msgIdCacheByWa   = make(map[string]*list.Element)
+	msgIdCacheByTg   = make(map[string]*list.Element)
+	msgIdCacheHits   uint64
+	msgIdCacheMisses uint64
+)
+
+func msgIdCacheEnabled() bool {
+	return state.State.Config().MsgPairCacheSize > 0
+}
+
+func msgIdCacheWaKey(waChatId, waMsgId string) string {
+	return waChatId + "|" + waMsgId
+}
+
+func msgIdCacheTgKey(tgChatId, tgMsgId int64) string {
+	return fmt.Sprintf("%d|%d", tgChatId, tgMsgId)
+}
+
+// msgIdCacheGet returns the cached pair stored under the key, moving it to the
+// front of the LRU list. The caller must hold msgIdCacheMu.
+func msgIdCacheGet(index map[string]*list.Element, key string) (MsgIdPair, bool) {
+	elem, found := index[key]
+	if !found {
+		msgIdCacheMisses++
+		return MsgIdPair{}, false
+	}
+
+	entry := elem.Value.(*msgIdCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		msgIdCacheRemove(elem)
+		msgIdCacheMisses++
+		return MsgIdPair{}, false
+	}
+
+	msgIdCacheLRU.MoveToFront(elem)
+	msgIdCacheHits++
+	return entry.pair, true
+}
+
+func msgIdCacheGetByWa(waChatId, waMsgId string) (MsgIdPair, bool) {
+	if !msgIdCacheEnabled() {
+		return MsgIdPair{}, false
+	}
+	msgIdCacheMu.Lock()
+	defer msgIdCacheMu.Unlock()
+	return msgIdCacheGet(msgIdCacheByWa, msgIdCacheWaKey(waChatId, waMsgId))
+}
+
+func msgIdCacheGetByTg(tgChatId, tgMsgId int64) (MsgIdPair, bool) {
+	if !msgIdCacheEnabled() {
+		return MsgIdPair{}, false
+	}
+	msgIdCacheMu.Lock()
+	defer msgIdCacheMu.Unlock()
+	return msgIdCacheGet(msgIdCacheByTg, msgIdCacheTgKey(tgChatId, tgMsgId))
+}
+
+// msgIdCachePut caches a pair that was found in the database, evicting the
+// least recently used pairs once msg_pair_cache_size is reached.
+func msgIdCachePut(pair MsgIdPair) {
+	if !msgIdCacheEnabled() || pair.ID == "" {
+		return
+	}
+
+	cfg := state.State.Config()
+	ttl := time.Duration(cfg.MsgPairCacheTTLSeconds) * time.Second
+
+	msgIdCacheMu.Lock()
+	defer msgIdCacheMu.Unlock()
+
+	msgIdCacheInvalidate(pair.WaChatId, pair.ID, pair.TgChatId, pair.TgMsgId)
+
+	elem := msgIdCacheLRU.PushFront(&msgIdCacheEntry{pair, time.Now().Add(ttl)})
+	msgIdCacheByWa[msgIdCacheWaKey(pair.WaChatId, pair.ID)] = elem
+	msgIdCacheByTg[msgIdCacheTgKey(pair.TgChatId, pair.TgMsgId)] = elem
+
+	for msgIdCacheLRU.Len() > cfg.MsgPairCacheSize {
+		msgIdCacheRemove(msgIdCacheLRU.Back())
+	}
+}
+
+// msgIdCacheInvalidate removes the pairs cached under either side of a pair.
+// The caller must hold msgIdCacheMu.
+func msgIdCacheInvalidate(waChatId, waMsgId string, tgChatId, tgMsgId int64) {
+	if elem, found := msgIdCacheByWa[msgIdCacheWaKey(waChatId, waMsgId)]; found {
+		msgIdCacheRemove(elem)
+	}
+	if elem, found := msgIdCacheByTg[msgIdCacheTgKey(tgChatId, tgMsgId)]; found {
+		msgIdCacheRemove(elem)
+	}
+}
+
+// msgIdCacheRemove removes a cached pair. The caller must hold msgIdCacheMu.
+func msgIdCacheRemove(elem *list.Element) {
+	entry := msgIdCacheLRU.Remove(elem).(*msgIdCacheEntry)
+	delete(msgIdCacheByWa, msgIdCacheWaKey(entry.pair.WaChatId, entry.pair.ID))
+	delete(msgIdCacheByTg, msgIdCacheTgKey(entry.pair.TgChatId, entry.pair.TgMsgId))
+}
+
+func msgIdCacheInvalidateByTg(tgChatId, tgMsgId int64) {
+	msgIdCacheMu.Lock()
+	defer msgIdCacheMu.Unlock()
+	if elem, found := msgIdCacheByTg[msgIdCacheTgKey(tgChatId, tgMsgId)]; found {
+		msgIdCacheRemove(elem)
+	}
+}
+
+func msgIdCacheInvalidateByWa(waChatId, waMsgId string) {
+	msgIdCacheMu.Lock()
+	defer msgIdCacheMu.Unlock()
+	if elem, found := msgIdCacheByWa[msgIdCacheWaKey(waChatId, waMsgId)]; found {
+		msgIdCacheRemove(elem)
+	}
+}
+
+// msgIdCacheInvalidateWhere removes the cached pairs matching fn.
+func msgIdCacheInvalidateWhere(fn func(pair *MsgIdPair) bool) {
+	msgIdCacheMu.Lock()
+	defer msgIdCacheMu.Unlock()
+	for elem := msgIdCacheLRU.Front(); elem != nil; {
+		next := elem.Next()
+		if fn(&elem.Value.(*msgIdCacheEntry).pair) {
+			msgIdCacheRemove(elem)
+		}
+		elem = next
+	}
+}
+
+// MsgIdCacheStats returns the number of cached message pairs and the number
+// of lookups that were answered from the cache and from the database.
+func MsgIdCacheStats() (size int, hits, misses uint64) {
+	msgIdCacheMu.Lock()
+	defer msgIdCacheMu.Unlock()
+	return msgIdCacheLRU.Len(), msgIdCacheHits, msgIdCacheMisses
+}
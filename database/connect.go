@@ -2,6 +2,10 @@ package database
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"watgbridge/state"
 
@@ -22,8 +26,19 @@ func hasKeys(p_map *map[string]string, keys ...string) (missingKeys []string) {
 	return missingKeys
 }
 
+// Connect opens the database and applies the connection pool settings from
+// the optional max_open_conns, max_idle_conns and conn_max_lifetime_seconds
+// keys of the database config.
 func Connect() (*gorm.DB, error) {
-	dbConfig := state.State.Config.Database
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return db, configurePool(db)
+}
+
+func open() (*gorm.DB, error) {
+	dbConfig := state.State.Config().Database
 	dbType, exists := dbConfig["type"]
 	if !exists {
 		return nil, fmt.Errorf("Error: key 'type' not found in database config")
@@ -35,7 +50,7 @@ func Connect() (*gorm.DB, error) {
 
 	case "postgres":
 
-		if missingKeys := hasKeys(&state.State.Config.Database,
+		if missingKeys := hasKeys(&state.State.Config().Database,
 			"host", "user", "password", "dbname", "port", "time_zone",
 		); len(missingKeys) != 0 {
 			return nil, fmt.Errorf("Error: database config for type '%s' requires the keys %+v", dbType, missingKeys)
@@ -56,15 +71,19 @@ func Connect() (*gorm.DB, error) {
 
 	case "sqlite":
 
-		if missingKeys := hasKeys(&state.State.Config.Database, "path"); len(missingKeys) != 0 {
+		if missingKeys := hasKeys(&state.State.Config().Database, "path"); len(missingKeys) != 0 {
 			return nil, fmt.Errorf("Error: database config for type '%s' requires the keys %+v", dbType, missingKeys)
 		}
 
-		return gorm.Open(sqlite.Open(dbConfig["path"]), &gormConfig)
+		dsn, err := sqliteDSN(dbConfig)
+		if err != nil {
+			return nil, err
+		}
+		return gorm.Open(sqlite.Open(dsn), &gormConfig)
 
 	case "mysql":
 
-		if missingKeys := hasKeys(&state.State.Config.Database,
+		if missingKeys := hasKeys(&state.State.Config().Database,
 			"user", "password", "host", "port", "dbname",
 		); len(missingKeys) != 0 {
 			return nil, fmt.Errorf("Error: database config for type '%s' requires the keys %+v", dbType, missingKeys)
@@ -83,3 +102,86 @@ func Connect() (*gorm.DB, error) {
 
 	return nil, fmt.Errorf("Database of type '%s' is not supported", dbType)
 }
+
+// sqliteDSN adds a busy timeout to the SQLite path, so that writers wait for
+// the lock instead of failing with "database is locked", and the journal mode
+// (WAL unless journal_mode says otherwise) so that reads don't block on them.
+// Transactions take the write lock when they begin, which avoids deadlocks
+// between two transactions that both started out reading. Options already in
+// the path are kept.
+func sqliteDSN(dbConfig map[string]string) (string, error) {
+	path := dbConfig["path"]
+
+	busyTimeout := "5000"
+	if value, found := dbConfig["busy_timeout_ms"]; found {
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("Error: database config key 'busy_timeout_ms' must be a number")
+		}
+		busyTimeout = value
+	}
+
+	journalMode := "WAL"
+	if value, found := dbConfig["journal_mode"]; found {
+		journalMode = strings.ToUpper(value)
+	}
+	switch journalMode {
+	case "WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF":
+	default:
+		return "", fmt.Errorf("Error: database config key 'journal_mode' must be one of WAL, DELETE, TRUNCATE, PERSIST, MEMORY or OFF")
+	}
+
+	base, rawQuery, _ := strings.Cut(path, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("Error: failed to parse the options in the sqlite path: %s", err)
+	}
+	if !query.Has("_busy_timeout") && !query.Has("_timeout") {
+		query.Set("_busy_timeout", busyTimeout)
+	}
+	if !query.Has("_journal_mode") && !query.Has("_journal") {
+		query.Set("_journal_mode", journalMode)
+	}
+	if !query.Has("_txlock") {
+		query.Set("_txlock", "immediate")
+	}
+	return base + "?" + query.Encode(), nil
+}
+
+func configurePool(db *gorm.DB) error {
+	dbConfig := state.State.Config().Database
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	// SQLite allows a single writer, so by default all queries share one
+	// connection instead of waiting on each other's locks
+	if dbConfig["type"] == "sqlite" {
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	if value, found := dbConfig["max_open_conns"]; found {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Error: database config key 'max_open_conns' must be a number")
+		}
+		sqlDB.SetMaxOpenConns(n)
+	}
+	if value, found := dbConfig["max_idle_conns"]; found {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Error: database config key 'max_idle_conns' must be a number")
+		}
+		sqlDB.SetMaxIdleConns(n)
+	}
+	if value, found := dbConfig["conn_max_lifetime_seconds"]; found {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Error: database config key 'conn_max_lifetime_seconds' must be a number")
+		}
+		sqlDB.SetConnMaxLifetime(time.Duration(n) * time.Second)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+
+	"watgbridge/state"
+)
+
+// When msg_pair_content_hash or msg_pair_snippet_length are set, the text or
+// caption of bridged messages is stored along with their IDs, as a SHA-256
+// hash and/or its first few characters. They are left empty for messages
+// without text and for pairs stored while both options were disabled. The
+// bridge itself doesn't read them back, they are there for exports and
+// tools that read the database.
+
+// msgIdContentHash returns the hash stored for a message with this text, or
+// an empty string if it has no text.
+func msgIdContentHash(content string) string {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func msgIdContentSnippet(content string, length int) string {
+	content = strings.TrimSpace(content)
+	if length <= 0 || utf8.RuneCountInString(content) <= length {
+		return content
+	}
+	return string([]rune(content)[:length])
+}
+
+// msgIdContentFields returns the hash and snippet to store for the text of a
+// bridged message, each left empty if disabled.
+func msgIdContentFields(content string) (contentHash, snippet string) {
+	cfg := state.State.Config()
+	if cfg.MsgPairContentHash {
+		contentHash = msgIdContentHash(content)
+	}
+	if cfg.MsgPairSnippetLength > 0 {
+		snippet = msgIdContentSnippet(content, cfg.MsgPairSnippetLength)
+	}
+	return contentHash, snippet
+}
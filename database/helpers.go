@@ -2,13 +2,37 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
 
 	"watgbridge/state"
 
 	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm/clause"
 )
 
-func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId, tgThreadId int64) error {
+func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId, tgThreadId int64, content string) error {
+
+	msgIdCacheInvalidateByWa(waChatId, waMsgId)
+	contentHash, snippet := msgIdContentFields(content)
+
+	if msgIdPairBatchingEnabled() {
+		msgIdQueuePair(MsgIdPair{
+			ID:            waMsgId,
+			ParticipantId: participantId,
+			WaChatId:      waChatId,
+			TgChatId:      tgChatId,
+			TgMsgId:       tgMsgId,
+			TgThreadId:    tgThreadId,
+			MarkRead:      sql.NullBool{Valid: true, Bool: false},
+			BridgedAt:     sql.NullTime{Valid: true, Time: time.Now().UTC()},
+			ContentHash:   contentHash,
+			Snippet:       snippet,
+		})
+		return nil
+	}
 
 	db := state.State.Database
 
@@ -25,6 +49,9 @@ func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId,
 		bridgePair.TgMsgId = tgMsgId
 		bridgePair.TgThreadId = tgThreadId
 		bridgePair.MarkRead = sql.NullBool{Valid: true, Bool: false}
+		bridgePair.BridgedAt = sql.NullTime{Valid: true, Time: time.Now().UTC()}
+		bridgePair.ContentHash = contentHash
+		bridgePair.Snippet = snippet
 		res = db.Save(&bridgePair)
 		return res.Error
 	}
@@ -37,12 +64,27 @@ func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId,
 		TgMsgId:       tgMsgId,
 		TgThreadId:    tgThreadId,
 		MarkRead:      sql.NullBool{Valid: true, Bool: false},
+		BridgedAt:     sql.NullTime{Valid: true, Time: time.Now().UTC()},
+		ContentHash:   contentHash,
+		Snippet:       snippet,
 	})
 	return res.Error
 }
 
 func MsgIdGetTgFromWa(waMsgId, waChatId string) (int64, int64, int64, error) {
 
+	if pair, found := msgIdFindPendingPair(func(pair *MsgIdPair) bool {
+		return pair.ID == waMsgId && pair.WaChatId == waChatId
+	}); found {
+		return pair.TgChatId, pair.TgThreadId, pair.TgMsgId, nil
+	} else if pair, found := msgIdFindPendingPair(func(pair *MsgIdPair) bool {
+		return pair.ID == waMsgId
+	}); found {
+		return pair.TgChatId, pair.TgThreadId, pair.TgMsgId, nil
+	} else if pair, found := msgIdCacheGetByWa(waChatId, waMsgId); found {
+		return pair.TgChatId, pair.TgThreadId, pair.TgMsgId, nil
+	}
+
 	db := state.State.Database
 
 	var candidates []MsgIdPair
@@ -54,33 +96,60 @@ func MsgIdGetTgFromWa(waMsgId, waChatId string) (int64, int64, int64, error) {
 	} else if len(candidates) > 1 {
 		res = db.Where("id = ?  AND wa_chat_id = ?", waMsgId, waChatId).Find(&bridgePair)
 	}
+	if res.Error == nil {
+		msgIdCachePut(bridgePair)
+	}
 	return bridgePair.TgChatId, bridgePair.TgThreadId, bridgePair.TgMsgId, res.Error
 }
 
 func MsgIdGetWaFromTg(tgChatId, tgMsgId, tgThreadId int64) (msgId, participantId, chatId string, err error) {
 
+	if pair, found := msgIdFindPendingPair(func(pair *MsgIdPair) bool {
+		return pair.TgChatId == tgChatId && pair.TgMsgId == tgMsgId && pair.TgThreadId == tgThreadId
+	}); found {
+		return pair.ID, pair.ParticipantId, pair.WaChatId, nil
+	} else if pair, found := msgIdCacheGetByTg(tgChatId, tgMsgId); found && pair.TgThreadId == tgThreadId {
+		return pair.ID, pair.ParticipantId, pair.WaChatId, nil
+	}
+
 	db := state.State.Database
 
 	var bridgePair MsgIdPair
 	res := db.Where("tg_chat_id = ? AND tg_msg_id = ? AND tg_thread_id = ?", tgChatId, tgMsgId, tgThreadId).Find(&bridgePair)
+	if res.Error == nil {
+		msgIdCachePut(bridgePair)
+	}
 
 	return bridgePair.ID, bridgePair.ParticipantId, bridgePair.WaChatId, res.Error
 }
 
 // MsgIdGetWaFromTgByMsgId looks up a WhatsApp message pair by Telegram chat+message ID only,
-// ignoring thread ID. Used for reactions where thread ID is not available.
+// ignoring thread ID. Used for reactions where thread ID is not available, and for
+// messages in the General topic where it doesn't identify the WhatsApp chat.
 func MsgIdGetWaFromTgByMsgId(tgChatId, tgMsgId int64) (msgId, participantId, chatId string, err error) {
 
+	if pair, found := msgIdFindPendingPair(func(pair *MsgIdPair) bool {
+		return pair.TgChatId == tgChatId && pair.TgMsgId == tgMsgId
+	}); found {
+		return pair.ID, pair.ParticipantId, pair.WaChatId, nil
+	} else if pair, found := msgIdCacheGetByTg(tgChatId, tgMsgId); found {
+		return pair.ID, pair.ParticipantId, pair.WaChatId, nil
+	}
+
 	db := state.State.Database
 
 	var bridgePair MsgIdPair
-	res := db.Where("tg_chat_id = ? AND tg_msg_id = ?", tgChatId, tgMsgId).First(&bridgePair)
+	res := db.Where("tg_chat_id = ? AND tg_msg_id = ?", tgChatId, tgMsgId).Limit(1).Find(&bridgePair)
+	if res.Error == nil {
+		msgIdCachePut(bridgePair)
+	}
 
 	return bridgePair.ID, bridgePair.ParticipantId, bridgePair.WaChatId, res.Error
 }
 
 func MsgIdGetUnread(waChatId string) (map[string]([]string), error) {
 
+	MsgIdFlushPairs()
 	db := state.State.Database
 
 	var bridgePairs []MsgIdPair
@@ -100,6 +169,7 @@ func MsgIdGetUnread(waChatId string) (map[string]([]string), error) {
 
 func MsgIdMarkRead(waChatId, waMsgId string) error {
 
+	MsgIdFlushPairs()
 	db := state.State.Database
 
 	var bridgePair MsgIdPair
@@ -119,22 +189,84 @@ func MsgIdMarkRead(waChatId, waMsgId string) error {
 
 func MsgIdDeletePair(tgChatId, tgMsgId int64) error {
 
+	MsgIdFlushPairs()
+	msgIdCacheInvalidateByTg(tgChatId, tgMsgId)
 	db := state.State.Database
 	res := db.Where("tg_chat_id = ? AND tg_msg_id = ?", tgChatId, tgMsgId).Delete(&MsgIdPair{})
 
 	return res.Error
 }
 
-func MsgIdDeletePairsByThreadId(tgChatId, tgThreadId int64) error {
+// MsgIdDeletePairsByThreadId deletes all the pairs of a thread and returns how many were removed.
+func MsgIdDeletePairsByThreadId(tgChatId, tgThreadId int64) (int64, error) {
 
+	MsgIdFlushPairs()
+	msgIdCacheInvalidateWhere(func(pair *MsgIdPair) bool {
+		return pair.TgChatId == tgChatId && pair.TgThreadId == tgThreadId
+	})
 	db := state.State.Database
 	res := db.Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).Delete(&MsgIdPair{})
 
+	return res.RowsAffected, res.Error
+}
+
+// MsgIdGetTgMsgIdsByThreadIdSince returns the Telegram message IDs in a thread
+// that were bridged after the given time.
+func MsgIdGetTgMsgIdsByThreadIdSince(tgChatId, tgThreadId int64, since time.Time) ([]int64, error) {
+
+	MsgIdFlushPairs()
+	db := state.State.Database
+
+	var tgMsgIds []int64
+	res := db.Model(&MsgIdPair{}).Where("tg_chat_id = ? AND tg_thread_id = ? AND bridged_at > ?",
+		tgChatId, tgThreadId, since).Pluck("tg_msg_id", &tgMsgIds)
+
+	return tgMsgIds, res.Error
+}
+
+// MsgIdGetPairsByThreadId returns the pairs of a thread in the order they
+// were sent to Telegram.
+func MsgIdGetPairsByThreadId(tgChatId, tgThreadId int64) ([]MsgIdPair, error) {
+
+	MsgIdFlushPairs()
+	db := state.State.Database
+
+	var pairs []MsgIdPair
+	res := db.Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).Order("tg_msg_id").Find(&pairs)
+
+	return pairs, res.Error
+}
+
+// MsgIdGetLastBridgedAt returns when a message was last bridged to the thread.
+// It reports false if none was, or only before bridged_at was added.
+func MsgIdGetLastBridgedAt(tgChatId, tgThreadId int64) (time.Time, bool, error) {
+
+	MsgIdFlushPairs()
+	db := state.State.Database
+
+	var lastPair MsgIdPair
+	res := db.Where("tg_chat_id = ? AND tg_thread_id = ? AND bridged_at IS NOT NULL", tgChatId, tgThreadId).
+		Order("bridged_at DESC").Limit(1).Find(&lastPair)
+
+	return lastPair.BridgedAt.Time, lastPair.BridgedAt.Valid, res.Error
+}
+
+// MsgIdMigrateTgChatId moves all the pairs of a Telegram chat to its new ID,
+// used when a group is migrated to a supergroup.
+func MsgIdMigrateTgChatId(oldTgChatId, newTgChatId int64) error {
+
+	MsgIdFlushPairs()
+	msgIdCacheInvalidateWhere(func(pair *MsgIdPair) bool { return pair.TgChatId == oldTgChatId })
+	db := state.State.Database
+	res := db.Model(&MsgIdPair{}).Where("tg_chat_id = ?", oldTgChatId).Update("tg_chat_id", newTgChatId)
+
 	return res.Error
 }
 
 func MsgIdDropAllPairs() error {
 
+	MsgIdFlushPairs()
+	msgIdCacheInvalidateWhere(func(pair *MsgIdPair) bool { return true })
 	db := state.State.Database
 	res := db.Where("1 = 1").Delete(&MsgIdPair{})
 
@@ -142,6 +274,7 @@ func MsgIdDropAllPairs() error {
 }
 
 func ChatThreadAddNewPair(waChatId string, tgChatId, tgThreadId int64) error {
+	defer chatThreadCacheInvalidate()
 
 	db := state.State.Database
 
@@ -169,6 +302,12 @@ func ChatThreadAddNewPair(waChatId string, tgChatId, tgThreadId int64) error {
 
 func ChatThreadGetTgFromWa(waChatId string, tgChatId int64) (int64, bool, error) {
 
+	if chatPair, found, ok := chatThreadCachedFind(func(pair *ChatThreadPair) bool {
+		return pair.ID == waChatId && pair.TgChatId == tgChatId
+	}); ok {
+		return chatPair.TgThreadId, found, nil
+	}
+
 	db := state.State.Database
 
 	var chatPair ChatThreadPair
@@ -186,6 +325,7 @@ func ChatThreadGetPinnedMsgId(waChatId string, tgChatId int64) (int64, error) {
 }
 
 func ChatThreadSetPinnedMsgId(waChatId string, tgChatId int64, pinnedMsgId int64) error {
+	defer chatThreadCacheInvalidate()
 	db := state.State.Database
 	res := db.Model(&ChatThreadPair{}).
 		Where("id = ? AND tg_chat_id = ?", waChatId, tgChatId).
@@ -193,7 +333,78 @@ func ChatThreadSetPinnedMsgId(waChatId string, tgChatId int64, pinnedMsgId int64
 	return res.Error
 }
 
+func ChatThreadGetDescriptionMsgId(waChatId string, tgChatId int64) (int64, error) {
+	db := state.State.Database
+	var chatPair ChatThreadPair
+	res := db.Where("id = ? AND tg_chat_id = ?", waChatId, tgChatId).Find(&chatPair)
+	return chatPair.DescriptionMsgId, res.Error
+}
+
+func ChatThreadSetDescriptionMsgId(waChatId string, tgChatId int64, descriptionMsgId int64) error {
+	defer chatThreadCacheInvalidate()
+	db := state.State.Database
+	res := db.Model(&ChatThreadPair{}).
+		Where("id = ? AND tg_chat_id = ?", waChatId, tgChatId).
+		Update("description_msg_id", descriptionMsgId)
+	return res.Error
+}
+
+func ChatThreadSetManuallyClosed(tgChatId, tgThreadId int64, closed bool) error {
+	defer chatThreadCacheInvalidate()
+	db := state.State.Database
+	res := db.Model(&ChatThreadPair{}).
+		Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).
+		Update("manually_closed", closed)
+	return res.Error
+}
+
+func ChatThreadSetMediaEnabled(tgChatId, tgThreadId int64, enabled bool) error {
+	defer chatThreadCacheInvalidate()
+	db := state.State.Database
+	res := db.Model(&ChatThreadPair{}).
+		Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).
+		Update("media_enabled", enabled)
+	return res.Error
+}
+
+// ChatThreadGetMediaEnabled reports whether media should be bridged to the
+// given thread. Threads without a stored pair always get media.
+func ChatThreadGetMediaEnabled(tgChatId, tgThreadId int64) (bool, error) {
+	if chatPair, found, ok := chatThreadCachedFind(func(pair *ChatThreadPair) bool {
+		return pair.TgChatId == tgChatId && pair.TgThreadId == tgThreadId
+	}); ok {
+		return !found || chatPair.MediaEnabled, nil
+	}
+
+	db := state.State.Database
+
+	var chatPair ChatThreadPair
+	res := db.Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).Find(&chatPair)
+	if res.Error != nil || chatPair.ID == "" {
+		return true, res.Error
+	}
+
+	return chatPair.MediaEnabled, nil
+}
+
+// ChatThreadGetManuallyClosed reports whether the thread was closed using
+// /close. Threads without a stored pair never are.
+func ChatThreadGetManuallyClosed(tgChatId, tgThreadId int64) (bool, error) {
+	if chatPair, found, ok := chatThreadCachedFind(func(pair *ChatThreadPair) bool {
+		return pair.TgChatId == tgChatId && pair.TgThreadId == tgThreadId
+	}); ok {
+		return found && chatPair.ManuallyClosed, nil
+	}
+
+	db := state.State.Database
+
+	var chatPair ChatThreadPair
+	res := db.Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).Find(&chatPair)
+	return chatPair.ManuallyClosed, res.Error
+}
+
 func ChatThreadDropPairByTg(tgChatId, tgThreadId int64) error {
+	defer chatThreadCacheInvalidate()
 
 	db := state.State.Database
 
@@ -204,6 +415,12 @@ func ChatThreadDropPairByTg(tgChatId, tgThreadId int64) error {
 
 func ChatThreadGetWaFromTg(tgChatId, tgThreadId int64) (string, error) {
 
+	if chatPair, _, ok := chatThreadCachedFind(func(pair *ChatThreadPair) bool {
+		return pair.TgChatId == tgChatId && pair.TgThreadId == tgThreadId
+	}); ok {
+		return chatPair.ID, nil
+	}
+
 	db := state.State.Database
 
 	var chatPair ChatThreadPair
@@ -212,8 +429,39 @@ func ChatThreadGetWaFromTg(tgChatId, tgThreadId int64) (string, error) {
 	return chatPair.ID, res.Error
 }
 
+// ChatThreadIsShared reports whether more than one WhatsApp chat is mapped to the thread.
+func ChatThreadIsShared(tgChatId, tgThreadId int64) (bool, error) {
+
+	if chatThreadCacheEnabled() {
+		if chatPairs, err := ChatThreadPairsCached(); err == nil {
+			count := 0
+			for _, pair := range chatPairs {
+				if pair.TgChatId == tgChatId && pair.TgThreadId == tgThreadId {
+					count++
+				}
+			}
+			return count > 1, nil
+		}
+	}
+
+	db := state.State.Database
+
+	var count int64
+	res := db.Model(&ChatThreadPair{}).Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).Count(&count)
+
+	return count > 1, res.Error
+}
+
 func ChatThreadGetAllPairs(tgChatId int64) ([]ChatThreadPair, error) {
 
+	if chatThreadCacheEnabled() {
+		if chatPairs, err := ChatThreadPairsCached(); err == nil {
+			return slices.DeleteFunc(chatPairs, func(pair ChatThreadPair) bool {
+				return pair.TgChatId != tgChatId
+			}), nil
+		}
+	}
+
 	db := state.State.Database
 
 	var chatPairs []ChatThreadPair
@@ -222,7 +470,19 @@ func ChatThreadGetAllPairs(tgChatId int64) ([]ChatThreadPair, error) {
 	return chatPairs, res.Error
 }
 
+// ChatThreadMigrateTgChatId moves all the chat pairs of a Telegram chat to its
+// new ID, used when a group is migrated to a supergroup.
+func ChatThreadMigrateTgChatId(oldTgChatId, newTgChatId int64) error {
+	defer chatThreadCacheInvalidate()
+
+	db := state.State.Database
+	res := db.Model(&ChatThreadPair{}).Where("tg_chat_id = ?", oldTgChatId).Update("tg_chat_id", newTgChatId)
+
+	return res.Error
+}
+
 func ChatThreadDropAllPairs() error {
+	defer chatThreadCacheInvalidate()
 
 	db := state.State.Database
 	res := db.Where("1 = 1").Delete(&ChatThreadPair{})
@@ -230,6 +490,87 @@ func ChatThreadDropAllPairs() error {
 	return res.Error
 }
 
+// ForumTopicRecord stores that a message was seen in the topic, along with
+// its name if known.
+func ForumTopicRecord(tgChatId, tgThreadId int64, name string) error {
+
+	db := state.State.Database
+
+	topic := ForumTopic{
+		TgChatId:   tgChatId,
+		TgThreadId: tgThreadId,
+		Name:       name,
+		LastSeenAt: time.Now().UTC(),
+	}
+	updateColumns := []string{"last_seen_at"}
+	if name != "" {
+		updateColumns = append(updateColumns, "name")
+	}
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tg_chat_id"}, {Name: "tg_thread_id"}},
+		DoUpdates: clause.AssignmentColumns(updateColumns),
+	}).Create(&topic)
+
+	return res.Error
+}
+
+// ChatActivityRecord stores that the chat had a message at the given time,
+// unless a later one was already recorded.
+func ChatActivityRecord(waChatId string, at time.Time) error {
+
+	db := state.State.Database
+	at = at.UTC()
+
+	// MySQL has no excluded table in upserts, so the row is only inserted
+	// here and moved forward separately if it already existed
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&ChatActivity{ID: waChatId, LastMessageAt: at})
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error
+	}
+
+	res = db.Model(&ChatActivity{}).
+		Where("id = ? AND last_message_at < ?", waChatId, at).
+		Update("last_message_at", at)
+
+	return res.Error
+}
+
+// ChatActivityGetLastMessageAt returns when the chat last had a message. It
+// reports false if no message of it was recorded.
+func ChatActivityGetLastMessageAt(waChatId string) (time.Time, bool, error) {
+
+	db := state.State.Database
+
+	var activity ChatActivity
+	res := db.Where("id = ?", waChatId).Limit(1).Find(&activity)
+
+	return activity.LastMessageAt, res.RowsAffected > 0, res.Error
+}
+
+// ForumTopicGetUnlinked returns the seen topics of the chat that no WhatsApp
+// chat is linked to.
+func ForumTopicGetUnlinked(tgChatId int64) ([]ForumTopic, error) {
+
+	db := state.State.Database
+
+	var topics []ForumTopic
+	res := db.Where("tg_chat_id = ?", tgChatId).
+		Where("NOT EXISTS (?)", db.Model(&ChatThreadPair{}).Select("1").
+			Where("chat_thread_pairs.tg_chat_id = forum_topics.tg_chat_id AND chat_thread_pairs.tg_thread_id = forum_topics.tg_thread_id")).
+		Order("tg_thread_id").Find(&topics)
+
+	return topics, res.Error
+}
+
+func ForumTopicDropAll(tgChatId int64) (int64, error) {
+
+	db := state.State.Database
+	res := db.Where("tg_chat_id = ?", tgChatId).Delete(&ForumTopic{})
+
+	return res.RowsAffected, res.Error
+}
+
 func ContactNameAddNew(waUserId, waUserServer, firstName, fullName, pushName, businessName string) error {
 	db := state.State.Database
 
@@ -259,11 +600,33 @@ func ContactNameAddNew(waUserId, waUserServer, firstName, fullName, pushName, bu
 	return res.Error
 }
 
+// ContactNameSaveError lists the contacts that ContactNameBulkAddOrUpdate
+// couldn't save, while the others were saved.
+type ContactNameSaveError struct {
+	Failed map[string]error // Contact JID -> error
+	Total  int
+}
+
+func (e *ContactNameSaveError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("failed to save 0 of %d contacts", e.Total)
+	}
+	// The first JID in order is named, so that the message is the same every time
+	jid := slices.Min(slices.Collect(maps.Keys(e.Failed)))
+	return fmt.Sprintf("failed to save %d of %d contacts, e.g. %s : %s", len(e.Failed), e.Total, jid, e.Failed[jid])
+}
+
+// ContactNameBulkAddOrUpdate saves the contacts in batches. If a batch fails
+// its contacts are saved one by one, so a single bad contact doesn't keep the
+// others from being updated, and a *ContactNameSaveError lists the ones that
+// still failed.
 func ContactNameBulkAddOrUpdate(contacts map[types.JID]types.ContactInfo) error {
+	const batchSize = 100
 
 	var (
 		db           = state.State.Database
 		contactNames []ContactName
+		failed       = make(map[string]error)
 	)
 
 	for k, v := range contacts {
@@ -277,11 +640,20 @@ func ContactNameBulkAddOrUpdate(contacts map[types.JID]types.ContactInfo) error
 		})
 	}
 
-	res := db.Save(&contactNames)
-	if res.Error != nil {
-		return res.Error
+	for batch := range slices.Chunk(contactNames, batchSize) {
+		if res := db.Save(&batch); res.Error == nil {
+			continue
+		}
+		for _, contactName := range batch {
+			if res := db.Save(&contactName); res.Error != nil {
+				failed[contactName.ID+"@"+contactName.Server] = res.Error
+			}
+		}
 	}
 
+	if len(failed) > 0 {
+		return &ContactNameSaveError{Failed: failed, Total: len(contactNames)}
+	}
 	return nil
 }
 
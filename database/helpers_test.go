@@ -0,0 +1,30 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/internal/testutil"
+)
+
+func TestChatActivityRecordKeepsLatest(t *testing.T) {
+	testutil.Setup(t, nil)
+
+	const waChatId = "919876543210@s.whatsapp.net"
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, at := range []time.Time{base, base.Add(time.Hour), base.Add(-time.Hour)} {
+		if err := database.ChatActivityRecord(waChatId, at); err != nil {
+			t.Fatalf("ChatActivityRecord(%v) failed: %v", at, err)
+		}
+	}
+
+	got, found, err := database.ChatActivityGetLastMessageAt(waChatId)
+	if err != nil || !found {
+		t.Fatalf("ChatActivityGetLastMessageAt() = %v, %v, %v", got, found, err)
+	}
+	if want := base.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("last message at %v, want %v", got, want)
+	}
+}
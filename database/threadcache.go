@@ -0,0 +1,94 @@
+package database
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+)
+
+// When chat_thread_cache_ttl_seconds is set, all the chat pairs are kept in
+// memory, so that routing every message to its topic doesn't query the
+// database. The snapshot is dropped whenever a pair is changed and reloaded
+// on the next read, and also reloaded once it is older than the TTL in case
+// the database was edited by hand.
+
+var (
+	chatThreadSnapshotMu   sync.Mutex
+	chatThreadSnapshot     []ChatThreadPair
+	chatThreadSnapshotTime time.Time
+)
+
+func chatThreadCacheEnabled() bool {
+	return state.State.Config().ChatThreadCacheTTLSeconds > 0
+}
+
+// chatThreadCacheInvalidate drops the snapshot, it must be called after every
+// query that changes chat pairs.
+func chatThreadCacheInvalidate() {
+	chatThreadSnapshotMu.Lock()
+	defer chatThreadSnapshotMu.Unlock()
+	chatThreadSnapshot = nil
+}
+
+// ChatThreadPairsCached returns all the chat pairs, from the snapshot if it is
+// enabled and fresh. The returned slice is a copy that can be modified.
+func ChatThreadPairsCached() ([]ChatThreadPair, error) {
+	if !chatThreadCacheEnabled() {
+		var chatPairs []ChatThreadPair
+		res := state.State.Database.Find(&chatPairs)
+		return chatPairs, res.Error
+	}
+
+	chatThreadSnapshotMu.Lock()
+	defer chatThreadSnapshotMu.Unlock()
+
+	if err := chatThreadSnapshotRefresh(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(chatThreadSnapshot), nil
+}
+
+// chatThreadSnapshotRefresh reloads the snapshot if it was dropped or is older
+// than the TTL. chatThreadSnapshotMu must be held.
+func chatThreadSnapshotRefresh() error {
+	ttl := time.Duration(state.State.Config().ChatThreadCacheTTLSeconds) * time.Second
+	if chatThreadSnapshot != nil && time.Since(chatThreadSnapshotTime) <= ttl {
+		return nil
+	}
+
+	var chatPairs []ChatThreadPair
+	if res := state.State.Database.Find(&chatPairs); res.Error != nil {
+		return res.Error
+	}
+	if chatPairs == nil {
+		chatPairs = []ChatThreadPair{}
+	}
+	chatThreadSnapshot = chatPairs
+	chatThreadSnapshotTime = time.Now()
+	return nil
+}
+
+// chatThreadCachedFind returns the first cached pair matching fn. It reports
+// ok false if the cache is disabled or couldn't be loaded, in which case the
+// database must be queried instead. The snapshot is searched in place, so fn
+// must not modify the pair.
+func chatThreadCachedFind(fn func(pair *ChatThreadPair) bool) (pair ChatThreadPair, found, ok bool) {
+	if !chatThreadCacheEnabled() {
+		return ChatThreadPair{}, false, false
+	}
+
+	chatThreadSnapshotMu.Lock()
+	defer chatThreadSnapshotMu.Unlock()
+
+	if err := chatThreadSnapshotRefresh(); err != nil {
+		return ChatThreadPair{}, false, false
+	}
+	for i := range chatThreadSnapshot {
+		if fn(&chatThreadSnapshot[i]) {
+			return chatThreadSnapshot[i], true, true
+		}
+	}
+	return ChatThreadPair{}, false, true
+}
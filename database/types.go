@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"watgbridge/state"
 )
@@ -17,7 +18,12 @@ type MsgIdPair struct {
 	TgThreadId int64
 	TgMsgId    int64
 
-	MarkRead sql.NullBool
+	MarkRead  sql.NullBool
+	BridgedAt sql.NullTime // Unset for pairs stored before this column was added
+
+	// Text of the message, only stored if enabled (see msg_pair_content_hash)
+	ContentHash string `gorm:"index"` // SHA-256 of the text or caption
+	Snippet     string // First msg_pair_snippet_length characters of the text
 }
 
 type ChatThreadPair struct {
@@ -25,6 +31,27 @@ type ChatThreadPair struct {
 	TgChatId    int64  // Telegram Chat ID
 	TgThreadId  int64  // Telegram Thread ID (Topics)
 	PinnedMsgId int64  // Telegram Message ID of the pinned profile picture (0 = none)
+
+	DescriptionMsgId int64 // Telegram Message ID of the pinned group description (0 = none)
+
+	ManuallyClosed bool // Topic was closed using /close and must not be reopened automatically
+	MediaEnabled   bool `gorm:"default:true"` // Media is downloaded and bridged, otherwise a placeholder is sent (/nomedia)
+}
+
+// ForumTopic is a topic of the target chat the bot has seen a message in,
+// kept to find topics that aren't linked to any WhatsApp chat.
+type ForumTopic struct {
+	TgChatId   int64  `gorm:"primaryKey;autoIncrement:false"` // Telegram Chat ID
+	TgThreadId int64  `gorm:"primaryKey;autoIncrement:false"` // Telegram Thread ID
+	Name       string // Empty if the bot didn't see the topic being created or renamed
+	LastSeenAt time.Time
+}
+
+// ChatActivity is when a WhatsApp chat last had a message, bridged or not,
+// used to skip creating topics for dormant chats.
+type ChatActivity struct {
+	ID            string `gorm:"primaryKey;"` // WhatsApp Chat ID, as stored in ChatThreadPair
+	LastMessageAt time.Time
 }
 
 type ContactName struct {
@@ -47,6 +74,8 @@ func AutoMigrate() error {
 	return db.AutoMigrate(
 		&MsgIdPair{},
 		&ChatThreadPair{},
+		&ForumTopic{},
+		&ChatActivity{},
 		&ContactName{},
 		&ChatEphemeralSettings{},
 	)
@@ -0,0 +1,162 @@
+// Package testutil sets up the global state of the bridge for tests: the
+// config, an in-memory database and a Telegram bot that doesn't reach
+// Telegram.
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/queue"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SetConfig replaces the config with the defaults, changed by fn if it isn't
+// nil. It is saved to a temporary file instead of config.yaml. The message
+// and chat pair caches are disabled, as they would outlive the database of
+// the previous test.
+func SetConfig(t *testing.T, fn func(cfg *state.Config)) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := state.State.UpdateConfig(func(cfg *state.Config) {
+		*cfg = state.Config{}
+		cfg.SetDefaults()
+		cfg.Path = path
+		cfg.MsgPairCacheSize = 0
+		cfg.ChatThreadCacheTTLSeconds = 0
+		cfg.WhatsApp.SkipContactProfilePictures = true
+		cfg.WhatsApp.SkipGroupProfilePictures = true
+		if fn != nil {
+			fn(cfg)
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to update the config: %v", err)
+	}
+}
+
+// OpenDatabase makes an empty in-memory database, with all the tables, the
+// database of the bridge.
+func OpenDatabase(t *testing.T) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open the database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get the database connection: %v", err)
+	}
+	// Every connection to :memory: is a separate database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	state.State.Database = db
+	state.State.Logger = zap.NewNop()
+	if err := database.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate the database: %v", err)
+	}
+}
+
+// Setup sets the config, changed by fn, and opens the database.
+func Setup(t *testing.T, fn func(cfg *state.Config)) {
+	t.Helper()
+	SetConfig(t, fn)
+	OpenDatabase(t)
+}
+
+// FakeRequest is a request made to a FakeBotClient.
+type FakeRequest struct {
+	Method string
+	Params map[string]any
+}
+
+// FakeBotClient answers the requests of the bot instead of Telegram. Created
+// topics and sent messages get increasing IDs.
+type FakeBotClient struct {
+	// Delay is waited before answering, so that concurrent requests overlap
+	Delay time.Duration
+
+	TopicsCreated atomic.Int64
+	MessagesSent  atomic.Int64
+
+	mu       sync.Mutex
+	requests []FakeRequest
+}
+
+func (c *FakeBotClient) RequestWithContext(ctx context.Context, token string, method string, params map[string]any, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, FakeRequest{Method: method, Params: params})
+	c.mu.Unlock()
+
+	time.Sleep(c.Delay)
+
+	switch method {
+	case "createForumTopic":
+		threadId := 100 + c.TopicsCreated.Add(1)
+		return json.Marshal(gotgbot.ForumTopic{MessageThreadId: threadId, Name: fmt.Sprint(params["name"])})
+	case "sendMessage":
+		msg := gotgbot.Message{MessageId: c.MessagesSent.Add(1), Text: fmt.Sprint(params["text"])}
+		fmt.Sscan(fmt.Sprint(params["chat_id"]), &msg.Chat.Id)
+		if threadId, found := params["message_thread_id"]; found {
+			fmt.Sscan(fmt.Sprint(threadId), &msg.MessageThreadId)
+		}
+		return json.Marshal(msg)
+	case "editMessageText":
+		msg := gotgbot.Message{Text: fmt.Sprint(params["text"])}
+		fmt.Sscan(fmt.Sprint(params["message_id"]), &msg.MessageId)
+		fmt.Sscan(fmt.Sprint(params["chat_id"]), &msg.Chat.Id)
+		return json.Marshal(msg)
+	}
+	return json.RawMessage("true"), nil
+}
+
+func (c *FakeBotClient) GetAPIURL(opts *gotgbot.RequestOpts) string {
+	return "http://localhost"
+}
+
+func (c *FakeBotClient) FileURL(token string, tgFilePath string, opts *gotgbot.RequestOpts) string {
+	return "http://localhost/" + tgFilePath
+}
+
+// Requests returns the requests made with the given method.
+func (c *FakeBotClient) Requests(method string) []FakeRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var requests []FakeRequest
+	for _, request := range c.requests {
+		if request.Method == method {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+var startWorkersOnce sync.Once
+
+// UseFakeBot makes the bot of the bridge send its requests to a new
+// FakeBotClient, and starts the queue workers if they aren't running yet.
+func UseFakeBot(t *testing.T) *FakeBotClient {
+	t.Helper()
+
+	botClient := &FakeBotClient{}
+	state.State.TelegramBot = &gotgbot.Bot{Token: "test", BotClient: botClient}
+	startWorkersOnce.Do(queue.StartWorkers)
+	return botClient
+}
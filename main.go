@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"watgbridge/database"
@@ -24,7 +26,7 @@ import (
 
 func main() {
 	// Load configuration file
-	cfg := state.State.Config
+	cfg := state.State.Config()
 	cfg.SetDefaults()
 
 	if len(os.Args) > 1 {
@@ -261,5 +263,42 @@ SKIP_RESTART:
 		state.State.TelegramBot.SendMessage(cfg.Telegram.OwnerID, "Successfully started WaTgBridge", &gotgbot.SendMessageOpts{})
 	}
 
+	if cfg.Telegram.AnnounceStartStop {
+		announceInGeneralTopic(fmt.Sprintf("🟢 <b>WaTgBridge started</b>\n\n• <b>Version</b>: <code>%s</code>",
+			state.WATGBRIDGE_VERSION))
+	}
+
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+
+		logger.Info("received shutdown signal, stopping the bridge")
+		if cfg.Telegram.AnnounceStartStop {
+			announceInGeneralTopic(fmt.Sprintf("🔴 <b>WaTgBridge stopped</b>\n\n• <b>Version</b>: <code>%s</code>\n• <b>Uptime</b>: %s\n\nMessages sent while the bridge is down may be missing",
+				state.WATGBRIDGE_VERSION, time.Now().UTC().Sub(state.State.StartTime).Round(time.Second).String()))
+		}
+		state.State.WhatsAppClient.Disconnect()
+		_ = state.State.TelegramUpdater.Stop()
+	}()
+
 	state.State.TelegramUpdater.Idle()
+
+	queue.FlushReplayBuffer()
+
+	if err := database.MsgIdFlushPairs(); err != nil {
+		logger.Error("failed to save buffered message pairs before exiting",
+			zap.Error(err),
+		)
+	}
+}
+
+// announceInGeneralTopic posts a bridge status message to the General topic of the target chat.
+func announceInGeneralTopic(text string) {
+	_, err := state.State.TelegramBot.SendMessage(state.State.Config().Telegram.TargetChatID, text, &gotgbot.SendMessageOpts{})
+	if err != nil {
+		state.State.Logger.Error("failed to send announcement to the target chat",
+			zap.Error(err),
+		)
+	}
 }
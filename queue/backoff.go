@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Jitter returns a random duration between 0 and factor*d, added to backoffs
+// so that requests failing together don't all retry at the same instant.
+func Jitter(d time.Duration, factor float64) time.Duration {
+	maxJitter := int64(float64(d) * factor)
+	if maxJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(maxJitter + 1))
+}
+
+// maxRetryBackoff caps the wait between two retries.
+const maxRetryBackoff = time.Minute
+
+// Backoff spaces out the retries of a failing call. The wait starts at the
+// initial duration and doubles after every retry up to a minute, with Jitter
+// added to each wait.
+type Backoff struct {
+	initial      time.Duration
+	current      time.Duration
+	jitterFactor float64
+}
+
+func NewBackoff(initial time.Duration, jitterFactor float64) *Backoff {
+	return &Backoff{initial: initial, current: initial, jitterFactor: jitterFactor}
+}
+
+// Next returns the wait before the next retry.
+func (b *Backoff) Next() time.Duration {
+	d := min(b.current, maxRetryBackoff)
+	b.current = min(b.current*2, maxRetryBackoff)
+	return d + Jitter(d, b.jitterFactor)
+}
+
+// Reset makes the next wait the initial one again, once the call succeeded.
+func (b *Backoff) Reset() {
+	b.current = b.initial
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterRange(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		factor  float64
+	}{
+		{time.Second, 0.2},
+		{3 * time.Second, 0.5},
+		{100 * time.Millisecond, 1},
+	}
+	for _, tt := range tests {
+		maxJitter := time.Duration(float64(tt.backoff) * tt.factor)
+		for range 1000 {
+			if got := Jitter(tt.backoff, tt.factor); got < 0 || got > maxJitter {
+				t.Fatalf("Jitter(%v, %v) = %v, want between 0 and %v", tt.backoff, tt.factor, got, maxJitter)
+			}
+		}
+	}
+}
+
+func TestJitterSpreadsRetries(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		seen[Jitter(time.Second, 0.2)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Jitter returned the same value 100 times, retries would not be spread out")
+	}
+}
+
+func TestJitterDisabled(t *testing.T) {
+	for _, factor := range []float64{0, -0.5} {
+		if got := Jitter(time.Second, factor); got != 0 {
+			t.Errorf("Jitter(1s, %v) = %v, want 0", factor, got)
+		}
+	}
+	if got := Jitter(0, 0.2); got != 0 {
+		t.Errorf("Jitter(0, 0.2) = %v, want 0", got)
+	}
+}
+
+func TestBackoffNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial time.Duration
+		want    []time.Duration
+	}{
+		{
+			name:    "doubles per retry",
+			initial: time.Second,
+			want:    []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:    "capped",
+			initial: 20 * time.Second,
+			want:    []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute},
+		},
+		{
+			name:    "initial above the cap",
+			initial: 2 * time.Minute,
+			want:    []time.Duration{time.Minute, time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backoff := NewBackoff(tt.initial, 0)
+			for i, want := range tt.want {
+				if got := backoff.Next(); got != want {
+					t.Errorf("retry %d: Next() = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	backoff := NewBackoff(time.Second, 0)
+	for range 3 {
+		backoff.Next()
+	}
+	backoff.Reset()
+	if got := backoff.Next(); got != time.Second {
+		t.Errorf("Next() after Reset() = %v, want %v", got, time.Second)
+	}
+	if got := backoff.Next(); got != 2*time.Second {
+		t.Errorf("second Next() after Reset() = %v, want %v", got, 2*time.Second)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	backoff := NewBackoff(time.Second, 0.5)
+	for _, base := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := backoff.Next(); got < base || got > base+base/2 {
+			t.Errorf("Next() = %v, want between %v and %v", got, base, base+base/2)
+		}
+	}
+}
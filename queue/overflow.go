@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrQueueFull is returned for calls dropped because their queue was full,
+// following queue_overflow_policy.
+var ErrQueueFull = errors.New("the queue is full, the call was dropped")
+
+var errEnqueueTimedOut = errors.New("timed out waiting for space in the queue")
+
+type queueJob struct {
+	run  func()
+	drop func() // Called instead of run when the job is evicted from a full queue
+}
+
+// enqueue adds the job to the queue following the overflow policy:
+//   - "block" (default) waits for space, or until timeoutCh fires
+//   - "drop_newest" rejects the job with ErrQueueFull
+//   - "drop_oldest" evicts the oldest queued jobs to make space for it
+func enqueue(name string, ch chan queueJob, job queueJob, policy string, timeoutCh <-chan time.Time) error {
+	switch policy {
+	case "drop_newest":
+		select {
+		case ch <- job:
+			return nil
+		default:
+			log.Printf("[%s] queue is full, dropping the new job", name)
+			return ErrQueueFull
+		}
+
+	case "drop_oldest":
+		for {
+			select {
+			case ch <- job:
+				return nil
+			default:
+			}
+			select {
+			case oldest := <-ch:
+				log.Printf("[%s] queue is full, dropping the oldest job", name)
+				oldest.drop()
+			default:
+			}
+		}
+
+	default:
+		select {
+		case ch <- job:
+			return nil
+		case <-timeoutCh:
+			return errEnqueueTimedOut
+		}
+	}
+}
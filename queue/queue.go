@@ -5,7 +5,9 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -21,38 +23,95 @@ import (
 const QueueSize = 1000
 
 // NOTE: Do NOT initialize WaInterval / TgInterval as package-level vars from
-// state.State.Config here – config is not yet loaded at package-init time, so
+// state.State.Config() here – config is not yet loaded at package-init time, so
 // those values would always be 0. Workers read the config on every iteration
 // instead (see waWorker / tgWorker).
 
-var waJobCh = make(chan func(), QueueSize)
-var tgJobCh = make(chan func(), QueueSize)
+var waJobCh = make(chan queueJob, QueueSize)
+var tgJobCh = make(chan queueJob, QueueSize)
+
+// ErrWaSendTimeout is returned by WaSend when the send didn't finish within
+// the configured send_timeout_seconds. The job may still complete later.
+var ErrWaSendTimeout = errors.New("timed out waiting for the WhatsApp send to complete")
+
+// ErrWorkersNotStarted is returned by WaSend and TgRun when they are called
+// before StartWorkers, as nothing would ever take the job from the queue.
+var ErrWorkersNotStarted = errors.New("send queue workers are not started yet")
+
+// workersStarted is set by StartWorkers. Sends are refused until then instead
+// of waiting on a queue nobody drains.
+var workersStarted atomic.Bool
 
 // counters for log correlation
 var waJobCounter atomic.Int64
 var tgJobCounter atomic.Int64
 
 // StartWorkers launches the background rate-limited sender goroutines.
-// Must be called exactly once at startup, AFTER the config has been loaded.
+// Must be called exactly once at startup, AFTER the config has been loaded and
+// before anything is sent. It panics if called again.
 func StartWorkers() {
+	if workersStarted.Swap(true) {
+		panic("queue: StartWorkers called more than once")
+	}
 	log.Printf("[queue] starting workers (queue size: %d)", QueueSize)
 	go waWorker()
 	go tgWorker()
+	startReplayWorker()
 	log.Printf("[queue] workers started")
 }
 
+// waWorkerResumed is closed when the WhatsApp worker is resumed, it is nil
+// while it is not paused.
+var (
+	waWorkerPauseMu sync.Mutex
+	waWorkerResumed chan struct{}
+)
+
+// PauseWaWorker makes the WhatsApp worker stop taking jobs from its queue
+// until ResumeWaWorker is called. Senders keep blocking (or follow the
+// overflow policy) in the meantime. The Telegram worker keeps running, so
+// that command replies, including the one resuming it, still go out.
+func PauseWaWorker() {
+	waWorkerPauseMu.Lock()
+	defer waWorkerPauseMu.Unlock()
+	if waWorkerResumed == nil {
+		waWorkerResumed = make(chan struct{})
+		log.Printf("[wa_queue] worker paused")
+	}
+}
+
+func ResumeWaWorker() {
+	waWorkerPauseMu.Lock()
+	defer waWorkerPauseMu.Unlock()
+	if waWorkerResumed != nil {
+		close(waWorkerResumed)
+		waWorkerResumed = nil
+		log.Printf("[wa_queue] worker resumed")
+	}
+}
+
+func waitWhileWaWorkerPaused() {
+	waWorkerPauseMu.Lock()
+	resumed := waWorkerResumed
+	waWorkerPauseMu.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+}
+
 func waWorker() {
 	log.Printf("[wa_queue] worker ready")
 	for job := range waJobCh {
+		waitWhileWaWorkerPaused()
 		// seq := waJobCounter.Add(1)
 		// depth := len(waJobCh)
 		// log.Printf("[wa_queue] job #%d started (remaining in queue: %d)", seq, depth)
-		job()
+		job.run()
 		// log.Printf("[wa_queue] job #%d completed", seq)
 
-		if state.State.Config.WhatsApp.QueueEnabled {
+		if state.State.Config().WhatsApp.QueueEnabled {
 			// Read interval from config on every tick so config changes take effect.
-			interval := time.Duration(state.State.Config.WhatsApp.QueueIntervalMs) * time.Millisecond
+			interval := time.Duration(state.State.Config().WhatsApp.QueueIntervalMs) * time.Millisecond
 			if interval > 0 {
 				// log.Printf("[wa_queue] throttling %v before next job", interval)
 				time.Sleep(interval)
@@ -73,12 +132,12 @@ func tgWorker() {
 		middlewares.WaitTelegramRateLimit()
 
 		// log.Printf("[tg_queue] job #%d dispatching", seq)
-		job()
+		job.run()
 		// log.Printf("[tg_queue] job #%d completed", seq)
 
-		if state.State.Config.Telegram.QueueEnabled {
+		if state.State.Config().Telegram.QueueEnabled {
 			// Read interval from config on every tick so config changes take effect.
-			interval := time.Duration(state.State.Config.Telegram.QueueIntervalMs) * time.Millisecond
+			interval := time.Duration(state.State.Config().Telegram.QueueIntervalMs) * time.Millisecond
 			if interval > 0 {
 				// log.Printf("[tg_queue] throttling %v before next job", interval)
 				time.Sleep(interval)
@@ -88,7 +147,8 @@ func tgWorker() {
 }
 
 // WaSend enqueues a WhatsApp send through the rate-limited queue.
-// It blocks until the message has been sent and returns the result.
+// It blocks until the message has been sent and returns the result, or until
+// send_timeout_seconds elapses, in which case ErrWaSendTimeout is returned.
 // Use this everywhere instead of waClient.SendMessage directly.
 func WaSend(ctx context.Context, jid waTypes.JID, msg *waE2E.Message) (whatsmeow.SendResponse, error) {
 	type result struct {
@@ -96,23 +156,58 @@ func WaSend(ctx context.Context, jid waTypes.JID, msg *waE2E.Message) (whatsmeow
 		e error
 	}
 	ch := make(chan result, 1)
+
+	// A nil channel never fires, so a zero timeout waits indefinitely
+	var timeoutCh <-chan time.Time
+	if timeout := time.Duration(state.State.Config().WhatsApp.SendTimeoutSeconds) * time.Second; timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	if !workersStarted.Load() {
+		return whatsmeow.SendResponse{}, ErrWorkersNotStarted
+	}
+	if waIsPaused() {
+		return whatsmeow.SendResponse{}, ErrWaAccountBanned
+	}
+
 	// qDepth := len(waJobCh)
 	// log.Printf("[wa_queue] enqueuing send to %s (queue depth before enqueue: %d/%d)", jid.String(), qDepth, QueueSize)
-	waJobCh <- func() {
-		r, e := state.State.WhatsAppClient.SendMessage(ctx, jid, msg)
-		ch <- result{r, e}
+	job := queueJob{
+		run: func() {
+			r, e := state.State.WhatsAppClient.SendMessage(ctx, jid, msg)
+			if e == nil {
+				waRememberSentId(r.ID)
+			}
+			ch <- result{r, e}
+		},
+		drop: func() { ch <- result{e: ErrQueueFull} },
+	}
+	if err := enqueue("wa_queue", waJobCh, job, state.State.Config().WhatsApp.QueueOverflowPolicy, timeoutCh); err == errEnqueueTimedOut {
+		log.Printf("[wa_queue] timed out enqueuing send to %s, queue is full", jid.String())
+		return whatsmeow.SendResponse{}, ErrWaSendTimeout
+	} else if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+
+	select {
+	case res := <-ch:
+		// if res.e != nil {
+		// 	log.Printf("[wa_queue] send to %s failed: %v", jid.String(), res.e)
+		// } else {
+		// 	log.Printf("[wa_queue] send to %s succeeded (msgID: %s)", jid.String(), res.r.ID)
+		// }
+		return res.r, classifyWaSendError(res.e)
+	case <-timeoutCh:
+		log.Printf("[wa_queue] timed out waiting for send to %s, the message may still be sent later", jid.String())
+		return whatsmeow.SendResponse{}, ErrWaSendTimeout
 	}
-	res := <-ch
-	// if res.e != nil {
-	// 	log.Printf("[wa_queue] send to %s failed: %v", jid.String(), res.e)
-	// } else {
-	// 	log.Printf("[wa_queue] send to %s succeeded (msgID: %s)", jid.String(), res.r.ID)
-	// }
-	return res.r, res.e
 }
 
 // TgRun enqueues any Telegram API call through the rate-limited queue.
-// It blocks until the call completes and returns the result.
+// It blocks until the call completes and returns the result, or returns
+// ErrWorkersNotStarted right away if StartWorkers hasn't been called yet.
 // Use this everywhere instead of calling bot.SendMessage / SendPhoto / etc. directly.
 //
 // Example:
@@ -125,12 +220,27 @@ func TgRun[T any](fn func() (T, error)) (T, error) {
 		v T
 		e error
 	}
+	if !workersStarted.Load() {
+		var zero T
+		return zero, ErrWorkersNotStarted
+	}
 	ch := make(chan result, 1)
 	// qDepth := len(tgJobCh)
 	// log.Printf("[tg_queue] enqueuing job (queue depth before enqueue: %d/%d)", qDepth, QueueSize)
-	tgJobCh <- func() {
-		v, e := fn()
-		ch <- result{v, e}
+	job := queueJob{
+		run: func() {
+			v, e := fn()
+			tgRecordResult(e)
+			ch <- result{v, e}
+		},
+		drop: func() {
+			var zero T
+			ch <- result{zero, ErrQueueFull}
+		},
+	}
+	if err := enqueue("tg_queue", tgJobCh, job, state.State.Config().Telegram.QueueOverflowPolicy, nil); err != nil {
+		var zero T
+		return zero, err
 	}
 	res := <-ch
 	return res.v, res.e
@@ -146,6 +256,15 @@ func TgReopenForumTopic(b *gotgbot.Bot, chatId int64, threadId int64, opts *gotg
 	return TgRun(func() (bool, error) { return b.ReopenForumTopic(chatId, threadId, opts) })
 }
 
+// TgCloseForumTopic enqueues a Telegram CloseForumTopic call through the rate-limited queue.
+func TgCloseForumTopic(b *gotgbot.Bot, chatId int64, threadId int64, opts *gotgbot.CloseForumTopicOpts) (bool, error) {
+	return TgRun(func() (bool, error) { return b.CloseForumTopic(chatId, threadId, opts) })
+}
+
+func TgDeleteForumTopic(b *gotgbot.Bot, chatId int64, threadId int64, opts *gotgbot.DeleteForumTopicOpts) (bool, error) {
+	return TgRun(func() (bool, error) { return b.DeleteForumTopic(chatId, threadId, opts) })
+}
+
 func TgOpenForumTopic(b *gotgbot.Bot, chatId int64, name string, opts *gotgbot.CreateForumTopicOpts) (*gotgbot.ForumTopic, error) {
 	return TgRun(func() (*gotgbot.ForumTopic, error) { return b.CreateForumTopic(chatId, name, opts) })
 }
@@ -154,8 +273,33 @@ func TgEditForumTopic(b *gotgbot.Bot, chatId int64, threadId int64, opts *gotgbo
 	return TgRun(func() (bool, error) { return b.EditForumTopic(chatId, threadId, opts) })
 }
 
+// TgSendMessage sends a text message through the queue. While Telegram is
+// unreachable the message is written to the replay buffer, if configured, and
+// ErrTelegramUnreachable is returned.
 func TgSendMessage(b *gotgbot.Bot, chatId int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
-	return TgRun(func() (*gotgbot.Message, error) { return b.SendMessage(chatId, text, opts) })
+	return tgSendMessage(b, chatId, text, opts, nil)
+}
+
+// TgSendBridgedMessage sends a text message bridged from a WhatsApp message
+// like TgSendMessage, and stores the pair between both messages once it is
+// sent, which may only be when it is replayed.
+func TgSendBridgedMessage(b *gotgbot.Bot, chatId int64, text string, opts *gotgbot.SendMessageOpts, origin WaOrigin) (*gotgbot.Message, error) {
+	msg, err := tgSendMessage(b, chatId, text, opts, &origin)
+	if err == nil && msg != nil && msg.MessageId != 0 {
+		origin.addPair(msg)
+	}
+	return msg, err
+}
+
+func tgSendMessage(b *gotgbot.Bot, chatId int64, text string, opts *gotgbot.SendMessageOpts, origin *WaOrigin) (*gotgbot.Message, error) {
+	if tgShouldBuffer() && bufferTgMessage(chatId, text, opts, origin) {
+		return nil, ErrTelegramUnreachable
+	}
+	msg, err := TgRun(func() (*gotgbot.Message, error) { return b.SendMessage(chatId, text, opts) })
+	if err != nil && tgOutageActive() && bufferTgMessage(chatId, text, opts, origin) {
+		return nil, ErrTelegramUnreachable
+	}
+	return msg, err
 }
 
 func TgSendPhoto(b *gotgbot.Bot, chatId int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error) {
@@ -194,6 +338,17 @@ func TgSendContact(b *gotgbot.Bot, chatId int64, phoneNumber string, firstName s
 	return TgRun(func() (*gotgbot.Message, error) { return b.SendContact(chatId, phoneNumber, firstName, opts) })
 }
 
+func TgEditMessageText(b *gotgbot.Bot, text string, opts *gotgbot.EditMessageTextOpts) (*gotgbot.Message, error) {
+	return TgRun(func() (*gotgbot.Message, error) {
+		msg, _, err := b.EditMessageText(text, opts)
+		return msg, err
+	})
+}
+
+func TgDeleteMessages(b *gotgbot.Bot, chatId int64, messageIds []int64, opts *gotgbot.DeleteMessagesOpts) (bool, error) {
+	return TgRun(func() (bool, error) { return b.DeleteMessages(chatId, messageIds, opts) })
+}
+
 func TgPinChatMessage(b *gotgbot.Bot, chatId int64, messageId int64, opts *gotgbot.PinChatMessageOpts) (bool, error) {
 	return TgRun(func() (bool, error) { return b.PinChatMessage(chatId, messageId, opts) })
 }
@@ -209,3 +364,7 @@ func TgSendLocation(b *gotgbot.Bot, chatId int64, latitude float64, longitude fl
 func TgForwardMessage(b *gotgbot.Bot, chatId int64, fromChatId int64, messageId int64, opts *gotgbot.ForwardMessageOpts) (*gotgbot.Message, error) {
 	return TgRun(func() (*gotgbot.Message, error) { return b.ForwardMessage(chatId, fromChatId, messageId, opts) })
 }
+
+func TgSetMessageReaction(b *gotgbot.Bot, chatId int64, messageId int64, opts *gotgbot.SetMessageReactionOpts) (bool, error) {
+	return TgRun(func() (bool, error) { return b.SetMessageReaction(chatId, messageId, opts) })
+}
@@ -0,0 +1,317 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// Replay buffer for Telegram text messages sent while Telegram is unreachable.
+// After tgOutageThreshold consecutive calls fail without any response from the
+// Bot API, TgSendMessage appends messages to a file instead of dropping them.
+// They are replayed in order through the regular queue once Telegram answers
+// again. Media sends are not buffered because their files only live in memory.
+// Messages bridged from WhatsApp keep their origin, so that the pair between
+// both messages is stored once they are replayed.
+//
+// When replay_buffer_flush_interval_ms is set, buffered messages are kept in
+// memory and appended to the file together every interval, or as soon as
+// replay_buffer_flush_batch_size of them are waiting, instead of one write per
+// message. Messages not yet written are lost if the bridge crashes.
+
+const (
+	tgOutageThreshold   = 5
+	replayProbeInterval = 30 * time.Second
+)
+
+// ErrTelegramUnreachable is returned by TgSendMessage when the message was
+// written to the replay buffer instead of being sent.
+var ErrTelegramUnreachable = errors.New("telegram is unreachable, message buffered for replay")
+
+var (
+	tgConsecutiveFailures atomic.Int64
+
+	replayMu    sync.Mutex
+	replayCount int // Including the messages not yet written
+
+	replayUnflushed  [][]byte // Encoded entries waiting to be written
+	replayFlushTimer *time.Timer
+)
+
+type replayEntry struct {
+	ChatId    int64     `json:"chat_id"`
+	ThreadId  int64     `json:"thread_id,omitempty"`
+	ReplyToId int64     `json:"reply_to_id,omitempty"`
+	Text      string    `json:"text"`
+	Origin    *WaOrigin `json:"origin,omitempty"`
+}
+
+// WaOrigin is the WhatsApp message a Telegram message is bridged from. It is
+// kept with the message in the replay buffer so that the pair can be stored
+// when it is replayed.
+type WaOrigin struct {
+	MsgId         string `json:"wa_msg_id"`
+	ParticipantId string `json:"wa_participant_id"`
+	ChatId        string `json:"wa_chat_id"`
+	Content       string `json:"content,omitempty"`
+}
+
+func (origin WaOrigin) addPair(msg *gotgbot.Message) {
+	if err := database.MsgIdAddNewPair(origin.MsgId, origin.ParticipantId, origin.ChatId,
+		msg.Chat.Id, msg.MessageId, msg.MessageThreadId, origin.Content); err != nil {
+		log.Printf("[replay] failed to store the pair of a bridged message: %v", err)
+	}
+}
+
+// tgRecordResult tracks consecutive failures that didn't get any response from
+// the Bot API. Errors returned by Telegram itself mean it is reachable.
+func tgRecordResult(err error) {
+	var tgErr *gotgbot.TelegramError
+	if err == nil || errors.As(err, &tgErr) {
+		tgConsecutiveFailures.Store(0)
+		return
+	}
+	if n := tgConsecutiveFailures.Add(1); n == tgOutageThreshold {
+		log.Printf("[replay] %d consecutive Telegram calls failed, buffering messages until it recovers", n)
+	}
+}
+
+func tgOutageActive() bool {
+	return tgConsecutiveFailures.Load() >= tgOutageThreshold
+}
+
+// tgShouldBuffer reports whether new messages must go to the replay buffer,
+// either because of an outage or to keep them behind older buffered messages.
+func tgShouldBuffer() bool {
+	if state.State.Config().Telegram.ReplayBufferPath == "" {
+		return false
+	}
+	replayMu.Lock()
+	pending := replayCount
+	replayMu.Unlock()
+	return pending > 0 || tgOutageActive()
+}
+
+// bufferTgMessage appends a message to the replay buffer and reports whether it was stored.
+func bufferTgMessage(chatId int64, text string, opts *gotgbot.SendMessageOpts, origin *WaOrigin) bool {
+	cfg := state.State.Config()
+	if cfg.Telegram.ReplayBufferPath == "" {
+		return false
+	}
+
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	if replayCount >= cfg.Telegram.ReplayBufferSize {
+		log.Printf("[replay] buffer is full (%d messages), dropping message to chat %d", replayCount, chatId)
+		return false
+	}
+
+	entry := replayEntry{ChatId: chatId, Text: text, Origin: origin}
+	if opts != nil {
+		entry.ThreadId = opts.MessageThreadId
+		if opts.ReplyParameters != nil {
+			entry.ReplyToId = opts.ReplyParameters.MessageId
+		}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return false
+	}
+
+	if cfg.Telegram.ReplayBufferFlushIntervalMs <= 0 {
+		if err := appendReplayBuffer(cfg.Telegram.ReplayBufferPath, [][]byte{line}); err != nil {
+			log.Printf("[replay] failed to write to buffer file: %v", err)
+			return false
+		}
+		replayCount++
+		return true
+	}
+
+	replayUnflushed = append(replayUnflushed, line)
+	replayCount++
+	if len(replayUnflushed) >= cfg.Telegram.ReplayBufferFlushBatchSize {
+		flushReplayBufferLocked()
+	} else if replayFlushTimer == nil {
+		interval := time.Duration(cfg.Telegram.ReplayBufferFlushIntervalMs) * time.Millisecond
+		replayFlushTimer = time.AfterFunc(interval, func() { FlushReplayBuffer() })
+	}
+	return true
+}
+
+func appendReplayBuffer(path string, lines [][]byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	return writer.Flush()
+}
+
+// FlushReplayBuffer writes the buffered messages still held in memory to the
+// replay buffer file. It must be called before shutting down so that they
+// are not lost.
+func FlushReplayBuffer() {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	flushReplayBufferLocked()
+}
+
+func flushReplayBufferLocked() {
+	if replayFlushTimer != nil {
+		replayFlushTimer.Stop()
+		replayFlushTimer = nil
+	}
+	if len(replayUnflushed) == 0 {
+		return
+	}
+
+	// Kept in memory on failure and written with the next batch
+	if err := appendReplayBuffer(state.State.Config().Telegram.ReplayBufferPath, replayUnflushed); err != nil {
+		log.Printf("[replay] failed to write %d messages to buffer file: %v", len(replayUnflushed), err)
+		return
+	}
+	replayUnflushed = nil
+}
+
+func readReplayBuffer(path string) ([]replayEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []replayEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry replayEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func writeReplayBuffer(path string, entries []replayEntry) error {
+	if len(entries) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, entry := range entries {
+		line, _ := json.Marshal(entry)
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayBufferedMessages sends all buffered messages in order, stopping at the
+// first call that fails to reach Telegram and keeping the rest for later.
+func replayBufferedMessages(b *gotgbot.Bot) {
+	path := state.State.Config().Telegram.ReplayBufferPath
+
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	flushReplayBufferLocked()
+	if len(replayUnflushed) > 0 {
+		return
+	}
+
+	entries, err := readReplayBuffer(path)
+	if err != nil {
+		log.Printf("[replay] failed to read buffer file: %v", err)
+		return
+	}
+
+	sent := 0
+	for ; sent < len(entries); sent++ {
+		entry := entries[sent]
+		opts := &gotgbot.SendMessageOpts{MessageThreadId: entry.ThreadId}
+		if entry.ReplyToId != 0 {
+			opts.ReplyParameters = &gotgbot.ReplyParameters{MessageId: entry.ReplyToId}
+		}
+
+		msg, err := TgRun(func() (*gotgbot.Message, error) { return b.SendMessage(entry.ChatId, entry.Text, opts) })
+		var tgErr *gotgbot.TelegramError
+		if err != nil && !errors.As(err, &tgErr) {
+			break
+		} else if err != nil {
+			log.Printf("[replay] dropping buffered message rejected by Telegram: %v", err)
+		} else if entry.Origin != nil && msg != nil {
+			entry.Origin.addPair(msg)
+		}
+	}
+
+	if err := writeReplayBuffer(path, entries[sent:]); err != nil {
+		log.Printf("[replay] failed to update buffer file: %v", err)
+	}
+	replayCount = len(entries) - sent
+	log.Printf("[replay] replayed %d buffered messages (%d remaining)", sent, replayCount)
+}
+
+// startReplayWorker replays messages left over from a previous run and then
+// keeps probing Telegram while messages are buffered.
+func startReplayWorker() {
+	cfg := state.State.Config()
+	if cfg.Telegram.ReplayBufferPath == "" {
+		return
+	}
+
+	entries, err := readReplayBuffer(cfg.Telegram.ReplayBufferPath)
+	if err != nil {
+		log.Printf("[replay] failed to read buffer file: %v", err)
+	}
+	replayMu.Lock()
+	replayCount = len(entries)
+	replayMu.Unlock()
+
+	go func() {
+		for {
+			time.Sleep(replayProbeInterval)
+
+			b := state.State.TelegramBot
+			replayMu.Lock()
+			pending := replayCount
+			replayMu.Unlock()
+			if b == nil || pending == 0 {
+				continue
+			}
+
+			if _, err := TgRun(func() (*gotgbot.User, error) { return b.GetMe(nil) }); err != nil {
+				continue
+			}
+			replayBufferedMessages(b)
+		}
+	}()
+}
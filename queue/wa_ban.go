@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Errors returned by WaSend for failures that retrying won't fix. They wrap
+// the original error, so errors.Is works for both.
+var (
+	ErrWaAccountBanned      = errors.New("the WhatsApp account is temporarily banned, sending is paused")
+	ErrWaAccountRestricted  = errors.New("WhatsApp doesn't allow the account to message this chat right now")
+	ErrWaRecipientForbidden = errors.New("WhatsApp refused the message, the recipient may have blocked you")
+)
+
+var (
+	waPauseMu    sync.Mutex
+	waPaused     bool
+	waPauseTimer *time.Timer
+)
+
+// WaPause makes WaSend fail with ErrWaAccountBanned instead of sending, so
+// that a banned account isn't made worse by more attempts. A zero duration
+// pauses until WaResume is called.
+func WaPause(duration time.Duration) {
+	waPauseMu.Lock()
+	defer waPauseMu.Unlock()
+
+	waPaused = true
+	if waPauseTimer != nil {
+		waPauseTimer.Stop()
+		waPauseTimer = nil
+	}
+	if duration > 0 {
+		waPauseTimer = time.AfterFunc(duration, WaResume)
+	}
+	log.Printf("[wa_queue] sending paused (duration: %v)", duration)
+}
+
+// WaResume undoes WaPause.
+func WaResume() {
+	waPauseMu.Lock()
+	defer waPauseMu.Unlock()
+
+	if !waPaused {
+		return
+	}
+	waPaused = false
+	if waPauseTimer != nil {
+		waPauseTimer.Stop()
+		waPauseTimer = nil
+	}
+	log.Printf("[wa_queue] sending resumed")
+}
+
+func waIsPaused() bool {
+	waPauseMu.Lock()
+	defer waPauseMu.Unlock()
+	return waPaused
+}
+
+// classifyWaSendError wraps errors of failed sends that are caused by the
+// account or the recipient rather than the connection.
+func classifyWaSendError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, whatsmeow.ErrIQForbidden) {
+		return fmt.Errorf("%w: %w", ErrWaRecipientForbidden, err)
+	}
+
+	if errors.Is(err, whatsmeow.ErrServerReturnedError) {
+		switch {
+		case strings.HasSuffix(err.Error(), " 403"):
+			return fmt.Errorf("%w: %w", ErrWaRecipientForbidden, err)
+		case strings.HasSuffix(err.Error(), " 463"):
+			return fmt.Errorf("%w: %w", ErrWaAccountRestricted, err)
+		}
+	}
+
+	return err
+}
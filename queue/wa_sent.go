@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// IDs of the messages sent through WaSend are remembered for a while, so that
+// the copies WhatsApp syncs back to the account's devices are recognised as
+// sent by the bridge and not bridged to Telegram a second time.
+
+const waSentIdTTL = 10 * time.Minute
+
+var (
+	waSentIdsMu sync.Mutex
+	waSentIds   = make(map[string]time.Time) // Message ID -> time it was sent
+)
+
+func waRememberSentId(msgId string) {
+	if msgId == "" {
+		return
+	}
+
+	waSentIdsMu.Lock()
+	defer waSentIdsMu.Unlock()
+
+	now := time.Now()
+	for id, sentAt := range waSentIds {
+		if now.Sub(sentAt) > waSentIdTTL {
+			delete(waSentIds, id)
+		}
+	}
+	waSentIds[msgId] = now
+}
+
+// WaSentByBridge reports whether the message was sent by the bridge in the
+// last few minutes.
+func WaSentByBridge(msgId string) bool {
+	waSentIdsMu.Lock()
+	defer waSentIdsMu.Unlock()
+
+	sentAt, found := waSentIds[msgId]
+	return found && time.Since(sentAt) <= waSentIdTTL
+}
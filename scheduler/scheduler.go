@@ -1,7 +1,10 @@
 package scheduler
 
 import (
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"watgbridge/database"
@@ -13,6 +16,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// cleanupMutex keeps the scheduled runs and the ones started using /cleanup
+// from touching the database at the same time.
+var cleanupMutex sync.Mutex
+
 // StartTopicCleanupScheduler launches a background goroutine that runs
 // cleanupDeletedTopics and then waits for the configured interval before
 // running again. Using a post-completion delay (rather than a fixed clock
@@ -31,40 +38,78 @@ func StartTopicCleanupScheduler() {
 // StartMsgCleanUpScheduler registers a periodic cron job to clean up old messages.
 func StartMsgCleanUpScheduler(s *gocron.Scheduler) {
 	const intervalMins = 1440 // adjust as needed
-	_, _ = s.Every(intervalMins).Minutes().Tag("msg_cleanup").Do(CleanUpMsg)
+	_, _ = s.Every(intervalMins).Minutes().Tag("msg_cleanup").Do(func() { CleanUpMsg() })
+}
+
+// CleanupResult holds the number of rows removed by RunCleanup from each table.
+type CleanupResult struct {
+	ChatThreadPairs int64
+	MsgIdPairs      int64
+}
+
+// RunCleanup runs both cleanup jobs right away instead of waiting for their
+// next scheduled run. If one of them fails the other still runs, and the
+// result holds the rows removed by both.
+func RunCleanup() (CleanupResult, error) {
+	var result CleanupResult
+
+	topics, msgPairs, topicsErr := cleanupDeletedTopics()
+	result.ChatThreadPairs = topics
+	result.MsgIdPairs = msgPairs
+
+	orphaned, msgErr := CleanUpMsg()
+	result.MsgIdPairs += orphaned
+
+	return result, errors.Join(topicsErr, msgErr)
 }
 
 // Clean up message that doesn't has a topic (thread) associated with it anymore, which means the topic has been deleted and the msg_id_pairs entry is orphaned. This can happen when a Telegram topic is deleted but the scheduler hasn't run yet to clean up the database, or if there was an error during cleanup.
-func CleanUpMsg() {
+func CleanUpMsg() (int64, error) {
+	cleanupMutex.Lock()
+	defer cleanupMutex.Unlock()
+
 	db := state.State.Database
-	// This query works for MySQL and PostgreSQL. For SQLite, the syntax is different.
-	sql := `DELETE a FROM msg_id_pairs AS a LEFT JOIN chat_thread_pairs AS b ON a.tg_thread_id = b.tg_thread_id WHERE b.tg_thread_id IS NULL;`
-	if db != nil {
-		result := db.Exec(sql)
-		logger := state.State.Logger
-		if result.Error != nil {
-			if logger != nil {
-				logger.Error("[scheduler] failed to clean up orphaned msg_id_pairs", zap.Error(result.Error))
-			}
-		} else {
-			if logger != nil {
-				logger.Info("[scheduler] cleaned up orphaned msg_id_pairs", zap.Int64("rows_affected", result.RowsAffected))
-			}
+	// NOT EXISTS works the same on SQLite, PostgreSQL and MySQL. Messages in
+	// the chat itself or its General topic never have a chat pair to match.
+	sql := `DELETE FROM msg_id_pairs
+		WHERE tg_thread_id <> 0 AND tg_thread_id <> ?
+		AND NOT EXISTS (
+			SELECT 1 FROM chat_thread_pairs b
+			WHERE b.tg_chat_id = msg_id_pairs.tg_chat_id AND b.tg_thread_id = msg_id_pairs.tg_thread_id
+		)`
+	if db == nil {
+		return 0, nil
+	}
+
+	result := db.Exec(sql, state.State.Config().Telegram.GeneralTopicThreadId)
+	logger := state.State.Logger
+	if result.Error != nil {
+		if logger != nil {
+			logger.Error("[scheduler] failed to clean up orphaned msg_id_pairs", zap.Error(result.Error))
 		}
+		return 0, result.Error
+	}
+	if logger != nil {
+		logger.Info("[scheduler] cleaned up orphaned msg_id_pairs", zap.Int64("rows_affected", result.RowsAffected))
 	}
+	return result.RowsAffected, nil
 }
 
 // cleanupDeletedTopics is the actual cleanup function executed by the scheduler.
-func cleanupDeletedTopics() {
-	cfg := state.State.Config
+// It returns the number of chat_thread_pairs and msg_id_pairs rows removed,
+// and an error if some topics couldn't be checked or cleaned up.
+func cleanupDeletedTopics() (topicsRemoved, msgPairsRemoved int64, err error) {
+	cleanupMutex.Lock()
+	defer cleanupMutex.Unlock()
+
+	cfg := state.State.Config()
 	bot := state.State.TelegramBot
 	logger := state.State.Logger
 	if bot == nil {
 		return
 	}
 
-	err := utils.WaSyncContacts()
-	if err != nil && logger != nil {
+	if err := utils.WaSyncContacts(); err != nil && logger != nil {
 		logger.Error("[scheduler] failed to sync WhatsApp contacts", zap.Error(err))
 	}
 
@@ -75,56 +120,91 @@ func cleanupDeletedTopics() {
 		logger.Error("[scheduler] failed to fetch chat_thread_pairs for topic cleanup",
 			zap.Error(err),
 		)
-		return
+		return 0, 0, fmt.Errorf("failed to fetch the chat pairs : %s", err)
 	}
 
+	// Per-topic details are logged at debug level, with a single summary per run
+	var checked, errored int
+	defer func() {
+		logger.Info("[scheduler] topic cleanup finished",
+			zap.Int("checked", checked),
+			zap.Int64("deleted", topicsRemoved),
+			zap.Int("errors", errored),
+		)
+		if errored > 0 {
+			err = fmt.Errorf("failed to check or clean up %d of %d topics, see the logs", errored, checked)
+		}
+	}()
+
 	for _, pair := range pairs {
 		threadId := pair.TgThreadId
 
-		// Skip the "General" topic (thread ID 0 or 1) – those can never be deleted.
-		if threadId <= 1 {
+		// Skip the "General" topic – it can never be deleted.
+		if utils.TgIsGeneralThread(threadId) {
 			continue
 		}
+		checked++
 
 		// Probe Telegram: try to reopen the forum topic using the queue wrapper.
 		// - nil error or "TOPIC_NOT_MODIFIED" (already open) → topic still exists.
 		// - error containing "TOPIC_NOT_FOUND", "TOPIC_ID_INVALID", "MESSAGE_THREAD_INVALID" → topic has been deleted.
-		_, probeErr := queue.TgReopenForumTopic(bot, tgChatId, threadId, nil)
-		if probeErr == nil || !isTopicNotFound(probeErr) {
+		// Topics closed with /close are probed by closing them instead so they stay closed.
+		var probeErr error
+		if pair.ManuallyClosed {
+			_, probeErr = queue.TgCloseForumTopic(bot, tgChatId, threadId, nil)
+		} else {
+			_, probeErr = queue.TgReopenForumTopic(bot, tgChatId, threadId, nil)
+		}
+		if probeErr == nil || !IsTopicNotFound(probeErr) {
 			// Topic is still alive;
-			if isTopicNotModified(probeErr) {
+			if IsTopicNotModified(probeErr) {
 				utils.SyncTopicNameByChatThreadPair(bot, tgChatId, pair)
+			} else if probeErr != nil {
+				errored++
 			}
+			logger.Debug("[scheduler] Telegram topic still exists",
+				zap.Int64("tg_thread_id", threadId),
+				zap.String("wa_chat_id", pair.ID),
+				zap.Error(probeErr),
+			)
 			continue
 		}
 
-		logger.Info("[scheduler] detected deleted Telegram topic, cleaning up",
+		logger.Debug("[scheduler] detected deleted Telegram topic, cleaning up",
 			zap.Int64("tg_chat_id", tgChatId),
 			zap.Int64("tg_thread_id", threadId),
 			zap.String("wa_chat_id", pair.ID),
 		)
 
 		// Remove all msg_id_pairs rows belonging to this thread.
-		if err := database.MsgIdDeletePairsByThreadId(tgChatId, threadId); err != nil {
+		removed, err := database.MsgIdDeletePairsByThreadId(tgChatId, threadId)
+		if err != nil {
+			errored++
 			logger.Error("[scheduler] failed to delete msg_id_pairs for deleted topic",
 				zap.Int64("tg_thread_id", threadId),
 				zap.Error(err),
 			)
 		}
+		msgPairsRemoved += removed
 
 		// Remove the chat_thread_pairs row itself.
 		if err := database.ChatThreadDropPairByTg(tgChatId, threadId); err != nil {
+			errored++
 			logger.Error("[scheduler] failed to delete chat_thread_pairs for deleted topic",
 				zap.Int64("tg_thread_id", threadId),
 				zap.Error(err),
 			)
+		} else {
+			topicsRemoved++
 		}
 	}
+
+	return
 }
 
-// isTopicNotFound returns true if the Telegram API error indicates that the
+// IsTopicNotFound returns true if the Telegram API error indicates that the
 // forum topic no longer exists.
-func isTopicNotFound(err error) bool {
+func IsTopicNotFound(err error) bool {
 	if err == nil {
 		return false
 	}
@@ -132,7 +212,9 @@ func isTopicNotFound(err error) bool {
 	return strings.Contains(msg, "TOPIC_NOT_FOUND") || strings.Contains(msg, "TOPIC_ID_INVALID") || strings.Contains(msg, "MESSAGE_THREAD_INVALID")
 }
 
-func isTopicNotModified(err error) bool {
+// IsTopicNotModified returns true if the Telegram API error indicates that the
+// forum topic exists but was already in the requested state.
+func IsTopicNotModified(err error) bool {
 	if err == nil {
 		return false
 	}
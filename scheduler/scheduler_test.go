@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"testing"
+
+	"watgbridge/database"
+	"watgbridge/internal/testutil"
+	"watgbridge/state"
+)
+
+func TestCleanUpMsg(t *testing.T) {
+	const (
+		tgChatId    = int64(-1001234567890)
+		otherChatId = int64(-1009876543210)
+		waChatId    = "919876543210@s.whatsapp.net"
+	)
+	testutil.Setup(t, func(cfg *state.Config) {
+		cfg.Telegram.TargetChatID = tgChatId
+		cfg.Telegram.GeneralTopicThreadId = 1
+	})
+
+	if err := database.ChatThreadAddNewPair(waChatId, tgChatId, 7); err != nil {
+		t.Fatalf("failed to add the chat pair: %v", err)
+	}
+
+	pairs := []struct {
+		waMsgId    string
+		tgChatId   int64
+		tgThreadId int64
+		kept       bool
+	}{
+		{"3EB0000000000000000A", tgChatId, 7, true},
+		// The topic was deleted
+		{"3EB0000000000000000B", tgChatId, 8, false},
+		// Same thread ID, but in a chat that has no such topic
+		{"3EB0000000000000000C", otherChatId, 7, false},
+		{"3EB0000000000000000D", tgChatId, 0, true},
+		{"3EB0000000000000000E", tgChatId, 1, true},
+	}
+	for i, pair := range pairs {
+		if err := database.MsgIdAddNewPair(pair.waMsgId, waChatId, waChatId,
+			pair.tgChatId, int64(i+1), pair.tgThreadId, ""); err != nil {
+			t.Fatalf("failed to add the message pair: %v", err)
+		}
+	}
+
+	removed, err := CleanUpMsg()
+	if err != nil {
+		t.Fatalf("CleanUpMsg() failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("CleanUpMsg() removed %d pairs, want 2", removed)
+	}
+
+	for _, pair := range pairs {
+		var count int64
+		state.State.Database.Table("msg_id_pairs").Where("id = ?", pair.waMsgId).Count(&count)
+		if kept := count == 1; kept != pair.kept {
+			t.Errorf("pair %s kept = %v, want %v", pair.waMsgId, kept, pair.kept)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,63 +19,179 @@ type Config struct {
 	FfmpegExecutable string `yaml:"ffmpeg_executable"`
 	DebugMode        bool   `yaml:"debug_mode"`
 
+	StreamingThresholdMB int  `yaml:"streaming_threshold_mb"`
+	BridgeWaToTg         bool `yaml:"bridge_wa_to_tg"`
+	BridgeTgToWa         bool `yaml:"bridge_tg_to_wa"`
+
+	DownloadRetries        int `yaml:"download_retries"`
+	DownloadTimeoutSeconds int `yaml:"download_timeout_seconds"`
+	DownloadMaxSizeMB      int `yaml:"download_max_size_mb"`
+
+	RetryJitterFactor float64 `yaml:"retry_jitter_factor"`
+
+	MsgPairBatchIntervalMs int  `yaml:"msg_pair_batch_interval_ms"`
+	MsgPairCacheSize       int  `yaml:"msg_pair_cache_size"`
+	MsgPairCacheTTLSeconds int  `yaml:"msg_pair_cache_ttl_seconds"`
+	MsgPairContentHash     bool `yaml:"msg_pair_content_hash"`
+	MsgPairSnippetLength   int  `yaml:"msg_pair_snippet_length"`
+
+	ChatThreadCacheTTLSeconds int `yaml:"chat_thread_cache_ttl_seconds"`
+
+	MaxFormattingLength   int `yaml:"max_formatting_length"`
+	MaxFormattingEntities int `yaml:"max_formatting_entities"`
+
+	Media struct {
+		VoiceNoteBitrate  string `yaml:"voice_note_bitrate"`
+		VideoPreset       string `yaml:"video_preset"`
+		VideoCRF          int    `yaml:"video_crf"`
+		VideoAudioBitrate string `yaml:"video_audio_bitrate"`
+		ImageMaxDimension int    `yaml:"image_max_dimension"`
+		ImageQuality      int    `yaml:"image_quality"`
+		StickerQuality    int    `yaml:"sticker_quality"`
+	} `yaml:"media"`
+
+	Translation struct {
+		Provider  string            `yaml:"provider"`
+		URL       string            `yaml:"url"`
+		APIKey    string            `yaml:"api_key"`
+		TimeoutMs int               `yaml:"timeout_ms"`
+		Mode      string            `yaml:"mode"`
+		Chats     map[string]string `yaml:"chats"`
+	} `yaml:"translation"`
+
 	UseGithHubBinaries bool   `yaml:"use_github_binaries"`
 	Architecture       string `yaml:"architecture"`
 
-	   Telegram struct {
-		BotToken            string  `yaml:"bot_token"`
-		APIURL              string  `yaml:"api_url"`
-		SudoUsersID         []int64 `yaml:"sudo_users_id"`
-		OwnerID             int64   `yaml:"owner_id"`
-		TargetChatID        int64   `yaml:"target_chat_id"`
-		SelfHostedAPI       bool    `yaml:"self_hosted_api"`
-		SkipVideoStickers   bool    `yaml:"skip_video_stickers"`
-		SkipSettingCommands bool    `yaml:"skip_setting_commands"`
-		SendMyPresence      bool    `yaml:"send_my_presence"`
-		SendMyReadReceipts  bool    `yaml:"send_my_read_receipts"`
-		SilentConfirmation  bool    `yaml:"silent_confirmation"`
-		ConfirmationType    string  `yaml:"confirmation_type"`
-		EmojiConfirmation   *bool   `yaml:"emoji_confirmation"`
-		SkipStartupMessage  bool    `yaml:"skip_startup_message"`
-		SpoilerViewOnce     bool    `yaml:"spoiler_as_viewonce"`
-		Reactions           bool    `yaml:"reactions"`
-		QueueEnabled        bool    `yaml:"queue_enabled"`
-		QueueIntervalMs     int     `yaml:"queue_interval_ms"`
+	Telegram struct {
+		BotToken                    string            `yaml:"bot_token"`
+		APIURL                      string            `yaml:"api_url"`
+		SudoUsersID                 []int64           `yaml:"sudo_users_id"`
+		OwnerID                     int64             `yaml:"owner_id"`
+		TargetChatID                int64             `yaml:"target_chat_id"`
+		SelfHostedAPI               bool              `yaml:"self_hosted_api"`
+		SkipVideoStickers           bool              `yaml:"skip_video_stickers"`
+		SkipSettingCommands         bool              `yaml:"skip_setting_commands"`
+		SendMyPresence              bool              `yaml:"send_my_presence"`
+		PresenceDurationSeconds     int               `yaml:"presence_duration_seconds"`
+		SendMyTyping                bool              `yaml:"send_my_typing"`
+		PresenceOnConnect           string            `yaml:"presence_on_connect"`
+		SendMyReadReceipts          bool              `yaml:"send_my_read_receipts"`
+		SilentConfirmation          bool              `yaml:"silent_confirmation"`
+		ConfirmationType            string            `yaml:"confirmation_type"`
+		EmojiConfirmation           *bool             `yaml:"emoji_confirmation"`
+		SkipStartupMessage          bool              `yaml:"skip_startup_message"`
+		AnnounceStartStop           bool              `yaml:"announce_start_stop"`
+		ConnectionNotifications     bool              `yaml:"connection_notifications"`
+		SpoilerViewOnce             bool              `yaml:"spoiler_as_viewonce"`
+		Reactions                   bool              `yaml:"reactions"`
+		GroupReactionSummary        bool              `yaml:"group_reaction_summary"`
+		ReactionDebounceMs          int               `yaml:"reaction_debounce_ms"`
+		NativeReactions             bool              `yaml:"native_reactions"`
+		ReactionEmojiMap            map[string]string `yaml:"reaction_emoji_map"`
+		StickerAsReaction           bool              `yaml:"sticker_as_reaction"`
+		DefaultParseMode            string            `yaml:"default_parse_mode"`
+		TopicNameTemplate           string            `yaml:"topic_name_template"`
+		TopicTypePrefix             bool              `yaml:"topic_type_prefix"`
+		CombinedFeed                bool              `yaml:"combined_feed"`
+		GeneralTopicFallback        bool              `yaml:"general_topic_fallback"`
+		GeneralTopicThreadId        int64             `yaml:"general_topic_thread_id"`
+		TopicCreationRetries        int               `yaml:"topic_creation_retries"`
+		TrackTopics                 bool              `yaml:"track_topics"`
+		QueueEnabled                bool              `yaml:"queue_enabled"`
+		QueueIntervalMs             int               `yaml:"queue_interval_ms"`
+		QueueOverflowPolicy         string            `yaml:"queue_overflow_policy"`
+		ReplayBufferPath            string            `yaml:"replay_buffer_path"`
+		ReplayBufferSize            int               `yaml:"replay_buffer_size"`
+		ReplayBufferFlushIntervalMs int               `yaml:"replay_buffer_flush_interval_ms"`
+		ReplayBufferFlushBatchSize  int               `yaml:"replay_buffer_flush_batch_size"`
+		ErrorTopicCategories        []string          `yaml:"error_topic_categories"`
+		ErrorTopicCooldownSeconds   int               `yaml:"error_topic_cooldown_seconds"`
+
+		SendDefaults struct {
+			ProtectContent           bool `yaml:"protect_content"`
+			DisableWebPagePreview    bool `yaml:"disable_web_page_preview"`
+			AllowSendingWithoutReply bool `yaml:"allow_sending_without_reply"`
+		} `yaml:"send_defaults"`
 	} `yaml:"telegram"`
 
-	   WhatsApp struct {
-		   LoginDatabase struct {
-			   Type string `yaml:"type"`
-			   URL  string `yaml:"url"`
-		   } `yaml:"login_database"`
-		   StickerMetadata struct {
-			   PackName   string `yaml:"pack_name"`
-			   AuthorName string `yaml:"author_name"`
-		   } `yaml:"sticker_metadata"`
-		   SessionName                    string   `yaml:"session_name"`
-		   TagAllAllowedGroups            []string `yaml:"tag_all_allowed_groups"`
-		   IgnoreChats                    []string `yaml:"ignore_chats"`
-		   StatusIgnoredChats             []string `yaml:"status_ignored_chats"`
-		   SkipDocuments                  bool     `yaml:"skip_documents"`
-		   SkipImages                     bool     `yaml:"skip_images"`
-		   SkipGIFs                       bool     `yaml:"skip_gifs"`
-		   SkipVideos                     bool     `yaml:"skip_videos"`
-		   SkipVoiceNotes                 bool     `yaml:"skip_voice_notes"`
-		   SkipAudios                     bool     `yaml:"skip_audios"`
-		   SkipStatus                     bool     `yaml:"skip_status"`
-		   SkipStickers                   bool     `yaml:"skip_stickers"`
-		   SkipContacts                   bool     `yaml:"skip_contacts"`
-		   SkipLocations                  bool     `yaml:"skip_locations"`
-		   SkipProfilePictureUpdates      bool     `yaml:"skip_profile_picture_updates"`
-		   SkipGroupSettingsUpdates       bool     `yaml:"skip_group_settings_updates"`
-		   SkipChatDetails                bool     `yaml:"skip_chat_details"`
-		   SendRevokedMessageUpdates      bool     `yaml:"send_revoked_message_updates"`
-		   WhatsmeowDebugMode             bool     `yaml:"whatsmeow_debug_mode"`
-		   SendMyMessagesFromOtherDevices bool     `yaml:"send_my_messages_from_other_devices"`
-		   CreateThreadForInfoUpdates     bool     `yaml:"create_thread_for_info_updates"`
-		   QueueEnabled                   bool     `yaml:"queue_enabled"`
-		   QueueIntervalMs                int      `yaml:"queue_interval_ms"`
-	   } `yaml:"whatsapp"`
+	WhatsApp struct {
+		LoginDatabase struct {
+			Type string `yaml:"type"`
+			URL  string `yaml:"url"`
+		} `yaml:"login_database"`
+		StickerMetadata struct {
+			PackName   string `yaml:"pack_name"`
+			AuthorName string `yaml:"author_name"`
+		} `yaml:"sticker_metadata"`
+		Placeholders struct {
+			MediaOmitted string `yaml:"media_omitted"`
+			Revoked      string `yaml:"revoked"`
+		} `yaml:"placeholders"`
+		SessionName                       string   `yaml:"session_name"`
+		DeviceName                        string   `yaml:"device_name"`
+		TagAllAllowedGroups               []string `yaml:"tag_all_allowed_groups"`
+		IgnoreChats                       []string `yaml:"ignore_chats"`
+		StatusIgnoredChats                []string `yaml:"status_ignored_chats"`
+		AllowedSenders                    []string `yaml:"allowed_senders"`
+		SkipDocuments                     bool     `yaml:"skip_documents"`
+		SkipImages                        bool     `yaml:"skip_images"`
+		SkipGIFs                          bool     `yaml:"skip_gifs"`
+		SkipVideos                        bool     `yaml:"skip_videos"`
+		SkipVoiceNotes                    bool     `yaml:"skip_voice_notes"`
+		SkipAudios                        bool     `yaml:"skip_audios"`
+		SkipStatus                        bool     `yaml:"skip_status"`
+		SkipStickers                      bool     `yaml:"skip_stickers"`
+		SkipContacts                      bool     `yaml:"skip_contacts"`
+		SkipLocations                     bool     `yaml:"skip_locations"`
+		SkipProfilePictureUpdates         bool     `yaml:"skip_profile_picture_updates"`
+		SkipGroupProfilePictures          bool     `yaml:"skip_group_profile_pictures"`
+		SkipContactProfilePictures        bool     `yaml:"skip_contact_profile_pictures"`
+		RenameTopicsOnNameChange          bool     `yaml:"rename_topics_on_name_change"`
+		TopicRenameDebounceSeconds        int      `yaml:"topic_rename_debounce_seconds"`
+		ProfilePictureCaptionTemplate     string   `yaml:"profile_picture_caption_template"`
+		SkipGroupSettingsUpdates          bool     `yaml:"skip_group_settings_updates"`
+		SkipChatDetails                   bool     `yaml:"skip_chat_details"`
+		ShowSenderNumberInGroups          bool     `yaml:"show_sender_number_in_groups"`
+		HideSenderInPrivateChats          bool     `yaml:"hide_sender_in_private_chats"`
+		HideSenderInGroups                bool     `yaml:"hide_sender_in_groups"`
+		SendRevokedMessageUpdates         bool     `yaml:"send_revoked_message_updates"`
+		WhatsmeowDebugMode                bool     `yaml:"whatsmeow_debug_mode"`
+		SendMyMessagesFromOtherDevices    bool     `yaml:"send_my_messages_from_other_devices"`
+		NoBridgePrefix                    string   `yaml:"no_bridge_prefix"`
+		StripNoBridgePrefix               bool     `yaml:"strip_no_bridge_prefix"`
+		CreateThreadForInfoUpdates        bool     `yaml:"create_thread_for_info_updates"`
+		ChatClearAction                   string   `yaml:"chat_clear_action"`
+		PinMessageAction                  string   `yaml:"pin_message_action"`
+		CreateTopicsForJoinedGroups       bool     `yaml:"create_topics_for_joined_groups"`
+		JoinedGroupDetails                bool     `yaml:"joined_group_details"`
+		BridgePayments                    bool     `yaml:"bridge_payments"`
+		QuoteUnbridgedReplies             bool     `yaml:"quote_unbridged_replies"`
+		NotifyAdminsOnPanic               bool     `yaml:"notify_admins_on_panic"`
+		ActiveChatWindowDays              int      `yaml:"active_chat_window_days"`
+		GroupDescriptionAction            string   `yaml:"group_description_action"`
+		QueueEnabled                      bool     `yaml:"queue_enabled"`
+		QueueIntervalMs                   int      `yaml:"queue_interval_ms"`
+		QueueOverflowPolicy               string   `yaml:"queue_overflow_policy"`
+		SendTimeoutSeconds                int      `yaml:"send_timeout_seconds"`
+		EditedMarker                      string   `yaml:"edited_marker"`
+		LinkPreviewSource                 string   `yaml:"link_preview_source"`
+		MediaDownloadRetries              int      `yaml:"media_download_retries"`
+		RequestMediaReupload              bool     `yaml:"request_media_reupload"`
+		DocumentFilenameTemplate          string   `yaml:"document_filename_template"`
+		OutboundFooterTemplate            string   `yaml:"outbound_footer_template"`
+		AlwaysShowTimestamp               bool     `yaml:"always_show_timestamp"`
+		IgnoreMessagesOlderThanHours      int      `yaml:"ignore_messages_older_than_hours"`
+		FrequentlyForwardedThreshold      int      `yaml:"frequently_forwarded_threshold"`
+		FrequentlyForwardedAction         string   `yaml:"frequently_forwarded_action"`
+		NewChatMinMessages                int      `yaml:"new_chat_min_messages"`
+		NewChatWindowMinutes              int      `yaml:"new_chat_window_minutes"`
+		NewChatQuarantine                 bool     `yaml:"new_chat_quarantine"`
+		ChatRateLimitPerMinute            int      `yaml:"chat_rate_limit_per_minute"`
+		ChatRateLimitAction               string   `yaml:"chat_rate_limit_action"`
+		UnknownContactSyncCooldownMinutes int      `yaml:"unknown_contact_sync_cooldown_minutes"`
+		UnknownContactSyncMaxPerHour      int      `yaml:"unknown_contact_sync_max_per_hour"`
+		ContactSyncRetries                int      `yaml:"contact_sync_retries"`
+	} `yaml:"whatsapp"`
 
 	Database map[string]string `yaml:"database"`
 }
@@ -82,7 +199,7 @@ type Config struct {
 func (cfg *Config) LoadConfig() error {
 	configFilePath := cfg.Path
 
-			   	if _, err := os.Stat(configFilePath); err != nil {
+	if _, err := os.Stat(configFilePath); err != nil {
 		return fmt.Errorf("error with config file path : %s", err)
 	}
 
@@ -102,6 +219,38 @@ func (cfg *Config) LoadConfig() error {
 		return fmt.Errorf("could not parse config file : %s", err)
 	}
 
+	switch cfg.Telegram.PresenceOnConnect {
+	case "", "available", "unavailable":
+	default:
+		return fmt.Errorf("telegram presence_on_connect must be one of available or unavailable, or empty")
+	}
+
+	switch strings.ToLower(cfg.Telegram.DefaultParseMode) {
+	case "", "html", "markdownv2", "none":
+	default:
+		return fmt.Errorf("telegram default_parse_mode must be one of html, markdownv2 or none")
+	}
+
+	if cfg.Media.ImageQuality < 1 || cfg.Media.ImageQuality > 100 ||
+		cfg.Media.StickerQuality < 1 || cfg.Media.StickerQuality > 100 {
+		return fmt.Errorf("media image_quality and sticker_quality must be between 1 and 100")
+	}
+	if cfg.Media.VoiceNoteBitrate == "" {
+		cfg.Media.VoiceNoteBitrate = "32k"
+	}
+
+	switch cfg.Translation.Mode {
+	case "append", "replace":
+	default:
+		return fmt.Errorf("translation mode must be one of append or replace")
+	}
+	if cfg.Translation.TimeoutMs <= 0 {
+		return fmt.Errorf("translation timeout_ms must be greater than 0")
+	}
+	if cfg.Translation.Provider == "http" && cfg.Translation.URL == "" {
+		return fmt.Errorf("translation url must be set when the provider is http")
+	}
+
 	whatsappLoginDB := cfg.WhatsApp.LoginDatabase
 	if whatsappLoginDB.Type == "sqlite3" {
 		parsedUrl, err := url.Parse(whatsappLoginDB.URL)
@@ -147,12 +296,57 @@ func (cfg *Config) SaveConfig() error {
 
 func (cfg *Config) SetDefaults() {
 	cfg.TimeZone = "UTC"
+	cfg.StreamingThresholdMB = 50
+	cfg.BridgeWaToTg = true
+	cfg.BridgeTgToWa = true
+	cfg.MaxFormattingLength = 20000
+	cfg.MaxFormattingEntities = 500
+	cfg.DownloadRetries = 3
+	cfg.DownloadTimeoutSeconds = 30
+	cfg.DownloadMaxSizeMB = 20
+	cfg.RetryJitterFactor = 0.2
+	cfg.MsgPairCacheSize = 1000
+	cfg.MsgPairCacheTTLSeconds = 600
+	cfg.ChatThreadCacheTTLSeconds = 300
 
 	cfg.WhatsApp.SessionName = "watgbridge"
+	cfg.Media.VoiceNoteBitrate = "32k"
+	cfg.Media.ImageQuality = 85
+	cfg.Media.StickerQuality = 100
+
+	cfg.Translation.TimeoutMs = 3000
+	cfg.Translation.Mode = "append"
+
 	cfg.WhatsApp.LoginDatabase.Type = "sqlite3"
 	cfg.WhatsApp.LoginDatabase.URL = "file:wawebstore.db?foreign_keys=on"
 	cfg.WhatsApp.StickerMetadata.PackName = "WaTgBridge"
 	cfg.WhatsApp.StickerMetadata.AuthorName = "WaTgBridge"
+	cfg.WhatsApp.Placeholders.MediaOmitted = "📎 [media omitted]"
+	cfg.WhatsApp.Placeholders.Revoked = "This message was revoked by {name}"
+	cfg.WhatsApp.SendTimeoutSeconds = 120
+	cfg.WhatsApp.EditedMarker = "(edited)"
+	cfg.WhatsApp.FrequentlyForwardedThreshold = 5
+	cfg.WhatsApp.NewChatWindowMinutes = 60
+	cfg.WhatsApp.ChatRateLimitAction = "buffer"
+	cfg.WhatsApp.UnknownContactSyncCooldownMinutes = 60
+	cfg.WhatsApp.UnknownContactSyncMaxPerHour = 10
+	cfg.WhatsApp.ContactSyncRetries = 2
+	cfg.WhatsApp.MediaDownloadRetries = 2
+	cfg.WhatsApp.JoinedGroupDetails = true
+	cfg.WhatsApp.BridgePayments = true
+	cfg.WhatsApp.QuoteUnbridgedReplies = true
+	cfg.WhatsApp.RenameTopicsOnNameChange = true
+	cfg.WhatsApp.TopicRenameDebounceSeconds = 60
 
 	cfg.Telegram.ConfirmationType = "emoji"
+	cfg.Telegram.DefaultParseMode = "html"
+	cfg.Telegram.PresenceDurationSeconds = 10
+	cfg.Telegram.ReplayBufferSize = 1000
+	cfg.Telegram.ReplayBufferFlushBatchSize = 100
+	cfg.Telegram.GeneralTopicFallback = true
+	cfg.Telegram.GeneralTopicThreadId = 1
+	cfg.Telegram.TopicCreationRetries = 3
+	cfg.Telegram.ErrorTopicCooldownSeconds = 60
+	cfg.Telegram.SendDefaults.DisableWebPagePreview = true
+	cfg.Telegram.SendDefaults.AllowSendingWithoutReply = true
 }
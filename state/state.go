@@ -3,6 +3,8 @@ package state
 import (
 	_ "embed"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
@@ -16,7 +18,7 @@ import (
 var WATGBRIDGE_VERSION string
 
 type state struct {
-	Config   *Config
+	config   atomic.Pointer[Config]
 	Database *gorm.DB
 	Logger   *zap.Logger
 
@@ -35,7 +37,31 @@ type state struct {
 
 var State state
 
+// configUpdateMu serializes UpdateConfig calls so that no update is lost.
+var configUpdateMu sync.Mutex
+
 func init() {
 	WATGBRIDGE_VERSION = strings.TrimSpace(WATGBRIDGE_VERSION)
-	State.Config = &Config{Path: "config.yaml"}
+	State.config.Store(&Config{Path: "config.yaml"})
+}
+
+// Config returns the current config. It can be modified in place only during
+// startup, once the bridge is running it must be changed using UpdateConfig.
+func (s *state) Config() *Config {
+	return s.config.Load()
+}
+
+// UpdateConfig applies fn to a copy of the current config, makes the copy the
+// current config and saves it to the config file. Goroutines that already
+// hold the old config keep seeing it unchanged, so fn must replace slices and
+// maps instead of modifying them.
+func (s *state) UpdateConfig(fn func(cfg *Config)) error {
+	configUpdateMu.Lock()
+	defer configUpdateMu.Unlock()
+
+	newCfg := *s.config.Load()
+	fn(&newCfg)
+	s.config.Store(&newCfg)
+
+	return newCfg.SaveConfig()
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"watgbridge/queue"
 	"watgbridge/state"
 	"watgbridge/telegram/middlewares"
 
@@ -16,7 +17,7 @@ import (
 
 func NewTelegramClient() error {
 	var (
-		cfg    = state.State.Config
+		cfg    = state.State.Config()
 		logger = state.State.Logger
 	)
 	defer logger.Sync()
@@ -35,10 +36,15 @@ func NewTelegramClient() error {
 	}
 	state.State.TelegramBot = bot
 
-	bot.UseMiddleware(middlewares.AutoHandleRateLimit)
-	bot.UseMiddleware(middlewares.ParseAsHTML)
-	bot.UseMiddleware(middlewares.DisableWebPagePreview)
-	bot.UseMiddleware(middlewares.SendWithoutReply)
+	bot.UseMiddleware(middlewares.AutoHandleRateLimit(func(d time.Duration) time.Duration {
+		return queue.Jitter(d, state.State.Config().RetryJitterFactor)
+	}))
+	bot.UseMiddleware(middlewares.DefaultParseMode(cfg.Telegram.DefaultParseMode))
+	bot.UseMiddleware(middlewares.SendDefaults(middlewares.SendDefaultsOpts{
+		ProtectContent:           cfg.Telegram.SendDefaults.ProtectContent,
+		DisableWebPagePreview:    cfg.Telegram.SendDefaults.DisableWebPagePreview,
+		AllowSendingWithoutReply: cfg.Telegram.SendDefaults.AllowSendingWithoutReply,
+	}))
 
 	dispatcher := ext.NewDispatcher(&ext.DispatcherOpts{
 		UnhandledErrFunc: func(err error) {
@@ -5,4 +5,5 @@ const (
 	DispatcherForwardHandlerGroup
 	DispatcherCallbackHandlerGroup
 	ModulesStartingHandlerGroup
+	TopicTrackingHandlerGroup
 )
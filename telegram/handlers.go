@@ -3,6 +3,7 @@ package telegram
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"html"
 	"io"
@@ -11,25 +12,26 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"watgbridge/database"
 	"watgbridge/queue"
+	"watgbridge/scheduler"
 	"watgbridge/state"
 	"watgbridge/utils"
+	"watgbridge/whatsapp"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
 	"go.mau.fi/whatsmeow"
-	waCommon "go.mau.fi/whatsmeow/proto/waCommon"
-	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
 	waTypes "go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
-	"google.golang.org/protobuf/proto"
 )
 
 type waTgBridgeCommand struct {
@@ -41,17 +43,26 @@ var commands = []waTgBridgeCommand{}
 
 func AddTelegramHandlers() {
 
-	var (
-		cfg        = state.State.Config
-		dispatcher = state.State.TelegramDispatcher
-	)
+	dispatcher := state.State.TelegramDispatcher
 
 	dispatcher.AddHandlerToGroup(handlers.NewMessage(
 		func(msg *gotgbot.Message) bool {
-			return msg.Chat.Id == cfg.Telegram.TargetChatID
+			return msg.Chat.Id == state.State.Config().Telegram.TargetChatID
 		}, BridgeTelegramToWhatsAppHandler,
 	), DispatcherForwardHandlerGroup)
 
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(
+		func(msg *gotgbot.Message) bool {
+			return msg.MigrateToChatId != 0 && msg.Chat.Id == state.State.Config().Telegram.TargetChatID
+		}, ChatMigrationHandler,
+	), DefaultHandlerGroup)
+
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(
+		func(msg *gotgbot.Message) bool {
+			return msg.Chat.Id == state.State.Config().Telegram.TargetChatID && msg.IsTopicMessage && msg.MessageThreadId != 0
+		}, TopicTrackingHandler,
+	), TopicTrackingHandlerGroup)
+
 	commands = append(commands,
 		waTgBridgeCommand{
 			handlers.NewCommand("start", StartCommandHandler),
@@ -73,6 +84,10 @@ func AddTelegramHandlers() {
 			handlers.NewCommand("revoke", RevokeCommandHandler),
 			"Revoke a message from WhatsApp",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("reprocess", ReprocessCommandHandler),
+			"Bridge a WhatsApp message again by replying to it",
+		},
 		waTgBridgeCommand{
 			handlers.NewCommand("synccontacts", SyncContactsHandler),
 			"Try to sync the contacts list from WhatsApp",
@@ -81,6 +96,18 @@ func AddTelegramHandlers() {
 			handlers.NewCommand("clearpairhistory", ClearMessageIdPairsHistoryHandler),
 			"Delete all the past stored message id pairs",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("cleanup", CleanupCommandHandler),
+			"Remove deleted topics and orphaned message id pairs now",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("maintenance", MaintenanceHandler),
+			"Pause or resume bridging, use on or off",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("menu", MenuCommandHandler),
+			"Show buttons for common actions",
+		},
 		waTgBridgeCommand{
 			handlers.NewCommand("restartwa", RestartWhatsAppConnectionHandler),
 			"Restart the WhatsApp client",
@@ -97,10 +124,42 @@ func AddTelegramHandlers() {
 			handlers.NewCommand("settargetprivatechat", SetTargetPrivateChatHandler),
 			"Set the target WhatsApp private chat for current thread",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("link", LinkHandler),
+			"Link a WhatsApp chat to the current thread, or to a given thread ID",
+		},
 		waTgBridgeCommand{
 			handlers.NewCommand("unlinkthread", UnlinkThreadHandler),
 			"Unlink the current thread from its WhatsApp chat",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("unlinkedtopics", UnlinkedTopicsHandler),
+			"List the topics not linked to any WhatsApp chat",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("dormanttopics", DormantTopicsHandler),
+			"List, close or delete the topics without recent messages",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("close", CloseTopicHandler),
+			"Close a topic without removing its WhatsApp chat mapping",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("open", OpenTopicHandler),
+			"Reopen a topic closed using /close",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("nomedia", NoMediaHandler),
+			"Stop downloading media for a topic and bridge a placeholder instead",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("media", MediaHandler),
+			"Resume bridging media for a topic disabled using /nomedia",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("export", ExportTopicHandler),
+			"Export a transcript of the messages bridged to a topic",
+		},
 		waTgBridgeCommand{
 			handlers.NewCommand("getprofilepicture", GetProfilePictureHandler),
 			"Get the profile picture of user or group using its ID",
@@ -148,17 +207,26 @@ func AddTelegramHandlers() {
 			return strings.HasPrefix(cq.Data, "revoke")
 		}, RevokeCallbackHandler), DispatcherCallbackHandlerGroup)
 
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "link_")
+		}, LinkCallbackHandler), DispatcherCallbackHandlerGroup)
+
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "menu_")
+		}, MenuCallbackHandler), DispatcherCallbackHandlerGroup)
+
 	// Handler for Telegram message reactions → forward to WhatsApp
-	dispatcher.AddHandlerToGroup(telegramReactionHandler{targetChatID: cfg.Telegram.TargetChatID}, DispatcherForwardHandlerGroup)
+	dispatcher.AddHandlerToGroup(telegramReactionHandler{}, DispatcherForwardHandlerGroup)
 }
 
 // telegramReactionHandler implements ext.Handler for MessageReaction updates.
-type telegramReactionHandler struct {
-	targetChatID int64
-}
+// The target chat is read on every update as it changes on chat migration.
+type telegramReactionHandler struct{}
 
 func (h telegramReactionHandler) CheckUpdate(b *gotgbot.Bot, ctx *ext.Context) bool {
-	return ctx.Update.MessageReaction != nil && ctx.Update.MessageReaction.Chat.Id == h.targetChatID
+	return ctx.Update.MessageReaction != nil && ctx.Update.MessageReaction.Chat.Id == state.State.Config().Telegram.TargetChatID
 }
 
 func (h telegramReactionHandler) HandleUpdate(b *gotgbot.Bot, ctx *ext.Context) error {
@@ -176,8 +244,12 @@ func TelegramReactionToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return nil
 	}
 
+	cfg := state.State.Config()
+	if !cfg.BridgeTgToWa || whatsapp.InMaintenanceMode() {
+		return nil
+	}
+
 	// Only forward reactions from authorized users (owner or sudo users)
-	cfg := state.State.Config
 	isAuthorized := false
 	if reaction.User != nil {
 		isAuthorized = reaction.User.Id == cfg.Telegram.OwnerID ||
@@ -205,17 +277,48 @@ func TelegramReactionToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	waChatJID, _ := utils.WaParseJID(waChatID)
 
-	_, err = queue.WaSend(context.Background(), waChatJID, &waE2E.Message{
-		ReactionMessage: &waE2E.ReactionMessage{
-			Text:              proto.String(emoji),
-			SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
-			Key: &waCommon.MessageKey{
-				RemoteJID: proto.String(waChatJID.String()),
-				FromMe:    proto.Bool(false),
-				ID:        proto.String(stanzaID),
-			},
-		},
-	})
+	_, err = utils.WaSendReaction(waChatJID, stanzaID, emoji, false)
+	return err
+}
+
+// ChatMigrationHandler follows the target chat when it is migrated to a
+// supergroup, as its ID changes and every stored reference to it goes stale.
+func ChatMigrationHandler(b *gotgbot.Bot, c *ext.Context) error {
+	var (
+		logger   = state.State.Logger
+		oldId    = c.EffectiveMessage.Chat.Id
+		newId    = c.EffectiveMessage.MigrateToChatId
+		failures []string
+	)
+	defer logger.Sync()
+
+	logger.Info("target chat was migrated to a supergroup",
+		zap.Int64("old_chat_id", oldId),
+		zap.Int64("new_chat_id", newId),
+	)
+
+	if err := state.State.UpdateConfig(func(cfg *state.Config) {
+		cfg.Telegram.TargetChatID = newId
+	}); err != nil {
+		logger.Error("failed to save the new target chat ID in config", zap.Error(err))
+		failures = append(failures, "config")
+	}
+
+	if err := database.ChatThreadMigrateTgChatId(oldId, newId); err != nil {
+		logger.Error("failed to migrate chat thread pairs", zap.Error(err))
+		failures = append(failures, "chat thread pairs")
+	}
+
+	if err := database.MsgIdMigrateTgChatId(oldId, newId); err != nil {
+		logger.Error("failed to migrate message id pairs", zap.Error(err))
+		failures = append(failures, "message id pairs")
+	}
+
+	text := fmt.Sprintf("The target chat was migrated from <code>%d</code> to <code>%d</code>", oldId, newId)
+	if len(failures) > 0 {
+		text += fmt.Sprintf("\n\nFailed to update: %s. Check the logs for details", strings.Join(failures, ", "))
+	}
+	_, err := queue.TgSendMessage(b, newId, text, nil)
 	return err
 }
 
@@ -230,6 +333,15 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		}
 	}
 
+	if !state.State.Config().BridgeTgToWa {
+		return nil
+	}
+
+	if whatsapp.InMaintenanceMode() {
+		_, err := utils.TgReplyTextByContext(b, c, "Not sent to WhatsApp, the bridge is in maintenance mode", nil, false)
+		return err
+	}
+
 	var (
 		waClient     = state.State.WhatsAppClient
 		msgToForward = c.EffectiveMessage
@@ -240,11 +352,17 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 	var err error
 
 	if msgToReplyTo != nil && msgToReplyTo.ForumTopicCreated == nil {
-		stanzaID, participantID, waChatID, err = database.MsgIdGetWaFromTg(c.EffectiveChat.Id, msgToReplyTo.MessageId, msgToForward.MessageThreadId)
+		if msgToForward.IsTopicMessage {
+			stanzaID, participantID, waChatID, err = database.MsgIdGetWaFromTg(c.EffectiveChat.Id, msgToReplyTo.MessageId, msgToForward.MessageThreadId)
+		} else {
+			// Messages from chats without a topic share the General topic, and the thread
+			// ID of replies there is not a topic, so the pair alone tells the chat apart
+			stanzaID, participantID, waChatID, err = database.MsgIdGetWaFromTgByMsgId(c.EffectiveChat.Id, msgToReplyTo.MessageId)
+		}
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive a pair from database", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategorySend, "Failed to retreive a pair from database", err)
 		} else if stanzaID == "" {
-			return utils.TgReplyWithErrorByContext(b, c, "Cannot send to WhatsApp", fmt.Errorf("corresponding stanza Id to replied to message not found"))
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategorySend, "Cannot send to WhatsApp", fmt.Errorf("corresponding stanza Id to replied to message not found"))
 		}
 
 		if waChatID == waClient.Store.ID.String() {
@@ -253,14 +371,39 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 	} else {
 		waChatID, err = database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to find the chat pairing between this topic and a WhatsApp chat", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategorySend, "Failed to find the chat pairing between this topic and a WhatsApp chat", err)
 		} else if waChatID == "" {
-			if c.EffectiveMessage.MessageThreadId != 0 {
+			if state.State.Config().Telegram.CombinedFeed {
+				_, err = utils.TgReplyTextByContext(b, c, "Reply to a bridged message to send this to its WhatsApp chat", nil, false)
+				return err
+			} else if c.EffectiveMessage.MessageThreadId != 0 {
 				_, err = utils.TgReplyTextByContext(b, c, "No mapping found between current topic and a WhatsApp chat", nil, false)
 				return err
 			}
 			return nil
+		} else if utils.WaIsPseudoChat(waChatID) {
+			_, err = utils.TgReplyTextByContext(b, c, "This topic isn't linked to a WhatsApp chat, reply to a bridged message to send this to its chat", nil, false)
+			return err
+		}
+
+		// Only replies tell which of the chats linked to a shared topic to send to
+		if shared, err := database.ChatThreadIsShared(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategorySend, "Failed to find the chat pairing between this topic and a WhatsApp chat", err)
+		} else if shared {
+			_, err = utils.TgReplyTextByContext(b, c, "This topic is linked to several WhatsApp chats, reply to a bridged message to send this to its chat", nil, false)
+			return err
+		}
+	}
+
+	// Broadcast list message, replies go privately to the sender and never to the list
+	if waChatJID, _ := utils.WaParseJID(waChatID); waChatJID.IsBroadcastList() {
+		participant, _ := utils.WaParseJID(participantID)
+		if participant.User == waClient.Store.ID.User || participant.User == waClient.Store.LID.User {
+			_, err := utils.TgReplyTextByContext(b, c, "Cannot reply to a message you sent to a broadcast list", nil, false)
+			return err
 		}
+		participant = participant.ToNonAD()
+		return utils.TgSendToWhatsApp(b, c, msgToForward, msgToReplyTo, participant, participant.String(), stanzaID, true)
 	}
 
 	// Status Update
@@ -270,16 +413,17 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		waChatJID, _ := utils.WaParseJID(participantID)
 		contactName := utils.WaGetContactName(waChatJID)
 
+		utils.WaRecordChatActivity(waChatJID, time.Now())
 		contactThreadID, err := utils.TgGetOrMakeThreadFromWa(waChatJID, c.EffectiveChat.Id, contactName)
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to get or create a thread for the contact", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategorySend, "Failed to get or create a thread for the contact", err)
 		}
 
 		forwardedMsg, err := b.ForwardMessage(c.EffectiveChat.Id, c.EffectiveChat.Id, c.EffectiveMessage.MessageId, &gotgbot.ForwardMessageOpts{
 			MessageThreadId: contactThreadID,
 		})
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to forward the message to the contact's thread", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategorySend, "Failed to forward the message to the contact's thread", err)
 		}
 
 		msgCopy := *msgToForward
@@ -307,7 +451,7 @@ func StartCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	var (
 		startTime     = state.State.StartTime
 		localLocation = state.State.LocalLocation
-		timeFormat    = state.State.Config.TimeFormat
+		timeFormat    = state.State.Config().TimeFormat
 		upTime        = time.Now().UTC().Sub(startTime).Round(time.Second)
 	)
 
@@ -317,6 +461,14 @@ func StartCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 		upTime.String(),
 	)
 	startMessage += fmt.Sprintf("• <b>Version</b>: <code>%s</code>\n", state.WATGBRIDGE_VERSION)
+	if state.State.Config().MsgPairCacheSize > 0 {
+		size, hits, misses := database.MsgIdCacheStats()
+		hitRate := 0.0
+		if hits+misses > 0 {
+			hitRate = float64(hits) / float64(hits+misses) * 100
+		}
+		startMessage += fmt.Sprintf("• <b>Message ID Cache</b>: %d entries, %.1f%% hit rate\n", size, hitRate)
+	}
 	if len(state.State.Modules) > 0 {
 		startMessage += "• <b>Loaded Modules</b>:\n"
 		for _, module := range state.State.Modules {
@@ -366,6 +518,8 @@ func StartPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 	contactName := utils.WaGetContactName(waJID)
 	tgChatId := c.EffectiveChat.Id
+	// Starting a chat makes it active, even if it was dormant
+	utils.WaRecordChatActivity(waJID, time.Now())
 	_, err := utils.TgGetOrMakeThreadFromWa_String(waJID.String(), tgChatId, contactName)
 	if err != nil {
 		utils.TgSendErrorById(b, tgChatId, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
@@ -384,7 +538,7 @@ func GetWhatsAppGroupsHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	waGroups, err := waClient.GetJoinedGroups(context.Background())
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the groups", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to retrieve the groups", err)
 	}
 
 	outputString := ""
@@ -424,7 +578,7 @@ func FindContactHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	results, resultsCount, err := utils.WaFuzzyFindContacts(query)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Encountered error while finding contacts", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Encountered error while finding contacts", err)
 	} else if resultsCount == 0 {
 		_, err = utils.TgReplyTextByContext(b, c, "No matching results found :(", nil, false)
 		return err
@@ -454,11 +608,11 @@ func UpdateAndRestartHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return nil
 	}
 
-	cfg := state.State.Config
+	cfg := state.State.Config()
 
 	if cfg.UseGithHubBinaries {
 		if cfg.Architecture == "" {
-			return utils.TgReplyWithErrorByContext(b, c,
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone,
 				"Please set an architecture field in config file\nCan be 'amd64' or 'aarch64'",
 				nil)
 		}
@@ -468,17 +622,17 @@ func UpdateAndRestartHandler(b *gotgbot.Bot, c *ext.Context) error {
 		url := fmt.Sprintf(RELEASE_URL_FORMAT, cfg.Architecture)
 		err := utils.DownloadFileToLocalByURL("watgbridge_temp", url)
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to download the release", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to download the release", err)
 		}
 
 		err = os.Rename("watgbridge_temp", "watgbridge")
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to rename the downloaded file", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to rename the downloaded file", err)
 		}
 
 		err = os.Chmod("watgbridge", 0755)
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to make the file executable", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to make the file executable", err)
 		}
 
 		utils.TgReplyTextByContext(b, c, "Successfully downloaded and prepared the release, now restarting...", nil, false)
@@ -487,7 +641,7 @@ func UpdateAndRestartHandler(b *gotgbot.Bot, c *ext.Context) error {
 		gitPullCmd := exec.Command(cfg.GitExecutable, "pull", "--rebase")
 		err := gitPullCmd.Run()
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to execute 'git pull --rebase' command", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to execute 'git pull --rebase' command", err)
 		}
 
 		utils.TgReplyTextByContext(b, c, "Successfully pulled from GitHub", nil, false)
@@ -495,20 +649,22 @@ func UpdateAndRestartHandler(b *gotgbot.Bot, c *ext.Context) error {
 		goBuildCmd := exec.Command(cfg.GoExecutable, "build")
 		err = goBuildCmd.Run()
 		if err != nil {
-			return utils.TgReplyWithErrorByContext(b, c, "Failed to execute 'go build' command", err)
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to execute 'go build' command", err)
 		}
 
 		utils.TgReplyTextByContext(b, c, "Successfully built the binary, now restarting...", nil, false)
 
 	}
 
+	database.MsgIdFlushPairs()
+
 	os.Setenv("WATG_IS_RESTARTED", "1")
 	os.Setenv("WATG_CHAT_ID", fmt.Sprint(c.EffectiveChat.Id))
 	os.Setenv("WATG_MESSAGE_ID", fmt.Sprint(c.EffectiveMessage.MessageId))
 
 	err := syscall.Exec(path.Join(".", "watgbridge"), []string{}, os.Environ())
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to run exec syscall to restart the bot", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to run exec syscall to restart the bot", err)
 	}
 
 	return nil
@@ -522,7 +678,7 @@ func SyncContactsHandler(b *gotgbot.Bot, c *ext.Context) error {
 	utils.TgReplyTextByContext(b, c, "Starting syncing contacts... may take some time", nil, false)
 	err := utils.WaSyncContacts()
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to sync contacts", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to sync some or all of the contacts", err)
 	}
 
 	_, err = utils.TgReplyTextByContext(b, c, "Successfully synced the contact list", nil, false)
@@ -536,13 +692,379 @@ func ClearMessageIdPairsHistoryHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	err := database.MsgIdDropAllPairs()
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to delete stored pairs", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to delete stored pairs", err)
 	}
 
 	_, err = utils.TgReplyTextByContext(b, c, "Successfully deleted all the stored pairs", nil, false)
 	return err
 }
 
+// TopicTrackingHandler remembers the topics messages are seen in, see
+// track_topics. Topic names are only known from their creation or edit.
+func TopicTrackingHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !state.State.Config().Telegram.TrackTopics {
+		return nil
+	}
+
+	msg := c.EffectiveMessage
+	if utils.TgIsGeneralThread(msg.MessageThreadId) {
+		return nil
+	}
+
+	var name string
+	if msg.ForumTopicCreated != nil {
+		name = msg.ForumTopicCreated.Name
+	} else if msg.ForumTopicEdited != nil {
+		name = msg.ForumTopicEdited.Name
+	}
+
+	if err := database.ForumTopicRecord(msg.Chat.Id, msg.MessageThreadId, name); err != nil {
+		state.State.Logger.Warn("failed to record a seen topic",
+			zap.Int64("thread_id", msg.MessageThreadId),
+			zap.Error(err),
+		)
+	}
+	return nil
+}
+
+func UnlinkedTopicsHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	cfg := state.State.Config()
+	if !cfg.Telegram.TrackTopics {
+		_, err := utils.TgReplyTextByContext(b, c, "Set <code>track_topics</code> to true in the config so that topics are remembered", nil, false)
+		return err
+	}
+
+	args := c.Args()
+	if len(args) > 1 && strings.ToLower(args[1]) == "forget" {
+		removed, err := database.ForumTopicDropAll(cfg.Telegram.TargetChatID)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to forget the seen topics", err)
+		}
+		_, err = utils.TgReplyTextByContext(b, c, fmt.Sprintf("Forgot %d seen topics", removed), nil, false)
+		return err
+	}
+
+	topics, err := database.ForumTopicGetUnlinked(cfg.Telegram.TargetChatID)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get the seen topics", err)
+	} else if len(topics) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "Every topic seen since <code>track_topics</code> was turned on is linked to a WhatsApp chat", nil, false)
+		return err
+	}
+
+	linkChatId := strings.TrimPrefix(fmt.Sprint(cfg.Telegram.TargetChatID), "-100")
+
+	text := fmt.Sprintf("%d topics aren't linked to any WhatsApp chat:\n\n", len(topics))
+	for _, topic := range topics {
+		name := topic.Name
+		if name == "" {
+			name = fmt.Sprintf("Topic %d", topic.TgThreadId)
+		}
+		line := fmt.Sprintf("• <a href=\"https://t.me/c/%s/%d\">%s</a> (<code>%d</code>), last message at %s\n",
+			linkChatId, topic.TgThreadId, html.EscapeString(name), topic.TgThreadId,
+			topic.LastSeenAt.In(state.State.LocalLocation).Format(cfg.TimeFormat))
+
+		// Keep the reply within the 4096 characters Telegram allows
+		if len(text)+len(line) > 3800 {
+			text += "...\n"
+			break
+		}
+		text += line
+	}
+	text += "\nSend <code>/link</code> in a topic to link it to a WhatsApp chat, or delete it in Telegram. "
+	text += "Deleted topics stay in this list until <code>/unlinkedtopics forget</code> is used"
+
+	_, err = utils.TgReplyTextByContext(b, c, text, nil, false)
+	return err
+}
+
+func DormantTopicsHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		cfg    = state.State.Config()
+		tgBot  = state.State.TelegramBot
+		days   = cfg.WhatsApp.ActiveChatWindowDays
+		action string
+	)
+
+	usageString := "Usage: <code>" + html.EscapeString("/dormanttopics [days] [close|delete]") + "</code>\n\n"
+	usageString += "Lists the topics of WhatsApp chats without any message bridged for that many days "
+	usageString += "(<code>active_chat_window_days</code> by default), and closes or deletes them if asked to"
+
+	for _, arg := range c.Args()[1:] {
+		if parsedDays, err := strconv.Atoi(arg); err == nil && parsedDays > 0 {
+			days = parsedDays
+		} else if arg = strings.ToLower(arg); arg == "close" || arg == "delete" {
+			action = arg
+		} else {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+			return err
+		}
+	}
+	if days <= 0 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	chatPairs, err := database.ChatThreadGetAllPairs(cfg.Telegram.TargetChatID)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get the chat pairs", err)
+	}
+
+	// Only topics of WhatsApp chats, not the shared ones like Calls or Status
+	cutoff := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	var (
+		dormant []database.ChatThreadPair
+		lastAt  = make(map[int64]time.Time)
+		seen    = make(map[int64]bool)
+	)
+	for _, pair := range chatPairs {
+		if !strings.Contains(pair.ID, "@") || pair.ID == "status@broadcast" || seen[pair.TgThreadId] || utils.TgIsGeneralThread(pair.TgThreadId) {
+			continue
+		}
+		seen[pair.TgThreadId] = true
+
+		lastBridgedAt, found, err := database.MsgIdGetLastBridgedAt(cfg.Telegram.TargetChatID, pair.TgThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get the last message of a topic", err)
+		} else if !found || lastBridgedAt.After(cutoff) {
+			continue
+		}
+		dormant = append(dormant, pair)
+		lastAt[pair.TgThreadId] = lastBridgedAt
+	}
+
+	if len(dormant) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, fmt.Sprintf("No topic has gone without messages for %d days", days), nil, false)
+		return err
+	}
+
+	text := fmt.Sprintf("%d topics had no messages for %d days:\n\n", len(dormant), days)
+	failed := 0
+	for _, pair := range dormant {
+		line := fmt.Sprintf("• <code>%d</code> %s, last message at %s",
+			pair.TgThreadId, html.EscapeString(pair.ID),
+			lastAt[pair.TgThreadId].In(state.State.LocalLocation).Format(cfg.TimeFormat))
+
+		switch action {
+		case "close":
+			_, err = queue.TgCloseForumTopic(tgBot, cfg.Telegram.TargetChatID, pair.TgThreadId, nil)
+			if err == nil || strings.Contains(strings.ToUpper(err.Error()), "TOPIC_NOT_MODIFIED") {
+				err = database.ChatThreadSetManuallyClosed(cfg.Telegram.TargetChatID, pair.TgThreadId, true)
+			}
+		case "delete":
+			_, err = queue.TgDeleteForumTopic(tgBot, cfg.Telegram.TargetChatID, pair.TgThreadId, nil)
+			if err == nil {
+				if err = database.ChatThreadDropPairByTg(cfg.Telegram.TargetChatID, pair.TgThreadId); err == nil {
+					_, err = database.MsgIdDeletePairsByThreadId(cfg.Telegram.TargetChatID, pair.TgThreadId)
+				}
+			}
+		}
+		if action != "" && err != nil {
+			failed++
+			line += fmt.Sprintf(" (failed to %s: %s)", action, html.EscapeString(err.Error()))
+		}
+
+		if len(text)+len(line) > 3800 {
+			text += "..."
+			if action == "" {
+				break
+			}
+			continue
+		}
+		text += line + "\n"
+	}
+
+	switch action {
+	case "close":
+		text += fmt.Sprintf("\nClosed %d of them, use <code>/open</code> in a topic to reopen it", len(dormant)-failed)
+	case "delete":
+		text += fmt.Sprintf("\nDeleted %d of them, a new topic is created if the chat becomes active again", len(dormant)-failed)
+	default:
+		text += "\nUse <code>/dormanttopics close</code> or <code>/dormanttopics delete</code> to clean them up"
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, text, nil, false)
+	return err
+}
+
+func CleanupCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	utils.TgReplyTextByContext(b, c, "Starting cleanup... may take some time", nil, false)
+
+	result, cleanupErr := scheduler.RunCleanup()
+
+	text := "Cleanup finished"
+	if cleanupErr != nil {
+		text = "Cleanup finished with errors"
+	}
+	text += fmt.Sprintf("\n\n• <b>chat_thread_pairs</b>: %d removed\n• <b>msg_id_pairs</b>: %d removed",
+		result.ChatThreadPairs, result.MsgIdPairs)
+	if cleanupErr != nil {
+		text += fmt.Sprintf("\n\n<code>%s</code>", html.EscapeString(cleanupErr.Error()))
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, text, nil, false)
+	return err
+}
+
+func MaintenanceHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/maintenance on|off") + "</code>\n\n"
+	usageString += "While it is on, WhatsApp updates are held back until it is turned off, "
+	usageString += "and Telegram messages are not sent to WhatsApp. Commands are still answered\n\n"
+	switch {
+	case whatsapp.MaintenanceDraining():
+		usageString += "Maintenance mode is being turned off, the WhatsApp updates received in the meantime are still being bridged"
+	case whatsapp.InMaintenanceMode():
+		usageString += "Maintenance mode is on"
+	default:
+		usageString += "Maintenance mode is off"
+	}
+
+	args := c.Args()
+	if len(args) <= 1 {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	var text string
+	switch strings.ToLower(args[1]) {
+	case "on":
+		text = "Maintenance mode is on, use <code>/maintenance off</code> to resume bridging"
+		if err := whatsapp.EnterMaintenanceMode(); err != nil {
+			text = maintenanceErrorText(err)
+		}
+	case "off":
+		buffered, err := whatsapp.LeaveMaintenanceMode()
+		text = fmt.Sprintf("Maintenance mode is off, bridging %d WhatsApp updates received in the meantime", buffered)
+		if err != nil {
+			text = maintenanceErrorText(err)
+		}
+	default:
+		text = usageString
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, text, nil, false)
+	return err
+}
+
+// maintenanceErrorText returns the reply for an error of turning maintenance
+// mode on or off.
+func maintenanceErrorText(err error) string {
+	switch {
+	case errors.Is(err, whatsapp.ErrMaintenanceOn):
+		return "Maintenance mode is already on"
+	case errors.Is(err, whatsapp.ErrMaintenanceOff):
+		return "Maintenance mode is not on"
+	case errors.Is(err, whatsapp.ErrMaintenanceDraining):
+		return "Maintenance mode is being turned off, wait until the WhatsApp updates received in the meantime are bridged"
+	}
+	return "Failed to change maintenance mode: " + html.EscapeString(err.Error())
+}
+
+// makeMenuKeyboard returns the buttons of /menu, the maintenance button
+// showing what pressing it will do.
+func makeMenuKeyboard() *gotgbot.InlineKeyboardMarkup {
+	maintenanceText := "Pause bridging"
+	if whatsapp.MaintenanceDraining() {
+		maintenanceText = "Resuming bridging..."
+	} else if whatsapp.InMaintenanceMode() {
+		maintenanceText = "Resume bridging"
+	}
+
+	return &gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{
+			{
+				{Text: "Sync contacts", CallbackData: "menu_sync"},
+				{Text: "Clean up", CallbackData: "menu_cleanup"},
+			},
+			{
+				{Text: "Stats", CallbackData: "menu_stats"},
+				{Text: maintenanceText, CallbackData: "menu_maintenance"},
+			},
+		},
+	}
+}
+
+func MenuCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	_, err := utils.TgReplyTextByContext(b, c, "What do you want to do?", makeMenuKeyboard(), false)
+	return err
+}
+
+// MenuCallbackHandler runs the action of a /menu button. The results are sent
+// as replies to the menu, the same way as if the command was used.
+func MenuCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	cq := c.CallbackQuery
+
+	switch strings.TrimPrefix(cq.Data, "menu_") {
+
+	case "sync":
+		cq.Answer(b, nil)
+		return SyncContactsHandler(b, c)
+
+	case "cleanup":
+		cq.Answer(b, nil)
+		return CleanupCommandHandler(b, c)
+
+	case "stats":
+		cq.Answer(b, nil)
+		return StartCommandHandler(b, c)
+
+	case "maintenance":
+		var text string
+		if whatsapp.InMaintenanceMode() {
+			buffered, err := whatsapp.LeaveMaintenanceMode()
+			text = fmt.Sprintf("Maintenance mode is off, bridging %d WhatsApp updates received in the meantime", buffered)
+			if err != nil {
+				text = maintenanceErrorText(err)
+			}
+		} else {
+			text = "Maintenance mode is on, press the button again to resume bridging"
+			if err := whatsapp.EnterMaintenanceMode(); err != nil {
+				text = maintenanceErrorText(err)
+			}
+		}
+		cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{Text: text})
+
+		_, _, err := b.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+			ChatId:      c.EffectiveChat.Id,
+			MessageId:   c.EffectiveMessage.MessageId,
+			ReplyMarkup: *makeMenuKeyboard(),
+		})
+		return err
+
+	default:
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Invalid callback query",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+}
+
 func RestartWhatsAppConnectionHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
@@ -553,7 +1075,7 @@ func RestartWhatsAppConnectionHandler(b *gotgbot.Bot, c *ext.Context) error {
 	waClient.Disconnect()
 	err := waClient.Connect()
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to reconnect to WA servers", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryConnection, "Failed to reconnect to WA servers", err)
 	}
 
 	_, err = utils.TgReplyTextByContext(b, c, "Successfully restarted the WhatsApp connection", nil, false)
@@ -578,7 +1100,7 @@ func JoinInviteLinkHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	groupID, err := waClient.JoinGroupWithLink(context.Background(), inviteLink)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to join", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to join", err)
 	}
 
 	_, err = utils.TgReplyTextByContext(b, c,
@@ -605,7 +1127,7 @@ func SetTargetGroupChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		cfg      = state.State.Config
+		cfg      = state.State.Config()
 		groupID  = args[1]
 		waClient = state.State.WhatsAppClient
 	)
@@ -613,13 +1135,13 @@ func SetTargetGroupChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	groupJID, _ := utils.WaParseJID(groupID)
 	groupInfo, err := waClient.GetGroupInfo(context.Background(), groupJID)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to get group info", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get group info", err)
 	}
 	groupJID = groupInfo.JID
 
 	_, threadFound, err := database.ChatThreadGetTgFromWa(groupJID.String(), cfg.Telegram.TargetChatID)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to check database for existing mapping", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to check database for existing mapping", err)
 	} else if threadFound {
 		_, err = utils.TgReplyTextByContext(b, c, "A topic already exists in database for the given WhatsApp chat. Aborting...", nil, false)
 		return err
@@ -627,40 +1149,269 @@ func SetTargetGroupChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	err = database.ChatThreadAddNewPair(groupJID.String(), cfg.Telegram.TargetChatID, c.EffectiveMessage.MessageThreadId)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to add the mapping in database. Unsuccessful", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to add the mapping in database. Unsuccessful", err)
 	}
 
 	_, err = utils.TgReplyTextByContext(b, c, "Successfully mapped", nil, false)
 	return err
 }
 
-func UnlinkThreadHandler(b *gotgbot.Bot, c *ext.Context) error {
+func LinkHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
 	}
 
-	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
-		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic", nil, false)
-		return err
-	}
-
 	var (
 		tgChatId   = c.EffectiveChat.Id
 		tgThreadId = c.EffectiveMessage.MessageThreadId
+		args       = c.Args()
 	)
 
-	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
-	if err != nil {
-		err = utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
-		return err
-	} else if waChatId == "" {
-		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil, false)
+	// An existing topic was named explicitly, it can be shared by several chats
+	if len(args) > 2 {
+		return linkToExistingThread(b, c, args[1], args[2])
+	}
+
+	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic", nil, false)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get existing chat ID pairing", err)
+	} else if waChatId != "" {
+		_, err := utils.TgReplyTextByContext(b, c, fmt.Sprintf("This topic is already linked to <code>%s</code>, use /unlinkthread first",
+			html.EscapeString(waChatId)), nil, false)
+		return err
+	}
+
+	if len(args) > 1 {
+		waChatJID, ok := utils.WaParseJID(args[1])
+		if !ok {
+			_, err := utils.TgReplyTextByContext(b, c, "Provided JID is not valid", nil, false)
+			return err
+		}
+		text, err := linkThreadToWaChat(tgChatId, tgThreadId, waChatJID)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, text, err)
+		}
+		_, err = utils.TgReplyTextByContext(b, c, text, nil, false)
+		return err
+	}
+
+	candidates, err := utils.WaGetLinkCandidates(tgChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to list the WhatsApp chats", err)
+	} else if len(candidates) == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "No unlinked WhatsApp chats found", nil, false)
+		return err
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, "Choose the WhatsApp chat to link to this topic:",
+		utils.TgMakeLinkKeyboard(candidates, 0), false)
+	return err
+}
+
+func LinkCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		cq         = c.CallbackQuery
+		data       = strings.SplitN(cq.Data, "_", 3)
+		tgChatId   = c.EffectiveChat.Id
+		tgThreadId = c.EffectiveMessage.MessageThreadId
+	)
+
+	if len(data) != 3 {
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Invalid callback query",
+			ShowAlert: true,
+			CacheTime: 60,
+		})
+		return err
+	}
+
+	switch data[1] {
+
+	case "p":
+		page, err := strconv.Atoi(data[2])
+		if err != nil || page < 0 {
+			_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+				Text:      "Invalid callback query",
+				ShowAlert: true,
+				CacheTime: 60,
+			})
+			return err
+		}
+
+		candidates, err := utils.WaGetLinkCandidates(tgChatId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to list the WhatsApp chats", err)
+		}
+		if maxPage := (len(candidates) - 1) / utils.LinkCandidatesPerPage; page > maxPage {
+			page = max(maxPage, 0)
+		}
+
+		_, _, err = b.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+			ChatId:      tgChatId,
+			MessageId:   c.EffectiveMessage.MessageId,
+			ReplyMarkup: *utils.TgMakeLinkKeyboard(candidates, page),
+		})
+		cq.Answer(b, nil)
+		return err
+
+	case "c":
+		waChatJID, ok := utils.WaParseJID(data[2])
+		if !ok {
+			_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+				Text:      "Invalid callback query",
+				ShowAlert: true,
+				CacheTime: 60,
+			})
+			return err
+		}
+
+		if waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId); err == nil && waChatId != "" {
+			_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+				Text:      "This topic is already linked to " + waChatId,
+				ShowAlert: true,
+			})
+			return err
+		}
+
+		// Keep the picker open so that another chat can be chosen
+		if _, threadFound, err := database.ChatThreadGetTgFromWa(waChatJID.String(), tgChatId); err == nil && threadFound {
+			_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+				Text:      "This chat is already linked to another topic",
+				ShowAlert: true,
+			})
+			return err
+		}
+
+		text, err := linkThreadToWaChat(tgChatId, tgThreadId, waChatJID)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, text, err)
+		}
+
+		b.EditMessageText(text, &gotgbot.EditMessageTextOpts{
+			ChatId:    tgChatId,
+			MessageId: c.EffectiveMessage.MessageId,
+			ReplyMarkup: gotgbot.InlineKeyboardMarkup{
+				InlineKeyboard: [][]gotgbot.InlineKeyboardButton{},
+			},
+		})
+		_, err = cq.Answer(b, nil)
+		return err
+	}
+
+	_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text:      "Invalid callback query",
+		ShowAlert: true,
+		CacheTime: 60,
+	})
+	return err
+}
+
+// linkToExistingThread handles /link <wa_jid> <thread_id>, which maps the
+// chat to a topic that may already be used by other chats.
+func linkToExistingThread(b *gotgbot.Bot, c *ext.Context, waChatId, threadIdString string) error {
+	tgChatId := c.EffectiveChat.Id
+
+	waChatJID, ok := utils.WaParseJID(waChatId)
+	if !ok {
+		_, err := utils.TgReplyTextByContext(b, c, "Provided JID is not valid", nil, false)
+		return err
+	}
+
+	tgThreadId, err := strconv.ParseInt(threadIdString, 10, 64)
+	if err != nil || tgThreadId < 0 || utils.TgIsGeneralThread(tgThreadId) {
+		_, err := utils.TgReplyTextByContext(b, c, "Provided thread ID is not valid", nil, false)
+		return err
+	}
+
+	manuallyClosed, err := database.ChatThreadGetManuallyClosed(tgChatId, tgThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get existing chat ID pairing", err)
+	}
+
+	// Probe the topic the same way the cleanup scheduler does, closing the
+	// topics closed with /close so that they stay closed
+	if manuallyClosed {
+		_, err = queue.TgCloseForumTopic(b, tgChatId, tgThreadId, nil)
+	} else {
+		_, err = queue.TgReopenForumTopic(b, tgChatId, tgThreadId, nil)
+	}
+	if scheduler.IsTopicNotFound(err) {
+		_, err := utils.TgReplyTextByContext(b, c, fmt.Sprintf("No topic with ID <code>%d</code> exists", tgThreadId), nil, false)
+		return err
+	} else if err != nil && !scheduler.IsTopicNotModified(err) {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to check if the topic exists", err)
+	}
+
+	text, err := linkThreadToWaChat(tgChatId, tgThreadId, waChatJID)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, text, err)
+	}
+	// The new pair must also keep the topic closed
+	if manuallyClosed {
+		if err = database.ChatThreadSetManuallyClosed(tgChatId, tgThreadId, true); err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to save the topic state in database", err)
+		}
+	}
+	_, err = utils.TgReplyTextByContext(b, c, text, nil, false)
+	return err
+}
+
+// linkThreadToWaChat maps a topic to a WhatsApp chat unless the chat already
+// has a topic. The returned text describes the outcome, or the failure if an
+// error is returned.
+func linkThreadToWaChat(tgChatId, tgThreadId int64, waChatJID waTypes.JID) (string, error) {
+	existingThreadId, threadFound, err := database.ChatThreadGetTgFromWa(waChatJID.String(), tgChatId)
+	if err != nil {
+		return "Failed to check database for existing mapping", err
+	} else if threadFound {
+		return fmt.Sprintf("<code>%s</code> is already linked to another topic (<code>%d</code>), unlink it first",
+			html.EscapeString(waChatJID.String()), existingThreadId), nil
+	}
+
+	err = database.ChatThreadAddNewPair(waChatJID.String(), tgChatId, tgThreadId)
+	if err != nil {
+		return "Failed to add the mapping in database. Unsuccessful", err
+	}
+
+	return fmt.Sprintf("Successfully linked to <code>%s</code>", html.EscapeString(waChatJID.String())), nil
+}
+
+func UnlinkThreadHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	if !c.EffectiveMessage.IsTopicMessage || c.EffectiveMessage.MessageThreadId == 0 {
+		_, err := utils.TgReplyTextByContext(b, c, "The command should be sent in a topic", nil, false)
+		return err
+	}
+
+	var (
+		tgChatId   = c.EffectiveChat.Id
+		tgThreadId = c.EffectiveMessage.MessageThreadId
+	)
+
+	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
+	if err != nil {
+		err = utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get existing chat ID pairing", err)
+		return err
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil, false)
 		return err
 	}
 
 	err = database.ChatThreadDropPairByTg(tgChatId, tgThreadId)
 	if err != nil {
-		err = utils.TgReplyWithErrorByContext(b, c, "Failed to delete the thread chat pairing", err)
+		err = utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to delete the thread chat pairing", err)
 		return err
 	}
 
@@ -684,7 +1435,7 @@ func handleBlockUnblockUser(b *gotgbot.Bot, c *ext.Context, action events.Blockl
 
 	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
 	if err != nil {
-		err = utils.TgReplyWithErrorByContext(b, c, "Failed to get existing chat ID pairing", err)
+		err = utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get existing chat ID pairing", err)
 		return err
 	} else if waChatId == "" {
 		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil, false)
@@ -693,7 +1444,7 @@ func handleBlockUnblockUser(b *gotgbot.Bot, c *ext.Context, action events.Blockl
 	jid, _ := utils.WaParseJID(waChatId)
 	_, err = state.State.WhatsAppClient.UpdateBlocklist(context.Background(), jid, action)
 	if err != nil {
-		err = utils.TgReplyWithErrorByContext(b, c, "Failed to change the blocklist status", err)
+		err = utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to change the blocklist status", err)
 		return err
 	}
 	actionText := "blocked"
@@ -713,6 +1464,194 @@ func UnblockCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	return handleBlockUnblockUser(b, c, events.BlocklistChangeActionUnblock)
 }
 
+func handleCloseOpenTopic(b *gotgbot.Bot, c *ext.Context, closeTopic bool) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	commandName := "open"
+	if closeTopic {
+		commandName = "close"
+	}
+	usageString := "Usage: <code>" + html.EscapeString("/"+commandName+" <topic_id>") + "</code> or send <code>/" + commandName + "</code> in a topic"
+
+	var (
+		tgChatId   = c.EffectiveChat.Id
+		tgThreadId int64
+		args       = c.Args()
+	)
+
+	if len(args) > 1 {
+		parsedThreadId, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+			return err
+		}
+		tgThreadId = parsedThreadId
+	} else if c.EffectiveMessage.IsTopicMessage && c.EffectiveMessage.MessageThreadId != 0 {
+		tgThreadId = c.EffectiveMessage.MessageThreadId
+	} else {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil, false)
+		return err
+	}
+
+	if closeTopic {
+		_, err = queue.TgCloseForumTopic(b, tgChatId, tgThreadId, nil)
+	} else {
+		_, err = queue.TgReopenForumTopic(b, tgChatId, tgThreadId, nil)
+	}
+	if err != nil && !strings.Contains(strings.ToUpper(err.Error()), "TOPIC_NOT_MODIFIED") {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, fmt.Sprintf("Failed to %s the topic", commandName), err)
+	}
+
+	err = database.ChatThreadSetManuallyClosed(tgChatId, tgThreadId, closeTopic)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to save the topic state in database", err)
+	}
+
+	actionText := "reopened"
+	if closeTopic {
+		actionText = "closed"
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, fmt.Sprintf("Successfully %s the topic", actionText), nil, false)
+	return err
+}
+
+func CloseTopicHandler(b *gotgbot.Bot, c *ext.Context) error {
+	return handleCloseOpenTopic(b, c, true)
+}
+
+func OpenTopicHandler(b *gotgbot.Bot, c *ext.Context) error {
+	return handleCloseOpenTopic(b, c, false)
+}
+
+func handleMediaToggle(b *gotgbot.Bot, c *ext.Context, enableMedia bool) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	commandName := "nomedia"
+	if enableMedia {
+		commandName = "media"
+	}
+	usageString := "Usage: <code>" + html.EscapeString("/"+commandName+" <topic_id>") + "</code> or send <code>/" + commandName + "</code> in a topic"
+
+	var (
+		tgChatId   = c.EffectiveChat.Id
+		tgThreadId int64
+		args       = c.Args()
+	)
+
+	if len(args) > 1 {
+		parsedThreadId, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+			return err
+		}
+		tgThreadId = parsedThreadId
+	} else if c.EffectiveMessage.IsTopicMessage && c.EffectiveMessage.MessageThreadId != 0 {
+		tgThreadId = c.EffectiveMessage.MessageThreadId
+	} else {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil, false)
+		return err
+	}
+
+	err = database.ChatThreadSetMediaEnabled(tgChatId, tgThreadId, enableMedia)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to save the media setting in database", err)
+	}
+
+	actionText := "disabled"
+	if enableMedia {
+		actionText = "enabled"
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, fmt.Sprintf("Successfully %s media for the topic", actionText), nil, false)
+	return err
+}
+
+func NoMediaHandler(b *gotgbot.Bot, c *ext.Context) error {
+	return handleMediaToggle(b, c, false)
+}
+
+func MediaHandler(b *gotgbot.Bot, c *ext.Context) error {
+	return handleMediaToggle(b, c, true)
+}
+
+func ExportTopicHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage: <code>" + html.EscapeString("/export <topic_id>") + "</code> or send <code>/export</code> in a topic"
+
+	var (
+		tgChatId   = c.EffectiveChat.Id
+		tgThreadId int64
+		args       = c.Args()
+	)
+
+	if len(args) > 1 {
+		parsedThreadId, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+			return err
+		}
+		tgThreadId = parsedThreadId
+	} else if c.EffectiveMessage.IsTopicMessage && c.EffectiveMessage.MessageThreadId != 0 {
+		tgThreadId = c.EffectiveMessage.MessageThreadId
+	} else {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	waChatId, err := database.ChatThreadGetWaFromTg(tgChatId, tgThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to get existing chat ID pairing", err)
+	} else if waChatId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "No existing chat pairing found!!", nil, false)
+		return err
+	}
+
+	transcript, err := utils.TgBuildTopicTranscript(tgChatId, tgThreadId, waChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to build the transcript", err)
+	}
+
+	sendOpts := &gotgbot.SendDocumentOpts{
+		ReplyParameters: &gotgbot.ReplyParameters{
+			MessageId: c.EffectiveMessage.MessageId,
+		},
+	}
+	if c.EffectiveMessage.IsTopicMessage {
+		sendOpts.MessageThreadId = c.EffectiveMessage.MessageThreadId
+	}
+
+	_, err = queue.TgSendDocument(b, tgChatId,
+		gotgbot.InputFileByReader(fmt.Sprintf("transcript_%d.txt", tgThreadId), strings.NewReader(transcript)), sendOpts)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to send the transcript", err)
+	}
+	return nil
+}
+
 func SetTargetPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
@@ -732,7 +1671,7 @@ func SetTargetPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		cfg     = state.State.Config
+		cfg     = state.State.Config()
 		groupID = args[1]
 	)
 
@@ -740,7 +1679,7 @@ func SetTargetPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	_, threadFound, err := database.ChatThreadGetTgFromWa(userJID.String(), cfg.Telegram.TargetChatID)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to check database for existing mapping", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to check database for existing mapping", err)
 	} else if threadFound {
 		_, err = utils.TgReplyTextByContext(b, c, "A topic already exists in database for the given WhatsApp chat. Aborting...", nil, false)
 		return err
@@ -748,7 +1687,7 @@ func SetTargetPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	err = database.ChatThreadAddNewPair(userJID.String(), cfg.Telegram.TargetChatID, c.EffectiveMessage.MessageThreadId)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to add the mapping in database. Unsuccessful", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to add the mapping in database. Unsuccessful", err)
 	}
 
 	_, err = utils.TgReplyTextByContext(b, c, "Successfully mapped", nil, false)
@@ -778,18 +1717,18 @@ func GetProfilePictureHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	ppInfo, err := waClient.GetProfilePictureInfo(context.Background(), userJID, &whatsmeow.GetProfilePictureParams{})
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to fetch profile picture info from WhatsApp", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryDownload, "Failed to fetch profile picture info from WhatsApp", err)
 	}
 
 	res, err := http.DefaultClient.Get(ppInfo.URL)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to make HTTP GET request to profile picture URL", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryDownload, "Failed to make HTTP GET request to profile picture URL", err)
 	}
 	defer res.Body.Close()
 
 	imgBytes, err := io.ReadAll(res.Body)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to read HTTP response body", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryDownload, "Failed to read HTTP response body", err)
 	}
 
 	opts := &gotgbot.SendPhotoOpts{
@@ -802,7 +1741,7 @@ func GetProfilePictureHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 	_, err = queue.TgSendPhoto(b, c.EffectiveChat.Id, &gotgbot.FileReader{Data: bytes.NewReader(imgBytes)}, opts)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "Failed to send photo", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to send photo", err)
 	}
 
 	return nil
@@ -815,7 +1754,7 @@ func SyncTopicNamesHandler(b *gotgbot.Bot, c *ext.Context) error {
 	groupID := c.EffectiveChat.Id
 	chatThreadPairs, err := database.ChatThreadGetAllPairs(groupID)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "failed to retreive chat thread pairs from database", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "failed to retreive chat thread pairs from database", err)
 	}
 
 	utils.SyncTopicNameByChatThreadPairs(b, groupID, chatThreadPairs)
@@ -845,6 +1784,11 @@ func SendToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return nil
 	}
 
+	if !state.State.Config().BridgeTgToWa {
+		_, err := utils.TgReplyTextByContext(b, c, "Bridging from Telegram to WhatsApp is disabled in the config", nil, false)
+		return err
+	}
+
 	usageString := "Usage : Reply to a message, <code>" + html.EscapeString("/forward <target_id>") + "</code>\n"
 	usageString += "Example : <code>/forward 911234567890</code>"
 
@@ -891,20 +1835,58 @@ func RevokeCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	waMsgId, _, waChatId, err := database.MsgIdGetWaFromTg(chatId, msgToRevoke.MessageId, msgToRevoke.MessageThreadId)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "failed to retrieve WhatsApp side IDs", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "failed to retrieve WhatsApp side IDs", err)
 	}
 
 	chatJid, _ := utils.WaParseJID(waChatId)
 	revokeMessage := waClient.BuildRevoke(chatJid, waTypes.EmptyJID, waMsgId)
 	_, err = queue.WaSend(context.Background(), chatJid, revokeMessage)
 	if err != nil {
-		return utils.TgReplyWithErrorByContext(b, c, "failed to revoke message", err)
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategorySend, "failed to revoke message", err)
 	}
 
 	_, err = utils.TgReplyTextByContext(b, c, "<i>Successfully revoked</i>", nil, false)
 	return err
 }
 
+// ReprocessCommandHandler asks the phone to resend the WhatsApp message that
+// the replied to message was bridged from, so that it is bridged again.
+func ReprocessCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage : Reply to a message bridged from WhatsApp, <code>/reprocess</code>"
+
+	msgToReprocess := c.EffectiveMessage.ReplyToMessage
+	if msgToReprocess == nil || msgToReprocess.ForumTopicCreated != nil || msgToReprocess.ForumTopicClosed != nil ||
+		msgToReprocess.From == nil || msgToReprocess.From.Id != b.Id {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	waMsgId, participantId, waChatId, err := database.MsgIdGetWaFromTgByMsgId(c.EffectiveChat.Id, msgToReprocess.MessageId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to retrieve WhatsApp side IDs", err)
+	} else if waMsgId == "" {
+		_, err := utils.TgReplyTextByContext(b, c, "That message wasn't bridged from WhatsApp", nil, false)
+		return err
+	}
+
+	chatJid, _ := utils.WaParseJID(waChatId)
+	senderJid, _ := utils.WaParseJID(participantId)
+
+	err = whatsapp.RequestReprocess(chatJid, senderJid, waMsgId, func() {
+		utils.TgReplyTextByContext(b, c, "The message couldn't be retrieved from WhatsApp, it may have been deleted from your phone", nil, false)
+	})
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, utils.ErrorCategoryNone, "Failed to request the message from WhatsApp", err)
+	}
+
+	_, err = utils.TgReplyTextByContext(b, c, "<i>Requested the message from your phone, it will be bridged again once it arrives</i>", nil, false)
+	return err
+}
+
 func RevokeCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
@@ -59,6 +59,7 @@ func setTelegramRateLimit(d time.Duration) {
 
 type autoHandleRateLimitBotClient struct {
 	gotgbot.BotClient
+	jitter func(d time.Duration) time.Duration
 }
 
 func (b *autoHandleRateLimitBotClient) RequestWithContext(ctx context.Context,
@@ -94,6 +95,9 @@ func (b *autoHandleRateLimitBotClient) RequestWithContext(ctx context.Context,
 			d := time.Duration(timeToSleep) * time.Second
 			log.Printf("[auto_handle_rate_limit] 429 on %s – backing off %ds (attempt %d)", method, timeToSleep, attempt)
 			setTelegramRateLimit(d)
+			// The global backoff is shared, so spread out the requests retrying after it
+			WaitTelegramRateLimit()
+			time.Sleep(b.jitter(d))
 			continue
 		}
 
@@ -101,6 +105,11 @@ func (b *autoHandleRateLimitBotClient) RequestWithContext(ctx context.Context,
 	}
 }
 
-func AutoHandleRateLimit(b gotgbot.BotClient) gotgbot.BotClient {
-	return &autoHandleRateLimitBotClient{b}
+// AutoHandleRateLimit returns a middleware that retries requests rejected
+// with 429 once the backoff requested by Telegram, plus the random delay
+// returned by jitter, has passed.
+func AutoHandleRateLimit(jitter func(d time.Duration) time.Duration) func(gotgbot.BotClient) gotgbot.BotClient {
+	return func(b gotgbot.BotClient) gotgbot.BotClient {
+		return &autoHandleRateLimitBotClient{b, jitter}
+	}
 }
@@ -0,0 +1,173 @@
+package middlewares
+
+import (
+	"html"
+	"strings"
+)
+
+// Bridged messages are written as HTML, so with the markdownv2 parse mode
+// they are converted before sending: the tags the bridge uses are turned into
+// their MarkdownV2 markup, and the characters MarkdownV2 reserves are escaped
+// everywhere else.
+
+const markdownV2Reserved = "_*[]()~`>#+-=|{}.!\\"
+
+// EscapeMarkdownV2 escapes the characters that have a meaning in MarkdownV2,
+// so that the text is shown as is.
+func EscapeMarkdownV2(text string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(markdownV2Reserved, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+type markdownV2Writer struct {
+	sb strings.Builder
+
+	// Only ` and \ are escaped inside code and pre, and only ) and \ inside
+	// the URL of a link
+	inCode  bool
+	inPre   bool
+	links   []string
+	inQuote bool
+
+	// Every line of a quote starts with >, and the line after it must not
+	// continue the quote
+	quoteLineStart bool
+	afterQuote     bool
+}
+
+func (w *markdownV2Writer) writeRune(r rune) {
+	if w.afterQuote {
+		w.afterQuote = false
+		if r != '\n' {
+			w.sb.WriteByte('\n')
+		}
+	}
+	if w.quoteLineStart {
+		w.quoteLineStart = false
+		w.sb.WriteByte('>')
+	}
+
+	escape := strings.ContainsRune(markdownV2Reserved, r)
+	if w.inCode || w.inPre {
+		escape = r == '`' || r == '\\'
+	}
+	if escape {
+		w.sb.WriteByte('\\')
+	}
+	w.sb.WriteRune(r)
+
+	if r == '\n' && w.inQuote {
+		w.quoteLineStart = true
+	}
+}
+
+func (w *markdownV2Writer) writeText(text string) {
+	for _, r := range html.UnescapeString(text) {
+		w.writeRune(r)
+	}
+}
+
+func (w *markdownV2Writer) writeMarkup(markup string) {
+	if w.quoteLineStart {
+		w.quoteLineStart = false
+		w.sb.WriteByte('>')
+	}
+	w.sb.WriteString(markup)
+}
+
+func (w *markdownV2Writer) writeTag(tag string) {
+	closing := strings.HasPrefix(tag, "/")
+	tag = strings.TrimPrefix(tag, "/")
+	name, attrs, _ := strings.Cut(tag, " ")
+
+	switch strings.ToLower(name) {
+	case "b", "strong":
+		w.writeMarkup("*")
+	case "i", "em":
+		w.writeMarkup("_")
+	case "u", "ins":
+		w.writeMarkup("__")
+	case "s", "strike", "del":
+		w.writeMarkup("~")
+	case "tg-spoiler":
+		w.writeMarkup("||")
+	case "code":
+		// Already marked by the surrounding pre
+		if w.inPre {
+			return
+		}
+		w.writeMarkup("`")
+		w.inCode = !closing
+	case "pre":
+		if closing {
+			w.writeMarkup("\n```")
+		} else {
+			w.writeMarkup("```\n")
+		}
+		w.inPre = !closing
+	case "a":
+		if closing {
+			if len(w.links) == 0 {
+				return
+			}
+			href := w.links[len(w.links)-1]
+			w.links = w.links[:len(w.links)-1]
+			href = strings.NewReplacer(`\`, `\\`, `)`, `\)`).Replace(href)
+			w.writeMarkup("](" + href + ")")
+		} else {
+			w.links = append(w.links, tagAttribute(attrs, "href"))
+			w.writeMarkup("[")
+		}
+	case "blockquote":
+		if closing {
+			w.inQuote = false
+			w.quoteLineStart = false
+			w.afterQuote = true
+		} else {
+			w.inQuote = true
+			w.quoteLineStart = true
+		}
+	case "br":
+		w.writeRune('\n')
+	}
+}
+
+// tagAttribute returns the value of the named attribute in the attributes of
+// an HTML tag, or "" if it isn't there.
+func tagAttribute(attrs, name string) string {
+	_, value, found := strings.Cut(attrs, name+"=\"")
+	if !found {
+		return ""
+	}
+	value, _, _ = strings.Cut(value, "\"")
+	return html.UnescapeString(value)
+}
+
+// HTMLToMarkdownV2 converts text formatted as HTML, as the bridge writes it,
+// to MarkdownV2. Unknown tags are dropped and their contents kept.
+func HTMLToMarkdownV2(text string) string {
+	var w markdownV2Writer
+	for text != "" {
+		start := strings.IndexByte(text, '<')
+		if start < 0 {
+			w.writeText(text)
+			break
+		}
+		end := strings.IndexByte(text[start:], '>')
+		if end < 0 {
+			w.writeText(text)
+			break
+		}
+
+		w.writeText(text[:start])
+		w.writeTag(text[start+1 : start+end])
+		text = text[start+end+1:]
+	}
+	return w.sb.String()
+}
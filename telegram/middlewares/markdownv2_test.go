@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	const text = "_*[]()~`>#+-=|{}.!\\ plain"
+	const want = "\\_\\*\\[\\]\\(\\)\\~\\`\\>\\#\\+\\-\\=\\|\\{\\}\\.\\!\\\\ plain"
+	if got := EscapeMarkdownV2(text); got != want {
+		t.Errorf("EscapeMarkdownV2(%q) = %q, want %q", text, got, want)
+	}
+}
+
+func TestHTMLToMarkdownV2(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "reserved characters",
+			html: "Price: 1.50 (approx.) - 50% off! #deal a_b*c",
+			want: "Price: 1\\.50 \\(approx\\.\\) \\- 50% off\\! \\#deal a\\_b\\*c",
+		},
+		{
+			name: "entities",
+			html: "&lt;tag&gt; &amp; &quot;quoted&quot;",
+			want: "<tag\\> & \"quoted\"",
+		},
+		{
+			name: "formatting",
+			html: "<b>John (work)</b>: <i>hi.</i> <s>x</s> <u>y</u>",
+			want: "*John \\(work\\)*: _hi\\._ ~x~ __y__",
+		},
+		{
+			name: "code",
+			html: "<code>a_b.c `d` \\e</code>",
+			want: "`a_b.c \\`d\\` \\\\e`",
+		},
+		{
+			name: "pre",
+			html: "<pre><code>x = 1.5\n</code></pre>",
+			want: "```\nx = 1.5\n\n```",
+		},
+		{
+			name: "link",
+			html: "<a href=\"https://t.me/c/123/4?a=(1)&amp;b\">topic.name</a>",
+			want: "[topic\\.name](https://t.me/c/123/4?a=(1\\)&b)",
+		},
+		{
+			name: "quote",
+			html: "Reply:\n<blockquote>first line.\nsecond</blockquote>after",
+			want: "Reply:\n>first line\\.\n>second\nafter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTMLToMarkdownV2(tt.html); got != tt.want {
+				t.Errorf("HTMLToMarkdownV2(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
+
+type recordingBotClient struct {
+	gotgbot.BotClient
+	params map[string]any
+}
+
+func (c *recordingBotClient) RequestWithContext(ctx context.Context, token string, method string, params map[string]any, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	c.params = params
+	return json.RawMessage("true"), nil
+}
+
+func TestDefaultParseModeMarkdownV2(t *testing.T) {
+	recorder := &recordingBotClient{}
+	client := DefaultParseMode("MarkdownV2")(recorder)
+
+	client.RequestWithContext(context.Background(), "", "sendMessage", map[string]any{"text": "<b>Hi!</b>"}, nil)
+	if got, want := recorder.params["text"], "*Hi\\!*"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if got := recorder.params["parse_mode"]; got != gotgbot.ParseModeMarkdownV2 {
+		t.Errorf("parse_mode = %q, want %q", got, gotgbot.ParseModeMarkdownV2)
+	}
+
+	// Requests that choose their own parse mode are left alone
+	client.RequestWithContext(context.Background(), "", "sendMessage", map[string]any{"text": "<b>Hi!</b>", "parse_mode": gotgbot.ParseModeHTML}, nil)
+	if got, want := recorder.params["text"], "<b>Hi!</b>"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+type parseModeBotClient struct {
+	gotgbot.BotClient
+	parseMode string
+}
+
+func (b *parseModeBotClient) RequestWithContext(ctx context.Context,
+	token string, method string, params map[string]any,
+	opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+
+	if strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit") {
+		if mode, found := params["parse_mode"]; !found || mode == "" {
+			params["parse_mode"] = b.parseMode
+			if b.parseMode == gotgbot.ParseModeMarkdownV2 {
+				for _, key := range []string{"text", "caption"} {
+					if text, ok := params[key].(string); ok {
+						params[key] = HTMLToMarkdownV2(text)
+					}
+				}
+			}
+		}
+	}
+
+	return b.BotClient.RequestWithContext(ctx, token, method, params, opts)
+}
+
+// DefaultParseMode returns a middleware that sets parse_mode on send and edit
+// requests which don't specify one. The "none" mode sends the text as is, and
+// "markdownv2" converts the HTML the bridge writes before sending it.
+func DefaultParseMode(parseMode string) func(gotgbot.BotClient) gotgbot.BotClient {
+	return func(b gotgbot.BotClient) gotgbot.BotClient {
+		switch strings.ToLower(parseMode) {
+		case "none":
+			return b
+		case "markdownv2":
+			return &parseModeBotClient{b, gotgbot.ParseModeMarkdownV2}
+		default:
+			return &parseModeBotClient{b, gotgbot.ParseModeHTML}
+		}
+	}
+}
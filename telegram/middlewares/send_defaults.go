@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// SendDefaultsOpts holds the options applied to outgoing requests which
+// don't set them explicitly.
+type SendDefaultsOpts struct {
+	ProtectContent           bool
+	DisableWebPagePreview    bool
+	AllowSendingWithoutReply bool
+}
+
+type sendDefaultsBotClient struct {
+	gotgbot.BotClient
+	opts SendDefaultsOpts
+}
+
+func (b *sendDefaultsBotClient) RequestWithContext(ctx context.Context,
+	token string, method string, params map[string]any,
+	opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+
+	var (
+		isSend = strings.HasPrefix(method, "send")
+		isEdit = strings.HasPrefix(method, "edit")
+		isCopy = method == "copyMessage" || method == "forwardMessage"
+	)
+
+	if b.opts.ProtectContent && (isSend || isCopy) {
+		setParamIfMissing(params, "protect_content", "true")
+	}
+	if b.opts.DisableWebPagePreview && (isSend || isEdit) {
+		setParamIfMissing(params, "disable_web_page_preview", "true")
+	}
+	if b.opts.AllowSendingWithoutReply && (isSend || method == "copyMessage") {
+		setParamIfMissing(params, "allow_sending_without_reply", "true")
+	}
+
+	return b.BotClient.RequestWithContext(ctx, token, method, params, opts)
+}
+
+func setParamIfMissing(params map[string]any, key string, value string) {
+	if v, found := params[key]; !found || v == "" {
+		params[key] = value
+	}
+}
+
+// SendDefaults returns a middleware that applies the given options to send,
+// edit and copy requests.
+func SendDefaults(opts SendDefaultsOpts) func(gotgbot.BotClient) gotgbot.BotClient {
+	return func(b gotgbot.BotClient) gotgbot.BotClient {
+		return &sendDefaultsBotClient{b, opts}
+	}
+}
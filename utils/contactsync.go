@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// Besides the scheduled sync, the contacts are synced when a message arrives
+// from a sender that isn't in the database yet, so that their topic and sender
+// line get a proper name right away. Each sender is retried at most once per
+// unknown_contact_sync_cooldown_minutes, and no more than
+// unknown_contact_sync_max_per_hour syncs run in total. The sync runs in the
+// background so that the message isn't held up, so the topic of a new private
+// chat is usually created with the phone number, and renamed once the sync
+// resolves the name. Senders a successful sync didn't resolve, like group
+// members who aren't in the contacts, aren't tried again until the cooldown
+// has passed.
+
+var (
+	unknownContactSyncMu      sync.Mutex
+	unknownContactLastSync    = make(map[string]time.Time)
+	unknownContactSyncWindow  []time.Time
+	unknownContactUnresolved  = make(map[string]time.Time)
+	unknownContactSyncRunning bool
+)
+
+// WaSyncContactsIfUnknown starts syncing the contacts if the sender isn't in
+// the database and the cooldowns allow it. It reports whether a sync was
+// started.
+func WaSyncContactsIfUnknown(sender types.JID) bool {
+	var (
+		cfg    = state.State.Config()
+		logger = state.State.Logger
+	)
+
+	cooldown := time.Duration(cfg.WhatsApp.UnknownContactSyncCooldownMinutes) * time.Minute
+	if cooldown <= 0 || sender.Server == types.GroupServer || sender.Server == types.BroadcastServer {
+		return false
+	}
+
+	// The chat of the sender may use either of its IDs
+	chatIds := []string{sender.ToNonAD().String()}
+	if sender.Server == types.HiddenUserServer {
+		pn, err := state.State.WhatsAppClient.Store.LIDs.GetPNForLID(context.Background(), sender)
+		if err == nil && !pn.IsEmpty() {
+			sender = pn
+			chatIds = append(chatIds, pn.ToNonAD().String())
+		}
+	}
+	sender = sender.ToNonAD()
+
+	if _, _, _, _, found, err := database.ContactNameGet(sender.User, sender.Server); err != nil || found {
+		return false
+	}
+
+	now := time.Now()
+	unknownContactSyncMu.Lock()
+	if unknownContactSyncRunning {
+		unknownContactSyncMu.Unlock()
+		return false
+	}
+	if unresolvedAt, found := unknownContactUnresolved[sender.String()]; found && now.Sub(unresolvedAt) < cooldown {
+		unknownContactSyncMu.Unlock()
+		return false
+	}
+	if last, found := unknownContactLastSync[sender.String()]; found && now.Sub(last) < cooldown {
+		unknownContactSyncMu.Unlock()
+		return false
+	}
+
+	recent := unknownContactSyncWindow[:0]
+	for _, t := range unknownContactSyncWindow {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	unknownContactSyncWindow = recent
+	if maxPerHour := cfg.WhatsApp.UnknownContactSyncMaxPerHour; maxPerHour > 0 && len(recent) >= maxPerHour {
+		unknownContactSyncMu.Unlock()
+		logger.Debug("not syncing contacts for an unknown sender, unknown_contact_sync_max_per_hour reached",
+			zap.String("sender_jid", sender.String()),
+		)
+		return false
+	}
+
+	unknownContactLastSync[sender.String()] = now
+	unknownContactSyncWindow = append(unknownContactSyncWindow, now)
+	unknownContactSyncRunning = true
+	for _, times := range []map[string]time.Time{unknownContactLastSync, unknownContactUnresolved} {
+		for jid, at := range times {
+			if now.Sub(at) >= cooldown {
+				delete(times, jid)
+			}
+		}
+	}
+	unknownContactSyncMu.Unlock()
+
+	go func() {
+		syncErr := WaSyncContacts()
+		if syncErr != nil {
+			logger.Warn("failed to sync contacts for an unknown sender",
+				zap.String("sender_jid", sender.String()),
+				zap.Error(syncErr),
+			)
+		}
+
+		_, _, _, _, found, err := database.ContactNameGet(sender.User, sender.Server)
+
+		unknownContactSyncMu.Lock()
+		unknownContactSyncRunning = false
+		if syncErr == nil && err == nil && !found {
+			unknownContactUnresolved[sender.String()] = time.Now()
+		}
+		unknownContactSyncMu.Unlock()
+
+		if err == nil && found {
+			renameUnknownContactTopic(chatIds)
+		}
+	}()
+	return true
+}
+
+// renameUnknownContactTopic gives the topic of the private chat of a sender
+// whose name was just resolved that name, if the topic exists already.
+func renameUnknownContactTopic(chatIds []string) {
+	var (
+		cfg      = state.State.Config()
+		tgBot    = state.State.TelegramBot
+		tgChatId = cfg.Telegram.TargetChatID
+	)
+
+	for _, chatId := range chatIds {
+		threadId, found, err := database.ChatThreadGetTgFromWa(chatId, tgChatId)
+		if err != nil || !found {
+			continue
+		}
+		SyncTopicNameByChatThreadPair(tgBot, tgChatId, database.ChatThreadPair{
+			ID:         chatId,
+			TgChatId:   tgChatId,
+			TgThreadId: threadId,
+		})
+		return
+	}
+}
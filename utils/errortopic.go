@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"sync"
+	"time"
+
+	"watgbridge/queue"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+// Errors of the categories listed in error_topic_categories are mirrored to a
+// shared "Errors" topic, so that the bridge can be monitored without access to
+// the logs. Repeats of the same error within error_topic_cooldown_seconds are
+// counted instead of posted, and once the cooldown has passed the count is
+// posted along with the last of them.
+
+const (
+	ErrorCategorySend       = "send"
+	ErrorCategoryDownload   = "download"
+	ErrorCategoryBan        = "ban"
+	ErrorCategoryConnection = "connection"
+
+	// ErrorCategoryNone is for errors that are only replied to, like failed
+	// commands, and never reported
+	ErrorCategoryNone = ""
+)
+
+type reportedError struct {
+	lastSent   time.Time
+	suppressed int
+	lastErr    error
+	flushTimer *time.Timer // Posts the suppressed count when the cooldown ends
+}
+
+var (
+	reportedErrorsMu sync.Mutex
+	reportedErrors   = make(map[string]*reportedError)
+)
+
+// TgReportError posts the error to the "Errors" topic in the background if
+// its category is enabled. err may be nil.
+func TgReportError(category, description string, err error) {
+	cfg := state.State.Config()
+	if category == ErrorCategoryNone || !slices.Contains(cfg.Telegram.ErrorTopicCategories, category) {
+		return
+	}
+
+	key := category + "|" + description
+	now := time.Now()
+	cooldown := time.Duration(cfg.Telegram.ErrorTopicCooldownSeconds) * time.Second
+
+	reportedErrorsMu.Lock()
+	reported, found := reportedErrors[key]
+	if found && now.Sub(reported.lastSent) < cooldown {
+		reported.suppressed++
+		reported.lastErr = err
+		if reported.flushTimer == nil {
+			reported.flushTimer = time.AfterFunc(cooldown-now.Sub(reported.lastSent), func() {
+				flushReportedError(key, category, description)
+			})
+		}
+		reportedErrorsMu.Unlock()
+		return
+	}
+	suppressed := 0
+	if found {
+		suppressed = reported.suppressed
+		if reported.flushTimer != nil {
+			reported.flushTimer.Stop()
+		}
+	}
+	reportedErrors[key] = &reportedError{lastSent: now}
+	for k, r := range reportedErrors {
+		if now.Sub(r.lastSent) >= cooldown && r.suppressed == 0 {
+			delete(reportedErrors, k)
+		}
+	}
+	reportedErrorsMu.Unlock()
+
+	postErrorReport(category, description, err, suppressed)
+}
+
+// flushReportedError posts the repeats of an error that were suppressed
+// during its cooldown, unless a new report already included them.
+func flushReportedError(key, category, description string) {
+	reportedErrorsMu.Lock()
+	reported, found := reportedErrors[key]
+	if !found || reported.suppressed == 0 {
+		reportedErrorsMu.Unlock()
+		return
+	}
+	suppressed, lastErr := reported.suppressed, reported.lastErr
+	// The post starts a new cooldown
+	reportedErrors[key] = &reportedError{lastSent: time.Now()}
+	reportedErrorsMu.Unlock()
+
+	postErrorReport(category, description, lastErr, suppressed-1)
+}
+
+// postErrorReport posts the error to the "Errors" topic in the background,
+// noting how many more like it were suppressed.
+func postErrorReport(category, description string, err error, suppressed int) {
+	cfg := state.State.Config()
+
+	text := fmt.Sprintf("<b>[%s]</b> %s", html.EscapeString(category), html.EscapeString(description))
+	if err != nil {
+		text += fmt.Sprintf(":\n\n<code>%s</code>", html.EscapeString(err.Error()))
+	}
+	if suppressed > 0 {
+		text += fmt.Sprintf("\n\n<i>%d more like this since the last report</i>", suppressed)
+	}
+
+	go func() {
+		tgBot := state.State.TelegramBot
+		threadId, err := TgGetOrMakeThreadFromWa_String("errors", cfg.Telegram.TargetChatID, "Errors")
+		if err != nil {
+			state.State.Logger.Warn("failed to create/find thread id for 'errors'", zap.Error(err))
+			return
+		}
+		if _, err = queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, text, &gotgbot.SendMessageOpts{
+			MessageThreadId: threadId,
+		}); err != nil {
+			state.State.Logger.Warn("failed to post an error to the errors topic", zap.Error(err))
+		}
+	}()
+}
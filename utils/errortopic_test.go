@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"watgbridge/internal/testutil"
+	"watgbridge/state"
+)
+
+func TestTgReportErrorFlushesSuppressed(t *testing.T) {
+	testutil.Setup(t, func(cfg *state.Config) {
+		cfg.Telegram.TargetChatID = -1001234567890
+		cfg.Telegram.ErrorTopicCategories = []string{ErrorCategorySend}
+		cfg.Telegram.ErrorTopicCooldownSeconds = 1
+	})
+	botClient := testutil.UseFakeBot(t)
+
+	waitForReports := func(want int) []testutil.FakeRequest {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			reports := botClient.Requests("sendMessage")
+			if len(reports) >= want || time.Now().After(deadline) {
+				return reports
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for i := range 3 {
+		TgReportError(ErrorCategorySend, "Failed to send a message", fmt.Errorf("attempt %d", i+1))
+	}
+	if reports := waitForReports(1); len(reports) != 1 {
+		t.Fatalf("posted %d reports right away, want 1", len(reports))
+	}
+
+	reports := waitForReports(2)
+	if len(reports) != 2 {
+		t.Fatalf("posted %d reports after the cooldown, want 2", len(reports))
+	}
+	text := reports[1].Params["text"].(string)
+	if !strings.Contains(text, "attempt 3") || !strings.Contains(text, "1 more like this") {
+		t.Errorf("report after the cooldown is %q, want the last error and 1 more", text)
+	}
+
+	// Nothing else was suppressed, so nothing more is posted
+	time.Sleep(1200 * time.Millisecond)
+	if reports := botClient.Requests("sendMessage"); len(reports) != 2 {
+		t.Errorf("posted %d reports, want 2", len(reports))
+	}
+}
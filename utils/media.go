@@ -0,0 +1,397 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"watgbridge/queue"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// mediaShouldStream reports whether media of the given size should be passed
+// through a temporary file instead of being held in memory.
+func mediaShouldStream(size uint64) bool {
+	thresholdMB := state.State.Config().StreamingThresholdMB
+	return thresholdMB > 0 && size > uint64(thresholdMB)*1024*1024
+}
+
+// WaMediaExpired reports whether a WhatsApp media download failed because the
+// file is no longer on WhatsApp's servers, in which case retrying won't help
+// but the sender's phone can be asked to upload it again.
+func WaMediaExpired(err error) bool {
+	return errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith403) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410)
+}
+
+// waWithDownloadRetries runs download up to media_download_retries more times
+// with exponential backoff while it fails with errors that may be transient,
+// such as a hash mismatch of a partially uploaded file or a network error.
+func waWithDownloadRetries(download func() error) error {
+	var (
+		cfg     = state.State.Config()
+		backoff = queue.NewBackoff(time.Second, cfg.RetryJitterFactor)
+		err     error
+	)
+
+	for attempt := 0; attempt <= cfg.WhatsApp.MediaDownloadRetries; attempt++ {
+		if attempt > 0 {
+			state.State.Logger.Debug("retrying a failed WhatsApp media download",
+				zap.Int("attempt", attempt),
+				zap.Error(err),
+			)
+			time.Sleep(backoff.Next())
+		}
+
+		err = download()
+		if err == nil || WaMediaExpired(err) ||
+			errors.Is(err, whatsmeow.ErrNoURLPresent) || errors.Is(err, whatsmeow.ErrUnknownMediaType) {
+			return err
+		}
+	}
+	return err
+}
+
+// WaDownload downloads the media attached to msg into memory, retrying
+// transient failures.
+func WaDownload(msg whatsmeow.DownloadableMessage) ([]byte, error) {
+	var data []byte
+	err := waWithDownloadRetries(func() (err error) {
+		data, err = state.State.WhatsAppClient.Download(context.Background(), msg)
+		return err
+	})
+	return data, err
+}
+
+// WaDownloadMedia downloads the media attached to msg. Small media is kept in
+// memory while media above the streaming threshold is written to a temporary
+// file. The file can be passed as the Data of a gotgbot.FileReader, which
+// streams it into the upload and seeks it back to the start if the request is
+// retried. The returned cleanup function must be called once the reader is no
+// longer needed, even if an error was returned.
+func WaDownloadMedia(msg whatsmeow.DownloadableMessage, size uint64) (io.Reader, func(), error) {
+	waClient := state.State.WhatsAppClient
+
+	if !mediaShouldStream(size) {
+		data, err := WaDownload(msg)
+		if err != nil {
+			return nil, func() {}, err
+		}
+		return bytes.NewReader(data), func() {}, nil
+	}
+
+	file, err := os.CreateTemp("", "watgbridge-download-*")
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("failed to create temporary file : %s", err)
+	}
+	cleanup := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	err = waWithDownloadRetries(func() error {
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return waClient.DownloadToFile(context.Background(), msg, file)
+	})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, cleanup, err
+	}
+	return file, cleanup, nil
+}
+
+// TgUploadToWhatsApp downloads the Telegram file at filePath and uploads it to
+// WhatsApp. Files above the streaming threshold are streamed from Telegram
+// into the upload without being fully loaded into memory.
+func TgUploadToWhatsApp(b *gotgbot.Bot, filePath string, size int64, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	var (
+		cfg      = state.State.Config()
+		waClient = state.State.WhatsAppClient
+	)
+
+	if !mediaShouldStream(uint64(size)) {
+		fileBytes, err := TgDownloadByFilePath(b, filePath)
+		if err != nil {
+			return whatsmeow.UploadResponse{}, err
+		}
+		return waClient.Upload(context.Background(), fileBytes, mediaType)
+	}
+
+	var reader io.ReadCloser
+	if cfg.Telegram.SelfHostedAPI {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return whatsmeow.UploadResponse{}, err
+		}
+		reader = file
+	} else {
+		// The timeout covers streaming the body into the upload as well
+		client := &http.Client{Timeout: time.Duration(cfg.DownloadTimeoutSeconds) * time.Second}
+		res, err := client.Get(fmt.Sprintf("%s/file/bot%s/%s",
+			cfg.Telegram.APIURL, b.Token, filePath))
+		if err != nil {
+			return whatsmeow.UploadResponse{}, err
+		}
+		if res.StatusCode != 200 {
+			res.Body.Close()
+			return whatsmeow.UploadResponse{}, fmt.Errorf("received non-200 status code : %s", res.Status)
+		}
+		reader = res.Body
+	}
+	defer reader.Close()
+
+	return waClient.UploadReader(context.Background(), reader, nil, mediaType)
+}
+
+// ProbeMediaDuration returns the duration of the media in seconds using the
+// ffprobe executable next to ffmpeg_executable, or the one in PATH.
+func ProbeMediaDuration(data []byte) (int64, error) {
+	ffprobePath := "ffprobe"
+	if ffmpegPath := state.State.Config().FfmpegExecutable; ffmpegPath != "" {
+		ffprobePath = filepath.Join(filepath.Dir(ffmpegPath), "ffprobe")
+	}
+
+	cmd := exec.Command(ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		"-i", "pipe:0",
+	)
+	cmd.Stdin = bytes.NewReader(data)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute ffprobe command: %s", err)
+	}
+
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe output: %s", err)
+	}
+	return int64(math.Round(duration)), nil
+}
+
+func ffmpegExecutable() string {
+	if ffmpegPath := state.State.Config().FfmpegExecutable; ffmpegPath != "" {
+		return ffmpegPath
+	}
+	return "ffmpeg"
+}
+
+// TgConvertToWaVoiceNote returns the voice note as OGG/Opus, the only format
+// WhatsApp plays as a PTT voice note, transcoding it with ffmpeg if needed.
+func TgConvertToWaVoiceNote(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte("OggS")) && bytes.Contains(data[:min(len(data), 128)], []byte("OpusHead")) {
+		return data, nil
+	}
+
+	cmd := exec.Command(ffmpegExecutable(),
+		"-i", "pipe:0",
+		"-vn",
+		"-c:a", "libopus",
+		"-b:a", state.State.Config().Media.VoiceNoteBitrate,
+		"-ac", "1",
+		"-ar", "48000",
+		"-f", "ogg",
+		"pipe:1",
+	)
+	cmd.Stdin = bytes.NewReader(data)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+	return output, nil
+}
+
+// TgConvertToWaVideoNote returns the video note as MP4 with H.264 video and
+// AAC audio so that WhatsApp plays it as a round video message, transcoding it
+// with ffmpeg if needed.
+func TgConvertToWaVideoNote(data []byte) ([]byte, error) {
+	if http.DetectContentType(data) == "video/mp4" {
+		return data, nil
+	}
+
+	mediaCfg := state.State.Config().Media
+	args := []string{"-i", "pipe:0", "-c:v", "libx264", "-pix_fmt", "yuv420p"}
+	if mediaCfg.VideoPreset != "" {
+		args = append(args, "-preset", mediaCfg.VideoPreset)
+	}
+	if mediaCfg.VideoCRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(mediaCfg.VideoCRF))
+	}
+	args = append(args, "-c:a", "aac")
+	if mediaCfg.VideoAudioBitrate != "" {
+		args = append(args, "-b:a", mediaCfg.VideoAudioBitrate)
+	}
+	args = append(args, "-movflags", "frag_keyframe+empty_moov", "-f", "mp4", "pipe:1")
+
+	cmd := exec.Command(ffmpegExecutable(), args...)
+	cmd.Stdin = bytes.NewReader(data)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+	return output, nil
+}
+
+// TgRecompressImage scales a photo down to fit within the image_max_dimension
+// config option and recompresses it as a JPEG of image_quality with ffmpeg,
+// returning the new image and its dimensions. Photos that already fit are
+// returned as they are.
+func TgRecompressImage(data []byte, width, height int64) ([]byte, int64, int64, error) {
+	mediaCfg := state.State.Config().Media
+	maxDimension := int64(mediaCfg.ImageMaxDimension)
+	if maxDimension <= 0 || (width <= maxDimension && height <= maxDimension) {
+		return data, width, height, nil
+	}
+
+	newWidth, newHeight := maxDimension, maxDimension
+	if width > height {
+		newHeight = max(1, height*maxDimension/width)
+	} else {
+		newWidth = max(1, width*maxDimension/height)
+	}
+
+	// ffmpeg's JPEG quality goes from 2 (best) to 31 (worst)
+	qscale := 2 + (100-mediaCfg.ImageQuality)*29/99
+
+	cmd := exec.Command(ffmpegExecutable(),
+		"-i", "pipe:0",
+		"-vf", fmt.Sprintf("scale=%d:%d", newWidth, newHeight),
+		"-q:v", strconv.Itoa(qscale),
+		"-f", "mjpeg",
+		"pipe:1",
+	)
+	cmd.Stdin = bytes.NewReader(data)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+	return output, newWidth, newHeight, nil
+}
+
+// DocumentFileNameFields are the values available to the
+// document_filename_template config option.
+type DocumentFileNameFields struct {
+	Date   string // Date the message was sent, as YYYY-MM-DD
+	Sender string // Sender's name as shown in the bridged messages
+	Phone  string // Sender's phone number without the leading +
+	Name   string // Original file name, including the extension
+	Base   string // Original file name without the extension
+	Ext    string // Extension of the original file name, including the dot
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[\x00-\x1f/\\:*?"<>|]+`)
+
+// WaFormatDocumentFileName renders the configured file name template for a
+// document bridged from WhatsApp. The original name is kept if no template is
+// set or it renders to nothing.
+func WaFormatDocumentFileName(original string, sender types.JID, sentAt time.Time) string {
+	const maxFileNameLength = 255
+
+	tmplString := state.State.Config().WhatsApp.DocumentFilenameTemplate
+	if tmplString == "" {
+		return original
+	}
+
+	ext := filepath.Ext(original)
+	fields := DocumentFileNameFields{
+		Date:   sentAt.In(state.State.LocalLocation).Format("2006-01-02"),
+		Sender: WaGetContactName(sender),
+		Phone:  sender.User,
+		Name:   original,
+		Base:   strings.TrimSuffix(original, ext),
+		Ext:    ext,
+	}
+	if sender.Server == types.HiddenUserServer {
+		if pn, err := state.State.WhatsAppClient.Store.LIDs.GetPNForLID(context.Background(), sender); err == nil && !pn.IsEmpty() {
+			fields.Phone = pn.User
+		}
+	}
+
+	tmpl, err := template.New("document_filename").Parse(tmplString)
+	if err != nil {
+		state.State.Logger.Error("failed to parse document_filename_template", zap.Error(err))
+		return original
+	}
+	var rendered strings.Builder
+	if err = tmpl.Execute(&rendered, fields); err != nil {
+		state.State.Logger.Error("failed to render document_filename_template", zap.Error(err))
+		return original
+	}
+
+	newName := unsafeFileNameChars.ReplaceAllString(rendered.String(), "_")
+	newName = strings.Trim(strings.TrimSpace(newName), ".")
+	if newName == "" {
+		return original
+	}
+	if len(newName) > maxFileNameLength {
+		newExt := filepath.Ext(newName)
+		if len(newExt) >= maxFileNameLength {
+			newExt = ""
+		}
+		newName = strings.ToValidUTF8(newName[:maxFileNameLength-len(newExt)], "") + newExt
+	}
+	return newName
+}
+
+// WaGetDocumentThumbnail returns the preview of a WhatsApp document as a JPEG
+// that Telegram accepts as a document thumbnail, or nil if there is none. The
+// inline preview is preferred, otherwise the full thumbnail is downloaded.
+func WaGetDocumentThumbnail(documentMsg *waE2E.DocumentMessage) []byte {
+	const (
+		maxThumbnailSize      = 200 * 1024
+		maxThumbnailDimension = 320
+	)
+
+	thumbnail := documentMsg.GetJPEGThumbnail()
+	if len(thumbnail) == 0 && documentMsg.GetThumbnailDirectPath() != "" {
+		var err error
+		thumbnail, err = state.State.WhatsAppClient.DownloadThumbnail(context.Background(), documentMsg)
+		if err != nil {
+			state.State.Logger.Debug("failed to download the thumbnail of a document", zap.Error(err))
+			return nil
+		}
+	}
+	if len(thumbnail) == 0 || len(thumbnail) > maxThumbnailSize {
+		return nil
+	}
+
+	imgConfig, format, err := image.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil || format != "jpeg" ||
+		imgConfig.Width > maxThumbnailDimension || imgConfig.Height > maxThumbnailDimension {
+		return nil
+	}
+	return thumbnail
+}
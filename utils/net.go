@@ -1,19 +1,107 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"time"
+
+	"watgbridge/queue"
+	"watgbridge/state"
 )
 
+// DownloadError is returned by DownloadFileBytesByURL once all the attempts
+// have failed. StatusCode is 0 when no response was received at all.
+type DownloadError struct {
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *DownloadError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("download of %s failed with status %d : %s", e.URL, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("download of %s failed : %s", e.URL, e.Err)
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// IsClientError reports whether the server rejected the request itself, in
+// which case retrying won't help.
+func (e *DownloadError) IsClientError() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests
+}
+
+// IsServerError reports whether the failure was on the server or network side.
+func (e *DownloadError) IsServerError() bool {
+	return !e.IsClientError()
+}
+
+var errDownloadTooLarge = errors.New("response exceeds download_max_size_mb")
+
+// downloadRetryBackoff is the wait before the first retry, it doubles after
+// every failed attempt.
+var downloadRetryBackoff = time.Second
+
+// DownloadFileBytesByURL fetches url into memory, retrying transient network
+// errors and non-2xx responses with exponential backoff.
 func DownloadFileBytesByURL(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+	var (
+		cfg     = state.State.Config()
+		client  = &http.Client{Timeout: time.Duration(cfg.DownloadTimeoutSeconds) * time.Second}
+		backoff = queue.NewBackoff(downloadRetryBackoff, cfg.RetryJitterFactor)
+		lastErr *DownloadError
+	)
+
+	for attempt := 0; attempt <= cfg.DownloadRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff.Next())
+		}
+
+		data, err := downloadFileBytesOnce(client, url, int64(cfg.DownloadMaxSizeMB)*1024*1024)
+		if err == nil {
+			return data, nil
+		}
+
+		lastErr = err
+		if err.IsClientError() || errors.Is(err, errDownloadTooLarge) {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+func downloadFileBytesOnce(client *http.Client, url string, maxSize int64) ([]byte, *DownloadError) {
+	resp, err := client.Get(url)
 	if err != nil {
-		return nil, err
+		return nil, &DownloadError{URL: url, Err: err}
 	}
 	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, Err: errors.New(resp.Status)}
+	}
+
+	body := io.Reader(resp.Body)
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, Err: err}
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, Err: errDownloadTooLarge}
+	}
+
+	return data, nil
 }
 
 func DownloadFileToLocalByURL(filepath string, url string) error {
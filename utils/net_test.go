@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"watgbridge/internal/testutil"
+	"watgbridge/state"
+)
+
+func setupDownloadTest(t *testing.T) {
+	t.Helper()
+
+	testutil.SetConfig(t, func(cfg *state.Config) {
+		cfg.DownloadRetries = 3
+		cfg.DownloadMaxSizeMB = 1
+	})
+
+	oldBackoff := downloadRetryBackoff
+	downloadRetryBackoff = time.Millisecond
+	t.Cleanup(func() { downloadRetryBackoff = oldBackoff })
+}
+
+func TestDownloadFileBytesByURLRetries(t *testing.T) {
+	setupDownloadTest(t)
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("picture"))
+	}))
+	defer server.Close()
+
+	data, err := DownloadFileBytesByURL(server.URL)
+	if err != nil {
+		t.Fatalf("DownloadFileBytesByURL() failed: %v", err)
+	}
+	if string(data) != "picture" {
+		t.Errorf("DownloadFileBytesByURL() = %q, want %q", data, "picture")
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("made %d requests, want 3", got)
+	}
+}
+
+func TestDownloadFileBytesByURLServerError(t *testing.T) {
+	setupDownloadTest(t)
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := DownloadFileBytesByURL(server.URL)
+	var downloadErr *DownloadError
+	if !errors.As(err, &downloadErr) {
+		t.Fatalf("DownloadFileBytesByURL() error = %v, want a *DownloadError", err)
+	}
+	if downloadErr.StatusCode != http.StatusBadGateway || !downloadErr.IsServerError() {
+		t.Errorf("got status %d (server error %v), want %d as a server error",
+			downloadErr.StatusCode, downloadErr.IsServerError(), http.StatusBadGateway)
+	}
+	if got := requests.Load(); got != 4 {
+		t.Errorf("made %d requests, want 4", got)
+	}
+}
+
+func TestDownloadFileBytesByURLClientError(t *testing.T) {
+	setupDownloadTest(t)
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := DownloadFileBytesByURL(server.URL)
+	var downloadErr *DownloadError
+	if !errors.As(err, &downloadErr) || !downloadErr.IsClientError() {
+		t.Fatalf("DownloadFileBytesByURL() error = %v, want a client error", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1 as client errors aren't retried", got)
+	}
+}
+
+func TestDownloadFileBytesByURLTooLarge(t *testing.T) {
+	setupDownloadTest(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 1024*1024+1)))
+	}))
+	defer server.Close()
+
+	if _, err := DownloadFileBytesByURL(server.URL); !errors.Is(err, errDownloadTooLarge) {
+		t.Errorf("DownloadFileBytesByURL() error = %v, want %v", err, errDownloadTooLarge)
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// With send_my_presence, the account shows as online from the first message
+// sent from Telegram until presence_duration_seconds after the last one, and
+// as offline otherwise. With send_my_typing, the chat also sees you typing
+// (or recording a voice note) while the message is being sent.
+
+var (
+	presenceMu    sync.Mutex
+	presenceTimer *time.Timer
+)
+
+func waSendPresence(presence waTypes.Presence) {
+	if err := state.State.WhatsAppClient.SendPresence(context.Background(), presence); err != nil {
+		state.State.Logger.Warn("failed to send presence",
+			zap.Error(err),
+			zap.String("presence", string(presence)),
+		)
+	}
+}
+
+func waSendChatPresence(chat waTypes.JID, presence waTypes.ChatPresence, media waTypes.ChatPresenceMedia) {
+	if err := state.State.WhatsAppClient.SendChatPresence(context.Background(), chat, presence, media); err != nil {
+		state.State.Logger.Warn("failed to send chat presence",
+			zap.Error(err),
+			zap.String("chat", chat.String()),
+			zap.String("presence", string(presence)),
+		)
+	}
+}
+
+// WaPresenceBeforeSend sends the presence configured for sending a message
+// from Telegram to the chat.
+func WaPresenceBeforeSend(chat waTypes.JID, isVoiceNote bool) {
+	cfg := state.State.Config()
+
+	if cfg.Telegram.SendMyPresence {
+		presenceMu.Lock()
+		if presenceTimer == nil || !presenceTimer.Stop() {
+			waSendPresence(waTypes.PresenceAvailable)
+		}
+		var timer *time.Timer
+		timer = time.AfterFunc(time.Duration(cfg.Telegram.PresenceDurationSeconds)*time.Second, func() {
+			presenceMu.Lock()
+			defer presenceMu.Unlock()
+			if presenceTimer == timer {
+				presenceTimer = nil
+				waSendPresence(waTypes.PresenceUnavailable)
+			}
+		})
+		presenceTimer = timer
+		presenceMu.Unlock()
+	}
+
+	if cfg.Telegram.SendMyTyping {
+		media := waTypes.ChatPresenceMediaText
+		if isVoiceNote {
+			media = waTypes.ChatPresenceMediaAudio
+		}
+		waSendChatPresence(chat, waTypes.ChatPresenceComposing, media)
+	}
+}
+
+// WaPresenceAfterSend stops showing you typing in the chat.
+func WaPresenceAfterSend(chat waTypes.JID) {
+	if state.State.Config().Telegram.SendMyTyping {
+		waSendChatPresence(chat, waTypes.ChatPresencePaused, waTypes.ChatPresenceMediaText)
+	}
+}
+
+// WaPresenceOnConnect sends the presence configured in presence_on_connect,
+// since WhatsApp otherwise decides by itself whether a linked device shows
+// the account as online.
+func WaPresenceOnConnect() {
+	switch state.State.Config().Telegram.PresenceOnConnect {
+	case "available":
+		waSendPresence(waTypes.PresenceAvailable)
+	case "unavailable":
+		waSendPresence(waTypes.PresenceUnavailable)
+	}
+}
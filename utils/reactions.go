@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"strings"
+
+	"watgbridge/state"
+)
+
+// tgAllowedReactions are the emoji Telegram accepts in ReactionTypeEmoji,
+// without variation selectors.
+var tgAllowedReactions = map[string]bool{
+	"👍": true, "👎": true, "❤": true, "🔥": true, "🥰": true, "👏": true, "😁": true, "🤔": true,
+	"🤯": true, "😱": true, "🤬": true, "😢": true, "🎉": true, "🤩": true, "🤮": true, "💩": true,
+	"🙏": true, "👌": true, "🕊": true, "🤡": true, "🥱": true, "🥴": true, "😍": true, "🐳": true,
+	"❤‍🔥": true, "🌚": true, "🌭": true, "💯": true, "🤣": true, "⚡": true, "🍌": true, "🏆": true,
+	"💔": true, "🤨": true, "😐": true, "🍓": true, "🍾": true, "💋": true, "🖕": true, "😈": true,
+	"😴": true, "😭": true, "🤓": true, "👻": true, "👨‍💻": true, "👀": true, "🎃": true, "🙈": true,
+	"😇": true, "😨": true, "🤝": true, "✍": true, "🤗": true, "🫡": true, "🎅": true, "🎄": true,
+	"☃": true, "💅": true, "🤪": true, "🗿": true, "🆒": true, "💘": true, "🙉": true, "🦄": true,
+	"😘": true, "💊": true, "🙊": true, "😎": true, "👾": true, "🤷‍♂": true, "🤷": true, "🤷‍♀": true,
+	"😡": true,
+}
+
+// defaultReactionMap maps the WhatsApp quick reactions Telegram doesn't allow
+// to the closest one it does. reaction_emoji_map entries take precedence.
+var defaultReactionMap = map[string]string{
+	"😂": "🤣",
+	"😮": "😱",
+	"😯": "😱",
+	"🥲": "😢",
+	"😆": "😁",
+	"😀": "😁",
+	"😃": "😁",
+	"😄": "😁",
+	"💗": "❤",
+	"💖": "❤",
+	"💕": "❤",
+	"✅": "👌",
+	"🙌": "👏",
+}
+
+// normalizeReactionEmoji strips variation selectors and skin tone modifiers,
+// which WhatsApp sends but Telegram's reaction list doesn't include.
+func normalizeReactionEmoji(emoji string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\uFE0F' || r == '\uFE0E' || (r >= 0x1F3FB && r <= 0x1F3FF) {
+			return -1
+		}
+		return r
+	}, emoji)
+}
+
+// TgReactionForWaEmoji returns the Telegram reaction to use for a WhatsApp
+// reaction emoji, and false if there is no sensible one.
+func TgReactionForWaEmoji(emoji string) (string, bool) {
+	normalized := normalizeReactionEmoji(emoji)
+
+	for from, to := range state.State.Config().Telegram.ReactionEmojiMap {
+		if from == emoji || normalizeReactionEmoji(from) == normalized {
+			normalizedTo := normalizeReactionEmoji(to)
+			return normalizedTo, tgAllowedReactions[normalizedTo]
+		}
+	}
+
+	if tgAllowedReactions[normalized] {
+		return normalized, true
+	}
+	if mapped, found := defaultReactionMap[normalized]; found {
+		return mapped, true
+	}
+	return "", false
+}
@@ -26,8 +26,8 @@ func TGSConvertToWebp(tgsStickerData []byte, updateId int64) ([]byte, error) {
 	opt := libtgsconverter.NewConverterOptions()
 	opt.SetExtension("webp")
 	var (
-		quality float32 = 100
-		fps     uint    = 30
+		quality      = float32(state.State.Config().Media.StickerQuality)
+		fps     uint = 30
 	)
 	for quality > 2 && fps > 5 {
 		logger.Debug("trying to convert tgs to webp",
@@ -70,7 +70,7 @@ func WebmConvertToWebp(webmStickerData []byte, scale, pad string, updateId int64
 		return nil, err
 	}
 
-	cmd := exec.Command(state.State.Config.FfmpegExecutable,
+	cmd := exec.Command(state.State.Config().FfmpegExecutable,
 		"-i", inputPath,
 		"-fs", "800000",
 		"-vf", fmt.Sprintf("fps=15,scale=%s,format=rgba,pad=%s:color=#00000000", scale, pad),
@@ -110,7 +110,7 @@ func WebpImagePad(inputData []byte, wPad, hPad int, updateId int64) ([]byte, err
 	outputImage := image.NewRGBA(image.Rect(0, 0, outputWidth, outputHeight))
 	draw.Draw(outputImage, image.Rect(wOffset, hOffset, outputWidth-wOffset, outputHeight-hOffset), inputImage, image.Point{}, draw.Src)
 
-	outputBytes, err := webp.EncodeRGBA(outputImage, 100)
+	outputBytes, err := webp.EncodeRGBA(outputImage, float32(state.State.Config().Media.StickerQuality))
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode padded data into Webp: %w", err)
 	}
@@ -160,7 +160,7 @@ func AnimatedWebpConvertToGif(inputData []byte, updateId string) ([]byte, error)
 
 func WebpWriteExifData(inputData []byte, updateId int64) ([]byte, error) {
 	var (
-		cfg           = state.State.Config
+		cfg           = state.State.Config()
 		logger        = state.State.Logger
 		startingBytes = []byte{0x49, 0x49, 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01, 0x00, 0x41, 0x57, 0x07, 0x00}
 		endingBytes   = []byte{0x16, 0x00, 0x00, 0x00}
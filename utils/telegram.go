@@ -3,14 +3,18 @@ package utils
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 	"unicode"
+	"unicode/utf16"
 
 	"watgbridge/database"
 	"watgbridge/queue"
@@ -21,7 +25,6 @@ import (
 	goVCard "github.com/emersion/go-vcard"
 	"github.com/forPelevin/gomoji"
 	"go.mau.fi/whatsmeow"
-	"go.mau.fi/whatsmeow/proto/waCommon"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	waTypes "go.mau.fi/whatsmeow/types"
 	"go.uber.org/zap"
@@ -50,25 +53,247 @@ func TgRegisterBotCommands(b *gotgbot.Bot, commands ...gotgbot.BotCommand) error
 	return err
 }
 
+var topicCreationForbiddenWarning sync.Once
+
+// TgIsMessageNotFound reports whether a Telegram error was caused by the
+// message no longer existing, e.g. because it was deleted by hand.
+func TgIsMessageNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToUpper(err.Error())
+	return strings.Contains(msg, "MESSAGE TO EDIT NOT FOUND") || strings.Contains(msg, "MESSAGE TO DELETE NOT FOUND") ||
+		strings.Contains(msg, "MESSAGE TO PIN NOT FOUND") || strings.Contains(msg, "MESSAGE TO REACT NOT FOUND") ||
+		strings.Contains(msg, "MESSAGE_ID_INVALID")
+}
+
+// TgIsGeneralThread reports whether the thread ID refers to the General topic,
+// which is either unset (0) or general_topic_thread_id depending on the update.
+func TgIsGeneralThread(threadId int64) bool {
+	return threadId == 0 || threadId == state.State.Config().Telegram.GeneralTopicThreadId
+}
+
+// tgIsNotEnoughRights reports whether a Telegram error was caused by the bot
+// missing admin rights in the chat.
+func tgIsNotEnoughRights(err error) bool {
+	msg := strings.ToUpper(err.Error())
+	return strings.Contains(msg, "NOT ENOUGH RIGHTS") || strings.Contains(msg, "CHAT_ADMIN_REQUIRED") ||
+		strings.Contains(msg, "RIGHT_FORBIDDEN")
+}
+
+// TopicNameFields are the values available to the topic_name_template config option.
+type TopicNameFields struct {
+	Name    string // Name the topic would get without a template
+	Phone   string // Phone number in international format, empty for groups
+	Subject string // Group subject, empty for private chats
+	Type    string // "group" or "private"
+}
+
+// TgFormatTopicName renders the configured topic name template for a WhatsApp
+// chat, and prefixes it with its type if topic_type_prefix is set. Chats that
+// aren't groups or users, like status updates and calls, keep the given name.
+func TgFormatTopicName(waChatIdString string, name string) string {
+	const maxTopicNameLength = 128
+
+	cfg := state.State.Config()
+	jid, err := waTypes.ParseJID(waChatIdString)
+	if err != nil || (jid.Server != waTypes.GroupServer && jid.Server != waTypes.DefaultUserServer) {
+		return name
+	}
+
+	newName := tgRenderTopicNameTemplate(cfg.Telegram.TopicNameTemplate, jid, name)
+	if cfg.Telegram.TopicTypePrefix {
+		if jid.Server == waTypes.GroupServer {
+			newName = "👥 " + newName
+		} else {
+			newName = "👤 " + newName
+		}
+	}
+
+	if asRunes := []rune(newName); len(asRunes) > maxTopicNameLength {
+		newName = string(asRunes[:maxTopicNameLength])
+	}
+	return newName
+}
+
+// tgRenderTopicNameTemplate renders topic_name_template, falling back to the
+// given name if it isn't set or renders to nothing.
+func tgRenderTopicNameTemplate(tmplString string, jid waTypes.JID, name string) string {
+	if tmplString == "" {
+		return name
+	}
+
+	fields := TopicNameFields{Name: name}
+	if jid.Server == waTypes.GroupServer {
+		fields.Type = "group"
+		fields.Subject = WaGetGroupName(jid)
+	} else {
+		fields.Type = "private"
+		fields.Phone = "+" + jid.User
+	}
+
+	tmpl, err := template.New("topic_name").Parse(tmplString)
+	if err != nil {
+		state.State.Logger.Error("failed to parse topic_name_template", zap.Error(err))
+		return name
+	}
+	var rendered strings.Builder
+	if err = tmpl.Execute(&rendered, fields); err != nil {
+		state.State.Logger.Error("failed to render topic_name_template", zap.Error(err))
+		return name
+	}
+
+	newName := strings.TrimSpace(rendered.String())
+	if newName == "" {
+		return name
+	}
+	return newName
+}
+
+// topicCreationLocks holds a mutex per WhatsApp chat, so that messages of a
+// chat whose topic is being created wait for it instead of creating another
+// one, and are then bridged in the order they arrived.
+var topicCreationLocks sync.Map
+
+// unsavedTopics holds the topics created while their pair couldn't be saved,
+// keyed like topicCreationLocks, so that the next message of the chat reuses
+// the topic and saves it again instead of creating another one.
+var unsavedTopics sync.Map
+
+// tgIsTransientError reports whether a failed Telegram request may succeed
+// if it is retried, i.e. it was rate limited, failed on Telegram's side or
+// never got a response.
+func tgIsTransientError(err error) bool {
+	var tgErr *gotgbot.TelegramError
+	if errors.As(err, &tgErr) {
+		return tgErr.Code == http.StatusTooManyRequests || tgErr.Code >= 500
+	}
+	return true
+}
+
+// tgCreateForumTopicWithRetry creates a forum topic, retrying transient errors
+// up to topic_creation_retries times with exponential backoff.
+func tgCreateForumTopicWithRetry(tgChatId int64, name string) (*gotgbot.ForumTopic, error) {
+	var (
+		cfg     = state.State.Config()
+		tgBot   = state.State.TelegramBot
+		backoff = queue.NewBackoff(time.Second, cfg.RetryJitterFactor)
+	)
+
+	for attempt := 0; ; attempt++ {
+		newForum, err := queue.TgOpenForumTopic(tgBot, tgChatId, name, &gotgbot.CreateForumTopicOpts{})
+		if err == nil || attempt >= cfg.Telegram.TopicCreationRetries || !tgIsTransientError(err) {
+			return newForum, err
+		}
+
+		state.State.Logger.Warn("failed to create a forum topic, retrying",
+			zap.String("name", name),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err),
+		)
+		time.Sleep(backoff.Next())
+	}
+}
+
+// ErrChatDormant is returned by TgGetOrMakeThreadFromWa instead of creating a
+// topic for a chat whose last message is older than active_chat_window_days.
+var ErrChatDormant = errors.New("the chat had no messages within active_chat_window_days, not creating a topic for it")
+
+// WaChatIsDormant reports whether the last recorded message of the chat is
+// older than active_chat_window_days. Chats without any recorded message
+// aren't dormant, and neither are shared topics like Status or Calls.
+func WaChatIsDormant(waChatIdString string) bool {
+	window := time.Duration(state.State.Config().WhatsApp.ActiveChatWindowDays) * 24 * time.Hour
+	if window <= 0 {
+		return false
+	}
+	jid, err := waTypes.ParseJID(waChatIdString)
+	if err != nil || (jid.Server != waTypes.GroupServer && jid.Server != waTypes.DefaultUserServer) {
+		return false
+	}
+
+	lastMessageAt, found, err := database.ChatActivityGetLastMessageAt(waChatIdString)
+	return err == nil && found && time.Since(lastMessageAt) > window
+}
+
+// WaRecordChatActivity stores the time of the message as the last activity of
+// its chat, which active_chat_window_days is checked against.
+func WaRecordChatActivity(chat waTypes.JID, at time.Time) {
+	if state.State.Config().WhatsApp.ActiveChatWindowDays <= 0 || chat.Server == waTypes.BroadcastServer {
+		return
+	}
+	waChatId, err := WaChatIdForThread(chat)
+	if err != nil {
+		return
+	}
+	if err := database.ChatActivityRecord(waChatId, at); err != nil {
+		state.State.Logger.Warn("failed to record the activity of a chat",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+	}
+}
+
 func TgGetOrMakeThreadFromWa_String(waChatIdString string, tgChatId int64, threadName string) (int64, error) {
+	// Everything goes to the chat itself in the combined feed
+	if state.State.Config().Telegram.CombinedFeed {
+		return 0, nil
+	}
+
 	threadId, threadFound, err := database.ChatThreadGetTgFromWa(waChatIdString, tgChatId)
 	if err != nil {
 		return 0, err
 	}
 
 	if !threadFound {
-		tgBot := state.State.TelegramBot
+		if WaChatIsDormant(waChatIdString) {
+			return 0, ErrChatDormant
+		}
+
+		lockKey := fmt.Sprintf("%s|%d", waChatIdString, tgChatId)
+		lock, _ := topicCreationLocks.LoadOrStore(lockKey, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
 
-		newForum, err := queue.TgOpenForumTopic(tgBot, tgChatId, threadName, &gotgbot.CreateForumTopicOpts{})
+		// The topic may have been created while waiting for the lock
+		threadId, threadFound, err = database.ChatThreadGetTgFromWa(waChatIdString, tgChatId)
 		if err != nil {
 			return 0, err
+		} else if threadFound {
+			return threadId, nil
+		}
+
+		if unsavedThreadId, found := unsavedTopics.Load(lockKey); found {
+			if err := database.ChatThreadAddNewPair(waChatIdString, tgChatId, unsavedThreadId.(int64)); err == nil {
+				unsavedTopics.Delete(lockKey)
+			}
+			return unsavedThreadId.(int64), nil
+		}
+
+		newForum, err := tgCreateForumTopicWithRetry(tgChatId, TgFormatTopicName(waChatIdString, threadName))
+		if err != nil && state.State.Config().Telegram.GeneralTopicFallback && tgIsNotEnoughRights(err) {
+			topicCreationForbiddenWarning.Do(func() {
+				state.State.Logger.Warn("bot is not allowed to create topics, sending messages of new chats to the General topic; grant it the \"Manage Topics\" admin right to fix this",
+					zap.Int64("tg_chat_id", tgChatId),
+					zap.Error(err),
+				)
+			})
+			return 0, nil
+		} else if err != nil {
+			return 0, err
 		}
 		dbErr := database.ChatThreadAddNewPair(waChatIdString, tgChatId, newForum.MessageThreadId)
 		// Send profile picture regardless of DB error so the topic always gets
 		// its pic+pin even if the pair record failed to persist.
 		jid, _ := waTypes.ParseJID(waChatIdString)
-		SendWaProfilePicToTopic(jid, waChatIdString, tgChatId, newForum.MessageThreadId, "WhatsApp profile picture")
+		if jid.Server == waTypes.GroupServer && state.State.Config().WhatsApp.SkipGroupProfilePictures ||
+			jid.Server != waTypes.GroupServer && state.State.Config().WhatsApp.SkipContactProfilePictures {
+			state.State.Logger.Debug("skipping profile picture as configured", zap.String("jid", jid.String()))
+		} else {
+			SendWaProfilePicToTopic(jid, waChatIdString, tgChatId, newForum.MessageThreadId, "WhatsApp profile picture")
+		}
 		if dbErr != nil {
+			unsavedTopics.Store(lockKey, newForum.MessageThreadId)
 			return newForum.MessageThreadId, dbErr
 		}
 		return newForum.MessageThreadId, nil
@@ -78,25 +303,20 @@ func TgGetOrMakeThreadFromWa_String(waChatIdString string, tgChatId int64, threa
 }
 
 func TgGetOrMakeThreadFromWa(waChatId waTypes.JID, tgChatId int64, threadName string) (int64, error) {
-	if waChatId.Server == waTypes.HiddenUserServer {
-		waClient := state.State.WhatsAppClient
-		pn, err := waClient.Store.LIDs.GetPNForLID(context.Background(), waChatId)
-		if err != nil {
-			return 0, err
-		}
-		waChatId = pn
+	waChatIdString, err := WaChatIdForThread(waChatId)
+	if err != nil {
+		return 0, err
 	}
-	waChatIdString := waChatId.ToNonAD().String()
 	return TgGetOrMakeThreadFromWa_String(waChatIdString, tgChatId, threadName)
 }
 
 func TgDownloadByFilePath(b *gotgbot.Bot, filePath string) ([]byte, error) {
-	if state.State.Config.Telegram.SelfHostedAPI {
+	if state.State.Config().Telegram.SelfHostedAPI {
 		return os.ReadFile(filePath)
 	}
 
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/file/bot%s/%s",
-		state.State.Config.Telegram.APIURL, b.Token, filePath), nil)
+		state.State.Config().Telegram.APIURL, b.Token, filePath), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +367,7 @@ func TgSendTextById(b *gotgbot.Bot, chatId int64, threadId int64, text string) e
 
 func TgUpdateIsAuthorized(b *gotgbot.Bot, c *ext.Context) bool {
 	var (
-		cfg         = state.State.Config
+		cfg         = state.State.Config()
 		sender      = c.EffectiveSender.User
 		ownerID     = cfg.Telegram.OwnerID
 		sudoUsersID = cfg.Telegram.SudoUsersID
@@ -169,7 +389,11 @@ func TgUpdateIsAuthorized(b *gotgbot.Bot, c *ext.Context) bool {
 	return false
 }
 
-func TgReplyWithErrorByContext(b *gotgbot.Bot, c *ext.Context, eMessage string, e error) error {
+// TgReplyWithErrorByContext replies with the error, and also reports it in the
+// "Errors" topic under the given category.
+func TgReplyWithErrorByContext(b *gotgbot.Bot, c *ext.Context, category, eMessage string, e error) error {
+	TgReportError(category, eMessage, e)
+
 	if c.CallbackQuery != nil {
 		_, err := c.CallbackQuery.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
 			Text:      eMessage + ":\n\n" + e.Error(),
@@ -203,20 +427,211 @@ func TgSendErrorById(b *gotgbot.Bot, chatId, threadId int64, eMessage string, e
 	return err
 }
 
+// FormattingGuardTriggered reports whether a message is too long, or has too
+// many entities or mentions, for its formatting to be converted. Such messages
+// are bridged as plain text so a crafted message can't stall the send workers.
+func FormattingGuardTriggered(textLength, entityCount int) bool {
+	cfg := state.State.Config()
+	return (cfg.MaxFormattingLength > 0 && textLength > cfg.MaxFormattingLength) ||
+		(cfg.MaxFormattingEntities > 0 && entityCount > cfg.MaxFormattingEntities)
+}
+
+// tgForwardAttribution returns the WhatsApp formatted "Forwarded from" line for
+// a forwarded Telegram message, or an empty string if it wasn't forwarded.
+func tgForwardAttribution(origin gotgbot.MessageOrigin) string {
+	if origin == nil {
+		return ""
+	}
+
+	merged := origin.MergeMessageOrigin()
+	var from string
+	switch {
+	case merged.SenderUser != nil:
+		from = strings.TrimSpace(merged.SenderUser.FirstName + " " + merged.SenderUser.LastName)
+	case merged.SenderUserName != "":
+		from = merged.SenderUserName
+	case merged.SenderChat != nil:
+		from = merged.SenderChat.Title
+	case merged.Chat != nil:
+		from = merged.Chat.Title
+	}
+	if merged.AuthorSignature != "" {
+		from = strings.TrimSpace(from + " (" + merged.AuthorSignature + ")")
+	}
+
+	if from == "" {
+		return "_Forwarded message_"
+	}
+	return "_Forwarded from " + from + "_"
+}
+
+// OutboundFooterFields are the values available to the
+// outbound_footer_template config option.
+type OutboundFooterFields struct {
+	Sender string // Name of the Telegram user who sent the message
+	Time   string // Time the message was sent on Telegram, formatted with time_format
+}
+
+// tgRenderOutboundFooter renders the footer added to messages sent from
+// Telegram to WhatsApp, or returns an empty string if none is configured.
+func tgRenderOutboundFooter(msg *gotgbot.Message) string {
+	cfg := state.State.Config()
+
+	tmplString := cfg.WhatsApp.OutboundFooterTemplate
+	if tmplString == "" {
+		return ""
+	}
+
+	fields := OutboundFooterFields{
+		Time: time.Unix(msg.Date, 0).In(state.State.LocalLocation).Format(cfg.TimeFormat),
+	}
+	if msg.From != nil {
+		fields.Sender = strings.TrimSpace(msg.From.FirstName + " " + msg.From.LastName)
+	}
+
+	tmpl, err := template.New("outbound_footer").Parse(tmplString)
+	if err != nil {
+		state.State.Logger.Error("failed to parse outbound_footer_template", zap.Error(err))
+		return ""
+	}
+	var rendered strings.Builder
+	if err = tmpl.Execute(&rendered, fields); err != nil {
+		state.State.Logger.Error("failed to render outbound_footer_template", zap.Error(err))
+		return ""
+	}
+	return strings.TrimSpace(rendered.String())
+}
+
+// tgFormattingStyles lists the Telegram entity types that have a WhatsApp
+// equivalent, in the order their markers are opened.
+var tgFormattingStyles = []struct {
+	entityType string
+	marker     string
+}{
+	{"bold", "*"},
+	{"italic", "_"},
+	{"strikethrough", "~"},
+}
+
+// tgEntitiesToWaFormatting converts the bold, italic, strikethrough and code
+// entities of a Telegram message into WhatsApp formatting markers. WhatsApp
+// can't express overlapping ranges, so the text is split at every entity
+// boundary and each piece is wrapped in the markers of the entities covering it.
+func tgEntitiesToWaFormatting(text string, entities []gotgbot.MessageEntity) string {
+	utf16Text := utf16.Encode([]rune(text))
+	textLength := int64(len(utf16Text))
+
+	var (
+		formatting []gotgbot.MessageEntity
+		boundaries = []int64{0, textLength}
+	)
+	for _, entity := range entities {
+		switch entity.Type {
+		case "bold", "italic", "strikethrough", "code", "pre":
+		default:
+			continue
+		}
+		if entity.Offset < 0 || entity.Length <= 0 || entity.Offset+entity.Length > textLength {
+			continue
+		}
+		formatting = append(formatting, entity)
+		boundaries = append(boundaries, entity.Offset, entity.Offset+entity.Length)
+	}
+	if len(formatting) == 0 {
+		return text
+	}
+	slices.Sort(boundaries)
+	boundaries = slices.Compact(boundaries)
+
+	// markersAt returns the opening markers for the piece starting at offset
+	markersAt := func(offset int64) []string {
+		active := make(map[string]bool)
+		for _, entity := range formatting {
+			if entity.Offset <= offset && offset < entity.Offset+entity.Length {
+				active[entity.Type] = true
+			}
+		}
+		// Nothing is formatted inside code on WhatsApp
+		if active["code"] || active["pre"] {
+			return []string{"```"}
+		}
+		var markers []string
+		for _, style := range tgFormattingStyles {
+			if active[style.entityType] {
+				markers = append(markers, style.marker)
+			}
+		}
+		return markers
+	}
+
+	var (
+		result         strings.Builder
+		pending        string
+		pendingMarkers []string
+	)
+	flush := func() {
+		trimmed := strings.TrimSpace(pending)
+		if len(pendingMarkers) == 0 || trimmed == "" {
+			result.WriteString(pending)
+			return
+		}
+		// Markers must touch the text for WhatsApp to apply them
+		leading := pending[:len(pending)-len(strings.TrimLeftFunc(pending, unicode.IsSpace))]
+		trailing := pending[len(strings.TrimRightFunc(pending, unicode.IsSpace)):]
+		result.WriteString(leading)
+		for _, marker := range pendingMarkers {
+			result.WriteString(marker)
+		}
+		result.WriteString(trimmed)
+		for i := len(pendingMarkers) - 1; i >= 0; i-- {
+			result.WriteString(pendingMarkers[i])
+		}
+		result.WriteString(trailing)
+	}
+
+	for i := 0; i+1 < len(boundaries); i++ {
+		start, end := boundaries[i], boundaries[i+1]
+		piece := string(utf16.Decode(utf16Text[start:end]))
+		markers := markersAt(start)
+		if i > 0 && slices.Equal(markers, pendingMarkers) {
+			pending += piece
+			continue
+		}
+		if i > 0 {
+			flush()
+		}
+		pending, pendingMarkers = piece, markers
+	}
+	flush()
+
+	return result.String()
+}
+
 func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 	msgToForward, msgToReplyTo *gotgbot.Message,
 	waChatJID waTypes.JID, participant, stanzaId string,
 	isReply bool) error {
 
 	var (
-		cfg      = state.State.Config
+		cfg      = state.State.Config()
 		logger   = state.State.Logger
 		waClient = state.State.WhatsAppClient
 		mentions = []string{}
 	)
 
+	// Stored before formatting, attribution and footer are added
+	originalContent := msgToForward.Text
+	if originalContent == "" {
+		originalContent = msgToForward.Caption
+	}
+
 	var entities []gotgbot.ParsedMessageEntity
-	if len(msgToForward.Entities) > 0 {
+	if FormattingGuardTriggered(len(msgToForward.Text)+len(msgToForward.Caption),
+		len(msgToForward.Entities)+len(msgToForward.CaptionEntities)) {
+		logger.Warn("skipping entity processing for a long Telegram message",
+			zap.Int64("msg_id", msgToForward.MessageId),
+		)
+	} else if len(msgToForward.Entities) > 0 {
 		entities = msgToForward.ParseEntities()
 	} else if len(msgToForward.CaptionEntities) > 0 {
 		entities = msgToForward.ParseCaptionEntities()
@@ -237,27 +652,54 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		}
 	}
 
-	if cfg.Telegram.SendMyPresence {
-		err := waClient.SendPresence(context.Background(), waTypes.PresenceAvailable)
-		if err != nil {
-			logger.Warn("failed to send presence",
-				zap.Error(err),
-				zap.String("presence", string(waTypes.PresenceAvailable)),
-			)
+	// Formatting is converted after the entities were parsed since the markers shift their offsets
+	if len(entities) > 0 {
+		msgCopy := *msgToForward
+		if len(msgCopy.Entities) > 0 {
+			msgCopy.Text = tgEntitiesToWaFormatting(msgCopy.Text, msgCopy.Entities)
+		} else {
+			msgCopy.Caption = tgEntitiesToWaFormatting(msgCopy.Caption, msgCopy.CaptionEntities)
 		}
+		msgToForward = &msgCopy
+	}
 
-		go func() {
-			time.Sleep(10 * time.Second)
-			err := waClient.SendPresence(context.Background(), waTypes.PresenceAvailable)
-			if err != nil {
-				logger.Warn("failed to send presence",
-					zap.Error(err),
-					zap.String("presence", string(waTypes.PresenceUnavailable)),
-				)
+	// Attribution is added after the entities were parsed since it shifts their offsets
+	if attribution := tgForwardAttribution(msgToForward.ForwardOrigin); attribution != "" {
+		msgCopy := *msgToForward
+		if msgCopy.Text != "" {
+			msgCopy.Text = attribution + "\n" + msgCopy.Text
+		} else if msgCopy.Caption != "" {
+			msgCopy.Caption = attribution + "\n" + msgCopy.Caption
+		} else if msgCopy.Sticker == nil {
+			msgCopy.Caption = attribution
+		}
+		msgToForward = &msgCopy
+	}
+
+	// The footer goes last so that it isn't formatted, and is left out of
+	// single emoji replies which are sent as reactions. WhatsApp cuts off
+	// messages above waMaxTextLength, in which case the footer is dropped.
+	const waMaxTextLength = 65536
+	isReaction := isReply && len(gomoji.CollectAll(msgToForward.Text)) == 1 && gomoji.RemoveEmojis(msgToForward.Text) == ""
+	if footer := tgRenderOutboundFooter(msgToForward); footer != "" && !isReaction {
+		msgCopy := *msgToForward
+		if msgCopy.Text != "" {
+			if len(msgCopy.Text)+len(footer)+2 <= waMaxTextLength {
+				msgCopy.Text += "\n\n" + footer
+			}
+		} else if msgCopy.Caption != "" {
+			if len(msgCopy.Caption)+len(footer)+2 <= waMaxTextLength {
+				msgCopy.Caption += "\n\n" + footer
 			}
-		}()
+		} else if msgCopy.Sticker == nil {
+			msgCopy.Caption = footer
+		}
+		msgToForward = &msgCopy
 	}
 
+	WaPresenceBeforeSend(waChatJID, msgToForward.Voice != nil)
+	defer WaPresenceAfterSend(waChatJID)
+
 	isEphemeral, ephemeralTimer, ephemeralFound, err := database.GetEphemeralSettings(waChatJID.String())
 	if err != nil {
 		logger.Info(
@@ -309,17 +751,22 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive image file from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive image file from Telegram", err)
 		}
 
 		imageBytes, err := TgDownloadByFilePath(b, imageFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download image from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to download image from Telegram", err)
+		}
+
+		imageBytes, bestPhoto.Width, bestPhoto.Height, err = TgRecompressImage(imageBytes, bestPhoto.Width, bestPhoto.Height)
+		if err != nil {
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to recompress image", err)
 		}
 
 		uploadedImage, err := waClient.Upload(context.Background(), imageBytes, whatsmeow.MediaImage)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload image to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload image to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -353,15 +800,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send image to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send image to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 
 	} else if msgToForward.Video != nil {
@@ -377,17 +824,12 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive video file from Telegram", err)
-		}
-
-		videoBytes, err := TgDownloadByFilePath(b, videoFile.FilePath)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download video from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive video file from Telegram", err)
 		}
 
-		uploadedVideo, err := waClient.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
+		uploadedVideo, err := TgUploadToWhatsApp(b, videoFile.FilePath, msgToForward.Video.FileSize, whatsmeow.MediaVideo)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload video to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload video to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -399,7 +841,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				Mimetype:      proto.String(msgToForward.Video.MimeType),
 				FileEncSHA256: uploadedVideo.FileEncSHA256,
 				FileSHA256:    uploadedVideo.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(videoBytes))),
+				FileLength:    proto.Uint64(uploadedVideo.FileLength),
 				ViewOnce:      proto.Bool(msgToForward.HasProtectedContent || (msgToForward.HasMediaSpoiler && cfg.Telegram.SpoilerViewOnce)),
 				Seconds:       proto.Uint32(uint32(msgToForward.Video.Duration)),
 				GifPlayback:   proto.Bool(false),
@@ -422,15 +864,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send video to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send video to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 	} else if msgToForward.VideoNote != nil {
 
@@ -445,17 +887,26 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive video note file from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive video note file from Telegram", err)
 		}
 
 		videoBytes, err := TgDownloadByFilePath(b, videoFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download video note from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to download video note from Telegram", err)
+		}
+
+		videoBytes, err = TgConvertToWaVideoNote(videoBytes)
+		if err != nil {
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to convert video note for WhatsApp", err)
+		}
+		videoDuration := msgToForward.VideoNote.Duration
+		if videoDuration == 0 {
+			videoDuration, _ = ProbeMediaDuration(videoBytes)
 		}
 
 		uploadedVideo, err := waClient.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload video note to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload video note to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -464,12 +915,12 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				URL:           proto.String(uploadedVideo.URL),
 				DirectPath:    proto.String(uploadedVideo.DirectPath),
 				MediaKey:      uploadedVideo.MediaKey,
-				Mimetype:      proto.String(http.DetectContentType(videoBytes)),
+				Mimetype:      proto.String("video/mp4"),
 				FileEncSHA256: uploadedVideo.FileEncSHA256,
 				FileSHA256:    uploadedVideo.FileSHA256,
 				FileLength:    proto.Uint64(uint64(len(videoBytes))),
 				ViewOnce:      proto.Bool(msgToForward.HasProtectedContent || (msgToForward.HasMediaSpoiler && cfg.Telegram.SpoilerViewOnce)),
-				Seconds:       proto.Uint32(uint32(msgToForward.VideoNote.Duration)),
+				Seconds:       proto.Uint32(uint32(videoDuration)),
 				GifPlayback:   proto.Bool(false),
 				ContextInfo:   &waE2E.ContextInfo{},
 			},
@@ -488,15 +939,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send video note to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send video note to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 	} else if msgToForward.Animation != nil {
 
@@ -511,17 +962,17 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive animation file from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive animation file from Telegram", err)
 		}
 
 		animationBytes, err := TgDownloadByFilePath(b, animationFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download animation from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to download animation from Telegram", err)
 		}
 
 		uploadedAnimation, err := waClient.Upload(context.Background(), animationBytes, whatsmeow.MediaVideo)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload animation to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload animation to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -557,15 +1008,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send animation to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send animation to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 	} else if msgToForward.Audio != nil {
 
@@ -580,17 +1031,17 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive audio file from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive audio file from Telegram", err)
 		}
 
 		audioBytes, err := TgDownloadByFilePath(b, audioFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download audio from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to download audio from Telegram", err)
 		}
 
 		uploadedAudio, err := waClient.Upload(context.Background(), audioBytes, whatsmeow.MediaAudio)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload audio to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload audio to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -621,15 +1072,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send audio to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send audio to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 	} else if msgToForward.Voice != nil {
 
@@ -644,17 +1095,26 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive voice file from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive voice file from Telegram", err)
 		}
 
 		voiceBytes, err := TgDownloadByFilePath(b, voiceFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download voice from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to download voice from Telegram", err)
+		}
+
+		voiceBytes, err = TgConvertToWaVoiceNote(voiceBytes)
+		if err != nil {
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to convert voice for WhatsApp", err)
+		}
+		voiceDuration := msgToForward.Voice.Duration
+		if voiceDuration == 0 {
+			voiceDuration, _ = ProbeMediaDuration(voiceBytes)
 		}
 
 		uploadedVoice, err := waClient.Upload(context.Background(), voiceBytes, whatsmeow.MediaAudio)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload voice to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload voice to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -666,7 +1126,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				FileEncSHA256: uploadedVoice.FileEncSHA256,
 				FileSHA256:    uploadedVoice.FileSHA256,
 				FileLength:    proto.Uint64(uint64(len(voiceBytes))),
-				Seconds:       proto.Uint32(uint32(msgToForward.Voice.Duration)),
+				Seconds:       proto.Uint32(uint32(voiceDuration)),
 				PTT:           proto.Bool(true),
 				ContextInfo:   &waE2E.ContextInfo{},
 			},
@@ -685,15 +1145,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send voice to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send voice to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 	} else if msgToForward.Document != nil {
 
@@ -708,17 +1168,12 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive document file from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive document file from Telegram", err)
 		}
 
-		documentBytes, err := TgDownloadByFilePath(b, documentFile.FilePath)
+		uploadedDocument, err := TgUploadToWhatsApp(b, documentFile.FilePath, msgToForward.Document.FileSize, whatsmeow.MediaDocument)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download document from Telegram", err)
-		}
-
-		uploadedDocument, err := waClient.Upload(context.Background(), documentBytes, whatsmeow.MediaDocument)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload document to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload document to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -732,7 +1187,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				Mimetype:      proto.String(msgToForward.Document.MimeType),
 				FileEncSHA256: uploadedDocument.FileEncSHA256,
 				FileSHA256:    uploadedDocument.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(documentBytes))),
+				FileLength:    proto.Uint64(uploadedDocument.FileLength),
 				ContextInfo:   &waE2E.ContextInfo{},
 			},
 		}
@@ -750,18 +1205,22 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send document to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send document to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 	} else if msgToForward.Sticker != nil {
 
+		if cfg.Telegram.StickerAsReaction && isReply && msgToForward.Sticker.Emoji != "" {
+			return tgSendReactionToWhatsApp(b, c, msgToReplyTo, waChatJID, stanzaId, msgToForward.Sticker.Emoji)
+		}
+
 		if !cfg.Telegram.SelfHostedAPI && msgToForward.Sticker.FileSize > DownloadSizeLimit {
 			_, err := TgReplyTextByContext(b, c, "Unable to send sticker as it exceeds Telegram size restriction", nil, false)
 			return err
@@ -773,18 +1232,18 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive sticker file from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to retreive sticker file from Telegram", err)
 		}
 
 		stickerBytes, err := TgDownloadByFilePath(b, stickerFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download sticker from Telegram", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to download sticker from Telegram", err)
 		}
 
 		if msgToForward.Sticker.IsAnimated {
 			stickerBytes, err = TGSConvertToWebp(stickerBytes, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to convert TGS sticker to WebP", err)
+				return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to convert TGS sticker to WebP", err)
 			}
 		} else if msgToForward.Sticker.IsVideo && !cfg.Telegram.SkipVideoStickers {
 
@@ -803,7 +1262,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 			stickerBytes, err = WebmConvertToWebp(stickerBytes, scale, pad, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to convert WEBM sticker to GIF", err)
+				return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to convert WEBM sticker to GIF", err)
 			}
 		} else if !msgToForward.Sticker.IsAnimated || !msgToForward.Sticker.IsVideo {
 
@@ -818,13 +1277,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 			stickerBytes, err = WebpImagePad(stickerBytes, wPad, hPad, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to pad WEBP sticker to 512x512", err)
+				return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to pad WEBP sticker to 512x512", err)
 			}
 		}
 
 		uploadedSticker, err := waClient.Upload(context.Background(), stickerBytes, whatsmeow.MediaImage)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload sticker to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to upload sticker to WhatsApp", err)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -855,15 +1314,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send sticker to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send sticker to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 	} else if msgToForward.Contact != nil {
 
@@ -918,15 +1377,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send sticker to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send sticker to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 
 	} else if msgToForward.Location != nil {
@@ -972,46 +1431,21 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send sticker to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send sticker to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 
 	} else if msgToForward.Text != "" {
 
 		if emojis := gomoji.CollectAll(msgToForward.Text); isReply && len(emojis) == 1 && gomoji.RemoveEmojis(msgToForward.Text) == "" {
-			_, err := queue.WaSend(context.Background(), waChatJID, &waE2E.Message{
-				ReactionMessage: &waE2E.ReactionMessage{
-					Text:              proto.String(msgToForward.Text),
-					SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
-					Key: &waCommon.MessageKey{
-						RemoteJID: proto.String(waChatJID.String()),
-						FromMe:    proto.Bool(msgToReplyTo != nil && msgToReplyTo.From.Id != b.Id),
-						ID:        proto.String(stanzaId),
-					},
-				},
-			})
-			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to send reaction to WhatsApp", err)
-			}
-			if cfg.Telegram.ConfirmationType != "none" {
-				msg, err := TgReplyTextByContext(b, c, "Successfully reacted", nil, cfg.Telegram.SilentConfirmation)
-
-				if err == nil {
-					go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-						time.Sleep(15 * time.Second)
-						_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-					}(b, msg)
-				}
-				return err
-			}
-			return err
+			return tgSendReactionToWhatsApp(b, c, msgToReplyTo, waChatJID, stanzaId, msgToForward.Text)
 		}
 
 		msgToSend := &waE2E.Message{}
@@ -1036,15 +1470,15 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := queue.WaSend(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send message to WhatsApp", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send message to WhatsApp", err)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
 		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId, originalContent)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Failed to add to database", err)
 		}
 
 		{
@@ -1059,7 +1493,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 	if cfg.Telegram.SendMyReadReceipts {
 		unreadMsgs, err := database.MsgIdGetUnread(waChatJID.String())
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Message sent but failed to get unread messages to mark them read", err)
+			return TgReplyWithErrorByContext(b, c, ErrorCategoryNone, "Message sent but failed to get unread messages to mark them read", err)
 		}
 
 		for sender, msgIds := range unreadMsgs {
@@ -1085,6 +1519,31 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 	return nil
 }
 
+// tgSendReactionToWhatsApp reacts with emoji to the WhatsApp message the Telegram
+// update replied to and sends the configured confirmation.
+func tgSendReactionToWhatsApp(b *gotgbot.Bot, c *ext.Context, msgToReplyTo *gotgbot.Message,
+	waChatJID waTypes.JID, stanzaId, emoji string) error {
+
+	cfg := state.State.Config()
+
+	_, err := WaSendReaction(waChatJID, stanzaId, emoji, msgToReplyTo != nil && msgToReplyTo.From.Id != b.Id)
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, ErrorCategorySend, "Failed to send reaction to WhatsApp", err)
+	}
+	if cfg.Telegram.ConfirmationType != "none" {
+		msg, err := TgReplyTextByContext(b, c, "Successfully reacted", nil, cfg.Telegram.SilentConfirmation)
+
+		if err == nil {
+			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
+				time.Sleep(15 * time.Second)
+				_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
+			}(b, msg)
+		}
+		return err
+	}
+	return nil
+}
+
 func TgMakeRevokeKeyboard(msgId, chatId string, confirm bool) *gotgbot.InlineKeyboardMarkup {
 
 	if confirm {
@@ -1110,6 +1569,47 @@ func TgMakeRevokeKeyboard(msgId, chatId string, confirm bool) *gotgbot.InlineKey
 	}
 }
 
+// LinkCandidatesPerPage is the number of chats shown on each page of /link
+const LinkCandidatesPerPage = 10
+
+// TgMakeLinkKeyboard builds one page of the /link chat picker along with
+// buttons to move between pages.
+func TgMakeLinkKeyboard(candidates []WaLinkCandidate, page int) *gotgbot.InlineKeyboardMarkup {
+	var rows [][]gotgbot.InlineKeyboardButton
+
+	start := page * LinkCandidatesPerPage
+	end := min(start+LinkCandidatesPerPage, len(candidates))
+	for _, candidate := range candidates[start:end] {
+		text := candidate.Name
+		if strings.HasSuffix(candidate.JID, "@"+waTypes.GroupServer) {
+			text = "👥 " + text
+		}
+		rows = append(rows, []gotgbot.InlineKeyboardButton{{
+			Text:         text,
+			CallbackData: "link_c_" + candidate.JID,
+		}})
+	}
+
+	var navigation []gotgbot.InlineKeyboardButton
+	if page > 0 {
+		navigation = append(navigation, gotgbot.InlineKeyboardButton{
+			Text:         "« Previous",
+			CallbackData: fmt.Sprintf("link_p_%d", page-1),
+		})
+	}
+	if end < len(candidates) {
+		navigation = append(navigation, gotgbot.InlineKeyboardButton{
+			Text:         "Next »",
+			CallbackData: fmt.Sprintf("link_p_%d", page+1),
+		})
+	}
+	if len(navigation) > 0 {
+		rows = append(rows, navigation)
+	}
+
+	return &gotgbot.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
 func TgBuildUrlButton(text, url string) gotgbot.InlineKeyboardMarkup {
 	return gotgbot.InlineKeyboardMarkup{
 		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
@@ -1144,13 +1644,24 @@ func SendMessageConfirmation(
 	}
 }
 
-// SendWaProfilePicToTopic sends WhatsApp profile picture to a Telegram topic.
-// If a photo is successfully sent and pinned, the pinned message ID is stored
-// in the database against the (waChatIdString, tgChatId) pair.
+// sentProfilePicIds holds the ID of the last profile picture sent to the topic
+// of each chat, keyed by the chat ID the topic is stored under, so that the
+// same picture isn't posted again when a topic creation and a picture update
+// event both fire for it.
+var (
+	sentProfilePicIdsMu sync.Mutex
+	sentProfilePicIds   = make(map[string]string)
+)
+
+// SendWaProfilePicToTopic sends WhatsApp profile picture to a Telegram topic,
+// unless it is the one sent last. It is the single entry point for posting
+// profile pictures. If a photo is successfully sent and pinned, it replaces the
+// previously pinned one and its message ID is stored in the database against
+// the (waChatIdString, tgChatId) pair.
 func SendWaProfilePicToTopic(jid waTypes.JID, waChatIdString string, tgChatId int64, threadId int64, caption string) {
 	waClient := state.State.WhatsAppClient
 	tgBot := state.State.TelegramBot
-	cfg := state.State.Config
+	cfg := state.State.Config()
 	logger := state.State.Logger
 
 	pictureInfo, err := waClient.GetProfilePictureInfo(context.Background(), jid, &whatsmeow.GetProfilePictureParams{Preview: false})
@@ -1162,36 +1673,116 @@ func SendWaProfilePicToTopic(jid waTypes.JID, waChatIdString string, tgChatId in
 		logger.Info("No profile picture info or URL", zap.String("jid", jid.String()))
 		return
 	}
-	resp, err := http.Get(pictureInfo.URL)
-	if err != nil {
-		logger.Warn("Failed to download profile picture", zap.Error(err), zap.String("url", pictureInfo.URL))
+
+	sentProfilePicIdsMu.Lock()
+	alreadySent := waChatIdString != "" && sentProfilePicIds[waChatIdString] == pictureInfo.ID
+	sentProfilePicIdsMu.Unlock()
+	if alreadySent {
+		logger.Debug("profile picture was already sent to the topic", zap.String("jid", jid.String()))
 		return
 	}
-	defer resp.Body.Close()
-	newPictureBytes, err := io.ReadAll(resp.Body)
+
+	newPictureBytes, err := DownloadFileBytesByURL(pictureInfo.URL)
 	if err != nil {
-		logger.Warn("Failed to read profile picture bytes", zap.Error(err))
+		logger.Warn("Failed to download profile picture", zap.Error(err), zap.String("jid", jid.String()))
 		return
 	}
+
+	// Unpin previous profile picture before sending the new one
+	if waChatIdString != "" {
+		if prevPinId, _ := database.ChatThreadGetPinnedMsgId(waChatIdString, tgChatId); prevPinId != 0 {
+			queue.TgUnpinChatMessage(tgBot, tgChatId, &gotgbot.UnpinChatMessageOpts{MessageId: &prevPinId})
+			database.ChatThreadSetPinnedMsgId(waChatIdString, tgChatId, 0)
+		}
+	}
+
 	sentMsg, errSend := queue.TgSendPhoto(tgBot, cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
 		MessageThreadId: threadId,
-		Caption:         caption,
+		Caption:         tgFormatProfilePicCaption(jid, caption, time.Now()),
 	})
 	if errSend != nil {
 		logger.Warn("Failed to send profile picture to Telegram", zap.Error(errSend))
+		return
+	}
+
+	logger.Info("Profile picture sent to Telegram topic", zap.String("jid", jid.String()), zap.Int64("threadId", threadId))
+	if waChatIdString != "" {
+		sentProfilePicIdsMu.Lock()
+		sentProfilePicIds[waChatIdString] = pictureInfo.ID
+		sentProfilePicIdsMu.Unlock()
+	}
+
+	_, errPin := queue.TgPinChatMessage(tgBot, cfg.Telegram.TargetChatID, sentMsg.MessageId, &gotgbot.PinChatMessageOpts{
+		DisableNotification: true,
+	})
+	if errPin != nil {
+		logger.Warn("Failed to pin profile picture in Telegram topic", zap.Error(errPin))
+	} else if waChatIdString != "" {
+		if dbErr := database.ChatThreadSetPinnedMsgId(waChatIdString, tgChatId, sentMsg.MessageId); dbErr != nil {
+			logger.Warn("Failed to store pinned message ID", zap.Error(dbErr))
+		}
+	}
+}
+
+// ProfilePicCaptionFields are the values available to the
+// profile_picture_caption_template config option. All of them are HTML escaped
+// except Event, which is already formatted by the caller.
+type ProfilePicCaptionFields struct {
+	Name  string // Name of the group or contact
+	JID   string // JID of the group or contact
+	Phone string // Phone number of the contact without the leading +, empty for groups
+	Time  string // Time the picture was posted, formatted with time_format
+	Event string // What happened, e.g. "The profile picture was updated by Alice"
+}
+
+// tgFormatProfilePicCaption renders the configured caption template for a
+// profile picture posted to a topic. The event text is used as is if no
+// template is set or it fails to render.
+func tgFormatProfilePicCaption(jid waTypes.JID, event string, postedAt time.Time) string {
+	cfg := state.State.Config()
+
+	tmplString := cfg.WhatsApp.ProfilePictureCaptionTemplate
+	if tmplString == "" {
+		return event
+	}
+
+	fields := ProfilePicCaptionFields{
+		JID:   html.EscapeString(jid.ToNonAD().String()),
+		Time:  html.EscapeString(postedAt.In(state.State.LocalLocation).Format(cfg.TimeFormat)),
+		Event: event,
+	}
+	if jid.Server == waTypes.GroupServer {
+		fields.Name = html.EscapeString(WaGetGroupName(jid))
 	} else {
-		logger.Info("Profile picture sent to Telegram topic", zap.String("jid", jid.String()), zap.Int64("threadId", threadId))
-		_, errPin := queue.TgPinChatMessage(tgBot, cfg.Telegram.TargetChatID, sentMsg.MessageId, &gotgbot.PinChatMessageOpts{
-			DisableNotification: true,
-		})
-		if errPin != nil {
-			logger.Warn("Failed to pin profile picture in Telegram topic", zap.Error(errPin))
-		} else if waChatIdString != "" {
-			if dbErr := database.ChatThreadSetPinnedMsgId(waChatIdString, tgChatId, sentMsg.MessageId); dbErr != nil {
-				logger.Warn("Failed to store pinned message ID", zap.Error(dbErr))
+		fields.Name = html.EscapeString(WaGetContactName(jid.ToNonAD()))
+		fields.Phone = jid.User
+		if jid.Server == waTypes.HiddenUserServer {
+			fields.Phone = ""
+			if pn, err := state.State.WhatsAppClient.Store.LIDs.GetPNForLID(context.Background(), jid); err == nil && !pn.IsEmpty() {
+				fields.Phone = pn.User
 			}
 		}
 	}
+
+	tmpl, err := template.New("profile_picture_caption").Parse(tmplString)
+	if err != nil {
+		state.State.Logger.Error("failed to parse profile_picture_caption_template", zap.Error(err))
+		return event
+	}
+	var rendered strings.Builder
+	if err = tmpl.Execute(&rendered, fields); err != nil {
+		state.State.Logger.Error("failed to render profile_picture_caption_template", zap.Error(err))
+		return event
+	}
+	return strings.TrimSpace(rendered.String())
+}
+
+// ForgetSentWaProfilePic makes the next SendWaProfilePicToTopic call for the
+// chat send the picture even if it didn't change, used when it was removed.
+func ForgetSentWaProfilePic(waChatIdString string) {
+	sentProfilePicIdsMu.Lock()
+	delete(sentProfilePicIds, waChatIdString)
+	sentProfilePicIdsMu.Unlock()
 }
 
 // SyncTopicNameByChatThreadPairs updates the topic names for all chat thread pairs.
@@ -1199,7 +1790,7 @@ func SyncTopicNameByChatThreadPairs(b *gotgbot.Bot, groupId int64, chatThreadPai
 	for _, pair := range chatThreadPairs {
 		waChatId := pair.ID
 
-		if waChatId == "status@broadcast" || waChatId == "calls" || waChatId == "mentions" {
+		if waChatId == "status@broadcast" || WaIsPseudoChat(waChatId) {
 			continue
 		}
 		SyncTopicNameByChatThreadPair(b, groupId, pair)
@@ -1208,11 +1799,11 @@ func SyncTopicNameByChatThreadPairs(b *gotgbot.Bot, groupId int64, chatThreadPai
 
 func SyncTopicNameByChatThreadPair(b *gotgbot.Bot, groupId int64, pair database.ChatThreadPair) {
 	waChatId := pair.ID
-	if waChatId == "" {
+	tgThreadId := pair.TgThreadId
+	waChatJid, ok := WaParseJID(waChatId)
+	if !ok {
 		return
 	}
-	tgThreadId := pair.TgThreadId
-	waChatJid, _ := WaParseJID(waChatId)
 
 	var newName string
 	if waChatJid.Server == waTypes.GroupServer {
@@ -1221,5 +1812,59 @@ func SyncTopicNameByChatThreadPair(b *gotgbot.Bot, groupId int64, pair database.
 		newName = WaGetContactName(waChatJid)
 	}
 
-	TgEditForumTopicName(b, groupId, tgThreadId, newName)
+	TgEditForumTopicName(b, groupId, tgThreadId, TgFormatTopicName(waChatJid.ToNonAD().String(), newName))
+}
+
+// TgBuildTopicTranscript builds a plain text transcript of the messages bridged
+// to a topic. The bridge doesn't store message contents, so each entry links
+// to the message on Telegram instead.
+func TgBuildTopicTranscript(tgChatId, tgThreadId int64, waChatId string) (string, error) {
+	var (
+		cfg      = state.State.Config()
+		waClient = state.State.WhatsAppClient
+	)
+
+	pairs, err := database.MsgIdGetPairsByThreadId(tgChatId, tgThreadId)
+	if err != nil {
+		return "", err
+	}
+
+	chatName := waChatId
+	if waChatJID, ok := WaParseJID(waChatId); ok {
+		if waChatJID.Server == waTypes.GroupServer {
+			chatName = WaGetGroupName(waChatJID)
+		} else {
+			chatName = WaGetContactName(waChatJID)
+		}
+	}
+
+	// Links to messages of supergroups use the chat ID without the -100 prefix
+	linkChatId := strings.TrimPrefix(fmt.Sprint(tgChatId), "-100")
+
+	var transcript strings.Builder
+	fmt.Fprintf(&transcript, "Transcript of %s (%s)\n", chatName, waChatId)
+	fmt.Fprintf(&transcript, "Exported at %s, %d messages\n",
+		time.Now().In(state.State.LocalLocation).Format(cfg.TimeFormat), len(pairs))
+	transcript.WriteString("Message contents are not stored by the bridge, follow the links to read them\n\n")
+
+	for _, pair := range pairs {
+		sentAt := "unknown time"
+		if pair.BridgedAt.Valid {
+			sentAt = pair.BridgedAt.Time.In(state.State.LocalLocation).Format(cfg.TimeFormat)
+		}
+
+		senderName := pair.ParticipantId
+		if senderJID, ok := WaParseJID(pair.ParticipantId); ok {
+			if waClient.Store.ID != nil && senderJID.User == waClient.Store.ID.User {
+				senderName = "You"
+			} else {
+				senderName = WaGetContactName(senderJID)
+			}
+		}
+
+		fmt.Fprintf(&transcript, "[%s] %s: https://t.me/c/%s/%d/%d\n",
+			sentAt, senderName, linkChatId, pair.TgThreadId, pair.TgMsgId)
+	}
+
+	return transcript.String(), nil
 }
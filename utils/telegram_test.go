@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/internal/testutil"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+func TestTgGetOrMakeThreadFromWaConcurrent(t *testing.T) {
+	testutil.Setup(t, nil)
+	botClient := testutil.UseFakeBot(t)
+	botClient.Delay = 20 * time.Millisecond
+
+	const (
+		waChatId = "911234567890@s.whatsapp.net"
+		tgChatId = int64(-1001234567890)
+		callers  = 10
+	)
+
+	var (
+		wg        sync.WaitGroup
+		threadIds = make([]int64, callers)
+		errs      = make([]error, callers)
+	)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			threadIds[i], errs[i] = TgGetOrMakeThreadFromWa_String(waChatId, tgChatId, "Contact")
+		}()
+	}
+	wg.Wait()
+
+	for i := range callers {
+		if errs[i] != nil {
+			t.Fatalf("caller %d failed: %v", i, errs[i])
+		}
+		if threadIds[i] != threadIds[0] {
+			t.Errorf("caller %d got thread %d, want %d", i, threadIds[i], threadIds[0])
+		}
+	}
+	if created := botClient.TopicsCreated.Load(); created != 1 {
+		t.Errorf("created %d topics, want 1", created)
+	}
+
+	threadId, found, err := database.ChatThreadGetTgFromWa(waChatId, tgChatId)
+	if err != nil || !found || threadId != threadIds[0] {
+		t.Errorf("stored thread is %d (found %v, err %v), want %d", threadId, found, err, threadIds[0])
+	}
+}
+
+func TestTgEntitiesToWaFormatting(t *testing.T) {
+	entity := func(entityType string, offset, length int64) gotgbot.MessageEntity {
+		return gotgbot.MessageEntity{Type: entityType, Offset: offset, Length: length}
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		entities []gotgbot.MessageEntity
+		want     string
+	}{
+		{
+			name:     "mixed",
+			text:     "bold italic code strike",
+			entities: []gotgbot.MessageEntity{entity("bold", 0, 4), entity("italic", 5, 6), entity("code", 12, 4), entity("strikethrough", 17, 6)},
+			want:     "*bold* _italic_ ```code``` ~strike~",
+		},
+		{
+			name:     "nested",
+			text:     "bold and italic",
+			entities: []gotgbot.MessageEntity{entity("bold", 0, 15), entity("italic", 5, 3)},
+			want:     "*bold* *_and_* *italic*",
+		},
+		{
+			name:     "overlapping",
+			text:     "abcdef",
+			entities: []gotgbot.MessageEntity{entity("bold", 0, 4), entity("italic", 2, 4)},
+			want:     "*ab**_cd_*_ef_",
+		},
+		{
+			name:     "code inside bold",
+			text:     "x code y",
+			entities: []gotgbot.MessageEntity{entity("bold", 0, 8), entity("code", 2, 4)},
+			want:     "*x* ```code``` *y*",
+		},
+		{
+			name:     "utf-16 offsets",
+			text:     "😀 hi",
+			entities: []gotgbot.MessageEntity{entity("bold", 3, 2)},
+			want:     "😀 *hi*",
+		},
+		{
+			name:     "unsupported entities",
+			text:     "see https://example.com",
+			entities: []gotgbot.MessageEntity{entity("url", 4, 19)},
+			want:     "see https://example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tgEntitiesToWaFormatting(tt.text, tt.entities); got != tt.want {
+				t.Errorf("tgEntitiesToWaFormatting() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// Translator translates the text of a bridged message to the target language
+// set for its chat. Modules can add their own with RegisterTranslator and have
+// it used by setting translation.provider to its name.
+type Translator interface {
+	Translate(ctx context.Context, text, targetLanguage string) (string, error)
+}
+
+type noopTranslator struct{}
+
+func (noopTranslator) Translate(_ context.Context, text, _ string) (string, error) {
+	return text, nil
+}
+
+// httpTranslator sends the text to translation.url, for services that are
+// wrapped behind a small JSON endpoint.
+type httpTranslator struct{}
+
+func (httpTranslator) Translate(ctx context.Context, text, targetLanguage string) (string, error) {
+	cfg := state.State.Config()
+
+	body, err := json.Marshal(map[string]string{
+		"text":            text,
+		"target_language": targetLanguage,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Translation.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Translation.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Translation.APIKey)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("translation service returned status %d", res.StatusCode)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("could not parse the response of the translation service : %s", err)
+	}
+	return result.Text, nil
+}
+
+var (
+	translatorsMu sync.RWMutex
+	translators   = map[string]Translator{
+		"":     noopTranslator{},
+		"http": httpTranslator{},
+	}
+)
+
+// RegisterTranslator makes the translator available as translation.provider.
+func RegisterTranslator(name string, translator Translator) {
+	translatorsMu.Lock()
+	defer translatorsMu.Unlock()
+	translators[name] = translator
+}
+
+// TranslateWaText translates the text of a message of the chat if a target
+// language is set for it. It reports false if the text was left as it is,
+// which includes the translation failing or taking longer than timeout_ms.
+func TranslateWaText(chat types.JID, text string) (string, bool) {
+	cfg := state.State.Config()
+	if cfg.Translation.Provider == "" || strings.TrimSpace(text) == "" {
+		return text, false
+	}
+
+	chat = chat.ToNonAD()
+	targetLanguage, found := cfg.Translation.Chats[chat.String()]
+	if !found {
+		targetLanguage, found = cfg.Translation.Chats[chat.User]
+	}
+	if !found || targetLanguage == "" {
+		return text, false
+	}
+
+	translatorsMu.RLock()
+	translator, found := translators[cfg.Translation.Provider]
+	translatorsMu.RUnlock()
+	if !found {
+		state.State.Logger.Warn("no translator is registered with the configured name",
+			zap.String("provider", cfg.Translation.Provider),
+		)
+		return text, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Translation.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	// Translators of modules may not give up once the context is done
+	type result struct {
+		text string
+		err  error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		translated, err := translator.Translate(ctx, text, targetLanguage)
+		resultChan <- result{translated, err}
+	}()
+
+	var translated string
+	var err error
+	select {
+	case res := <-resultChan:
+		translated, err = res.text, res.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		state.State.Logger.Warn("failed to translate a message, bridging it untranslated",
+			zap.String("chat", chat.String()),
+			zap.Error(err),
+		)
+		return text, false
+	}
+	translated = strings.TrimSpace(translated)
+	if translated == "" || translated == strings.TrimSpace(text) {
+		return text, false
+	}
+	return translated, true
+}
@@ -2,10 +2,13 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html"
 	"log"
+	"sort"
 	"strings"
+	"time"
 
 	"watgbridge/database"
 	"watgbridge/queue"
@@ -14,13 +17,30 @@ import (
 	"github.com/lithammer/fuzzysearch/fuzzy"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/proto/waCommon"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
+// WaQuarantineChatId is the chat ID the shared "Quarantine" topic is stored
+// under. # can't be part of a JID or a phone number, so it never matches a
+// WhatsApp chat.
+const WaQuarantineChatId = "#quarantine"
+
+// WaIsPseudoChat reports whether the chat ID of a topic is one of the shared
+// topics of the bridge instead of a WhatsApp chat.
+func WaIsPseudoChat(waChatId string) bool {
+	switch waChatId {
+	case "errors", "calls", "mentions", WaQuarantineChatId:
+		return true
+	}
+	return false
+}
+
 func WaParseJID(s string) (types.JID, bool) {
-	if len(s) == 0 {
+	if len(s) == 0 || WaIsPseudoChat(s) {
 		return types.JID{}, false
 	}
 	if s[0] == '+' {
@@ -101,6 +121,68 @@ func WaFuzzyFindContacts(query string) (map[string]string, int, error) {
 	return results, resultsCount, nil
 }
 
+// WaLinkCandidate is a WhatsApp chat that can be linked to a topic using /link
+type WaLinkCandidate struct {
+	JID  string
+	Name string
+}
+
+// WaGetLinkCandidates returns the joined groups followed by the saved contacts,
+// each sorted by name, leaving out chats that already have a topic.
+func WaGetLinkCandidates(tgChatId int64) ([]WaLinkCandidate, error) {
+	waClient := state.State.WhatsAppClient
+
+	pairs, err := database.ChatThreadGetAllPairs(tgChatId)
+	if err != nil {
+		return nil, err
+	}
+	linked := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		linked[pair.ID] = true
+	}
+
+	groups, err := waClient.GetJoinedGroups(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var groupCandidates []WaLinkCandidate
+	for _, group := range groups {
+		if jid := group.JID.String(); !linked[jid] {
+			groupCandidates = append(groupCandidates, WaLinkCandidate{JID: jid, Name: group.Name})
+		}
+	}
+
+	contacts, err := database.ContactGetAll()
+	if err != nil {
+		return nil, err
+	}
+	var contactCandidates []WaLinkCandidate
+	for _, contact := range contacts {
+		if contact.Server != types.DefaultUserServer {
+			continue
+		}
+		jid := types.NewJID(contact.ID, contact.Server).String()
+		if linked[jid] {
+			continue
+		}
+		name := contact.ID
+		for _, n := range []string{contact.FirstName, contact.PushName, contact.BusinessName, contact.FullName} {
+			if n != "" {
+				name = n
+			}
+		}
+		contactCandidates = append(contactCandidates, WaLinkCandidate{JID: jid, Name: name})
+	}
+
+	byName := func(c []WaLinkCandidate) func(i, j int) bool {
+		return func(i, j int) bool { return strings.ToLower(c[i].Name) < strings.ToLower(c[j].Name) }
+	}
+	sort.Slice(groupCandidates, byName(groupCandidates))
+	sort.Slice(contactCandidates, byName(contactCandidates))
+
+	return append(groupCandidates, contactCandidates...), nil
+}
+
 func WaGetGroupName(jid types.JID) string {
 	waClient := state.State.WhatsAppClient
 
@@ -195,9 +277,31 @@ func WaGetContactName(jid types.JID) string {
 	return formattedName
 }
 
+// WaGetSenderPhoneNumber returns the phone number of the sender of a message in
+// international format, resolving LIDs through the message's alternate address
+// or the LID store. An empty string is returned if no number is known.
+func WaGetSenderPhoneNumber(source types.MessageSource) string {
+	sender := source.Sender.ToNonAD()
+	if sender.Server == types.HiddenUserServer {
+		if alt := source.SenderAlt.ToNonAD(); !alt.IsEmpty() && alt.Server == types.DefaultUserServer {
+			sender = alt
+		} else {
+			pn, err := state.State.WhatsAppClient.Store.LIDs.GetPNForLID(context.Background(), sender)
+			if err != nil || pn.IsEmpty() {
+				return ""
+			}
+			sender = pn
+		}
+	}
+	if sender.Server != types.DefaultUserServer {
+		return ""
+	}
+	return "+" + sender.User
+}
+
 func WaTagAll(group types.JID, msg *waE2E.Message, msgId, msgSender string, msgIsFromMe bool) {
 	var (
-		cfg      = state.State.Config
+		cfg      = state.State.Config()
 		waClient = state.State.WhatsAppClient
 		tgBot    = state.State.TelegramBot
 	)
@@ -271,19 +375,86 @@ func WaSendText(chat types.JID, text, stanzaId, participantId string, quotedMsg
 	return queue.WaSend(context.Background(), chat, msgToSend)
 }
 
-// WaSyncContacts fetches and updates WhatsApp contacts in the database.
+// WaSendReaction reacts to the message stanzaId in chat with emoji. An empty
+// emoji removes a previously sent reaction.
+func WaSendReaction(chat types.JID, stanzaId, emoji string, fromMe bool) (whatsmeow.SendResponse, error) {
+	return queue.WaSend(context.Background(), chat, &waE2E.Message{
+		ReactionMessage: &waE2E.ReactionMessage{
+			Text:              proto.String(emoji),
+			SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+			Key: &waCommon.MessageKey{
+				RemoteJID: proto.String(chat.String()),
+				FromMe:    proto.Bool(fromMe),
+				ID:        proto.String(stanzaId),
+			},
+		},
+	})
+}
+
+// WaSyncContacts fetches and updates WhatsApp contacts in the database. The
+// fetch is retried up to contact_sync_retries times, and if it still fails the
+// contacts already known to the WhatsApp store are saved anyway. Contacts that
+// fail to save don't keep the others from being updated, the returned error
+// sums up everything that failed.
 func WaSyncContacts() error {
 	var (
+		cfg      = state.State.Config()
+		logger   = state.State.Logger
 		waClient = state.State.WhatsAppClient
+		backoff  = queue.NewBackoff(time.Second, cfg.RetryJitterFactor)
+		errs     []error
+		err      error
 	)
-	err := waClient.FetchAppState(context.Background(), appstate.WAPatchCriticalUnblockLow, false, false)
+
+	for attempt := 0; attempt <= cfg.WhatsApp.ContactSyncRetries; attempt++ {
+		if attempt > 0 {
+			logger.Debug("retrying a failed WhatsApp contacts fetch",
+				zap.Int("attempt", attempt),
+				zap.Error(err),
+			)
+			time.Sleep(backoff.Next())
+		}
+		if err = waClient.FetchAppState(context.Background(), appstate.WAPatchCriticalUnblockLow, false, false); err == nil {
+			break
+		}
+	}
 	if err != nil {
-		return err
+		logger.Warn("failed to fetch the WhatsApp contacts, saving the ones already known",
+			zap.Error(err),
+		)
+		errs = append(errs, fmt.Errorf("failed to fetch contacts : %s", err))
 	}
 
 	contacts, err := waClient.Store.Contacts.GetAllContacts(context.Background())
-	if err == nil {
-		database.ContactNameBulkAddOrUpdate(contacts)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("failed to get contacts from the store : %s", err))...)
+	}
+
+	if err = database.ContactNameBulkAddOrUpdate(contacts); err != nil {
+		var saveErr *database.ContactNameSaveError
+		if errors.As(err, &saveErr) {
+			for jid, contactErr := range saveErr.Failed {
+				logger.Warn("failed to save a WhatsApp contact",
+					zap.String("jid", jid),
+					zap.Error(contactErr),
+				)
+			}
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// WaChatIdForThread returns the ID under which the chat's topic is stored,
+// which is always the phone number JID for individual chats.
+func WaChatIdForThread(waChatId types.JID) (string, error) {
+	if waChatId.Server == types.HiddenUserServer {
+		waClient := state.State.WhatsAppClient
+		pn, err := waClient.Store.LIDs.GetPNForLID(context.Background(), waChatId)
+		if err != nil {
+			return "", err
+		}
+		waChatId = pn
 	}
-	return err
+	return waChatId.ToNonAD().String(), nil
 }
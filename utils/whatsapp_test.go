@@ -0,0 +1,29 @@
+package utils
+
+import "testing"
+
+func TestWaParseJID(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   string
+		wantOk bool
+	}{
+		{"+911234567890", "911234567890@s.whatsapp.net", true},
+		{"911234567890:12@s.whatsapp.net", "911234567890@s.whatsapp.net", true},
+		{"120363012345678901@g.us", "120363012345678901@g.us", true},
+		{"", "", false},
+		// Shared topics of the bridge must never be taken for a chat
+		{WaQuarantineChatId, "", false},
+		{"errors", "", false},
+		{"calls", "", false},
+		{"mentions", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			jid, ok := WaParseJID(tt.input)
+			if ok != tt.wantOk || (ok && jid.String() != tt.want) {
+				t.Errorf("WaParseJID(%q) = %q, %v, want %q, %v", tt.input, jid.String(), ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
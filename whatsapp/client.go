@@ -50,7 +50,7 @@ func (wl whatsmeowLogger) Sub(module string) waLog.Logger {
 func NewWhatsAppClient() error {
 
 	var (
-		cfg    = state.State.Config
+		cfg    = state.State.Config()
 		err    error
 		logger *zap.Logger
 	)
@@ -75,7 +75,13 @@ func NewWhatsAppClient() error {
 	waDatabaseLogger := &whatsmeowLogger{logger: logger.Sugar().Named("WhatsMeow_Database")}
 	waClientLogger := &whatsmeowLogger{logger: logger.Sugar().Named("WhatsMeow_Client")}
 
-	store.DeviceProps.Os = proto.String(state.State.Config.WhatsApp.SessionName)
+	// The device name is only sent while pairing, so changing it later
+	// requires logging out and scanning the QR code again.
+	deviceName := cfg.WhatsApp.DeviceName
+	if deviceName == "" {
+		deviceName = cfg.WhatsApp.SessionName
+	}
+	store.DeviceProps.Os = proto.String(deviceName)
 	store.DeviceProps.RequireFullSync = proto.Bool(false)
 	store.DeviceProps.PlatformType = waCompanionReg.DeviceProps_DESKTOP.Enum()
 	store.DeviceProps.HistorySyncConfig = &waCompanionReg.DeviceProps_HistorySyncConfig{
@@ -88,8 +94,8 @@ func NewWhatsAppClient() error {
 		SupportCagReactionsAndPolls:    proto.Bool(false),
 	}
 
-	container, err := sqlstore.New(context.Background(), state.State.Config.WhatsApp.LoginDatabase.Type,
-		state.State.Config.WhatsApp.LoginDatabase.URL, waDatabaseLogger)
+	container, err := sqlstore.New(context.Background(), state.State.Config().WhatsApp.LoginDatabase.Type,
+		state.State.Config().WhatsApp.LoginDatabase.URL, waDatabaseLogger)
 	if err != nil {
 		return fmt.Errorf("could not initialize sqlstore for Whatsapp : %s", err)
 	}
@@ -120,7 +126,7 @@ func NewWhatsAppClient() error {
 					qrCodePNG, err := qrcode.Encode(evt.Code, qrcode.Highest, 512)
 					if err != nil {
 						state.State.TelegramBot.SendMessage(
-							state.State.Config.Telegram.OwnerID,
+							state.State.Config().Telegram.OwnerID,
 							fmt.Sprintf(
 								"Please check your terminal and scan the QR code to login to WhatsApp. Failed to encode to PNG and send here:\n<code>%s</code>",
 								html.EscapeString(err.Error()),
@@ -129,7 +135,7 @@ func NewWhatsAppClient() error {
 						)
 					} else {
 						state.State.TelegramBot.SendPhoto(
-							state.State.Config.Telegram.OwnerID,
+							state.State.Config().Telegram.OwnerID,
 							gotgbot.InputFileByReader("qrcode.png", bytes.NewReader(qrCodePNG)),
 							&gotgbot.SendPhotoOpts{
 								Caption: "Scan the above QR code to login to WhatsApp.",
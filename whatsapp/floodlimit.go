@@ -0,0 +1,190 @@
+package whatsapp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/queue"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// Each chat gets a token bucket holding chat_rate_limit_per_minute messages
+// that refills at the same rate per minute, so that one noisy chat can't fill
+// the Telegram queue and starve the others. Messages over the limit are
+// delayed until the bucket refills or dropped, depending on
+// chat_rate_limit_action, and a notice is posted in the topic.
+
+// chatRateLimitMaxBuffered caps how many minutes worth of messages are kept
+// back per chat before the rest are dropped.
+const chatRateLimitMaxBuffered = 10
+
+type chatBucket struct {
+	tokens     float64
+	refilledAt time.Time
+	buffered   []heldMessage
+	timer      *time.Timer
+	dropped    int
+	limited    bool // Notice about the limit was sent for the current flood
+}
+
+var (
+	chatBucketsMu sync.Mutex
+	chatBuckets   = make(map[string]*chatBucket)
+	// admittedMessages are buffered messages being bridged once their turn came
+	admittedMessages = make(map[*events.Message]bool)
+)
+
+func (bucket *chatBucket) refill(limit int) {
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.refilledAt).Minutes() * float64(limit)
+	if bucket.tokens > float64(limit) {
+		bucket.tokens = float64(limit)
+	}
+	bucket.refilledAt = now
+}
+
+// chatRateLimitAdmit reports whether the message can be bridged now. If not,
+// it was either buffered to be bridged later or dropped.
+func chatRateLimitAdmit(text string, v *events.Message, isEdited bool) bool {
+	cfg := state.State.Config()
+	limit := cfg.WhatsApp.ChatRateLimitPerMinute
+	if limit <= 0 {
+		return true
+	}
+	chatId := v.Info.Chat.ToNonAD().String()
+
+	chatBucketsMu.Lock()
+
+	if admittedMessages[v] {
+		delete(admittedMessages, v)
+		chatBucketsMu.Unlock()
+		return true
+	}
+
+	// Buckets untouched for a minute are full again and can be forgotten
+	for id, b := range chatBuckets {
+		if id != chatId && len(b.buffered) == 0 && b.dropped == 0 && time.Since(b.refilledAt) > time.Minute {
+			delete(chatBuckets, id)
+		}
+	}
+
+	bucket, found := chatBuckets[chatId]
+	if !found {
+		bucket = &chatBucket{tokens: float64(limit), refilledAt: time.Now()}
+		chatBuckets[chatId] = bucket
+	}
+	bucket.refill(limit)
+
+	if len(bucket.buffered) == 0 && bucket.tokens >= 1 {
+		bucket.tokens--
+		dropped, wasLimited := bucket.dropped, bucket.limited
+		bucket.dropped, bucket.limited = 0, false
+		chatBucketsMu.Unlock()
+
+		if wasLimited && dropped > 0 {
+			sendChatRateLimitNotice(v, fmt.Sprintf("<i>%d messages from this chat were dropped because it exceeded %d messages a minute</i>", dropped, limit))
+		}
+		return true
+	}
+
+	var notice string
+	if cfg.WhatsApp.ChatRateLimitAction == "buffer" && len(bucket.buffered) < limit*chatRateLimitMaxBuffered {
+		bucket.buffered = append(bucket.buffered, heldMessage{text, v, isEdited})
+		scheduleChatRateLimitDrain(chatId, bucket, limit)
+		if !bucket.limited {
+			notice = fmt.Sprintf("<i>This chat is sending more than %d messages a minute, its messages are being delayed</i>", limit)
+		}
+	} else {
+		bucket.dropped++
+		if !bucket.limited {
+			notice = fmt.Sprintf("<i>This chat is sending more than %d messages a minute, its messages are being dropped</i>", limit)
+		}
+	}
+	bucket.limited = true
+	chatBucketsMu.Unlock()
+
+	state.State.Logger.Debug("message is over chat_rate_limit_per_minute",
+		zap.String("event_id", v.Info.ID),
+		zap.String("chat_jid", chatId),
+	)
+	if notice != "" {
+		sendChatRateLimitNotice(v, notice)
+	}
+	return false
+}
+
+// scheduleChatRateLimitDrain bridges the first buffered message once the
+// bucket has a token for it. The caller must hold chatBucketsMu.
+func scheduleChatRateLimitDrain(chatId string, bucket *chatBucket, limit int) {
+	if bucket.timer != nil {
+		return
+	}
+	wait := time.Duration((1 - bucket.tokens) / float64(limit) * float64(time.Minute))
+	if wait < 0 {
+		wait = 0
+	}
+	bucket.timer = time.AfterFunc(wait, func() { drainChatRateLimit(chatId, limit) })
+}
+
+func drainChatRateLimit(chatId string, limit int) {
+	chatBucketsMu.Lock()
+	bucket, found := chatBuckets[chatId]
+	if !found {
+		chatBucketsMu.Unlock()
+		return
+	}
+	bucket.timer = nil
+	bucket.refill(limit)
+
+	if len(bucket.buffered) == 0 || bucket.tokens < 1 {
+		if len(bucket.buffered) > 0 {
+			scheduleChatRateLimitDrain(chatId, bucket, limit)
+		}
+		chatBucketsMu.Unlock()
+		return
+	}
+
+	bucket.tokens--
+	msg := bucket.buffered[0]
+	bucket.buffered = bucket.buffered[1:]
+	admittedMessages[msg.v] = true
+	if len(bucket.buffered) > 0 {
+		scheduleChatRateLimitDrain(chatId, bucket, limit)
+	}
+	chatBucketsMu.Unlock()
+
+	handleRecovering(msg.v, func() { MessageFromOthersEventHandler(msg.text, msg.v, msg.isEdited) })
+}
+
+// sendChatRateLimitNotice posts the notice in the chat's topic, if it has one.
+func sendChatRateLimitNotice(v *events.Message, text string) {
+	var (
+		cfg    = state.State.Config()
+		logger = state.State.Logger
+	)
+
+	waChatId, err := utils.WaChatIdForThread(v.Info.Chat)
+	if err != nil {
+		return
+	}
+	threadId, threadFound, err := database.ChatThreadGetTgFromWa(waChatId, cfg.Telegram.TargetChatID)
+	if err != nil || !threadFound {
+		return
+	}
+
+	if _, err = queue.TgSendMessage(state.State.TelegramBot, cfg.Telegram.TargetChatID, text, &gotgbot.SendMessageOpts{
+		MessageThreadId: threadId,
+	}); err != nil {
+		logger.Warn("failed to send chat rate limit notice",
+			zap.String("chat_jid", waChatId),
+			zap.Error(err),
+		)
+	}
+}
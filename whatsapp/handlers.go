@@ -3,9 +3,11 @@ package whatsapp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"html"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"watgbridge/database"
@@ -15,7 +17,6 @@ import (
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	goVCard "github.com/emersion/go-vcard"
-	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	waTypes "go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
@@ -25,39 +26,98 @@ import (
 )
 
 func WhatsAppEventHandler(evt interface{}) {
+	defer recoverEventPanic(evt)
 
-	cfg := state.State.Config
+	if bufferDuringMaintenance(evt) {
+		return
+	}
+	handleWhatsAppEvent(evt)
+}
+
+func handleWhatsAppEvent(evt interface{}) {
+
+	cfg := state.State.Config()
+
+	// Receipts, push names and logouts only update local state, everything else
+	// ends up in Telegram and is dropped when that direction is disabled
+	if !cfg.BridgeWaToTg {
+		switch evt.(type) {
+		case *events.Picture, *events.GroupInfo, *events.JoinedGroup, *events.UserAbout, *events.CallOffer,
+			*events.UndecryptableMessage, *events.Message, *events.ClearChat:
+			return
+		}
+	}
 
 	switch v := evt.(type) {
 
 	case *events.LoggedOut:
 		LogoutHandler(v)
 
+	case *events.TemporaryBan:
+		TemporaryBanEventHandler(v)
+
+	case *events.ConnectFailure:
+		if v.Reason == events.ConnectFailureTempBanned {
+			queue.WaPause(0)
+			utils.TgReportError(utils.ErrorCategoryBan, "WhatsApp refused the connection because the account is temporarily banned", nil)
+			notifyAdmins("WhatsApp refused the connection because the account is temporarily banned, sending is paused until it reconnects")
+		} else {
+			ConnectionStateEventHandler(v)
+		}
+
+	case *events.Connected:
+		queue.WaResume()
+		utils.WaPresenceOnConnect()
+		ConnectionStateEventHandler(v)
+
+	case *events.Disconnected, *events.StreamReplaced:
+		ConnectionStateEventHandler(v)
+
 	case *events.Receipt:
 		ReceiptEventHandler(v)
 
+	// Without topics there is nowhere to keep chat pictures and settings apart
 	case *events.Picture:
-		if !cfg.WhatsApp.SkipProfilePictureUpdates {
+		if !cfg.WhatsApp.SkipProfilePictureUpdates && !cfg.Telegram.CombinedFeed {
 			PictureEventHandler(v)
 		}
 
 	case *events.GroupInfo:
-		if !cfg.WhatsApp.SkipGroupSettingsUpdates {
+		if !cfg.WhatsApp.SkipGroupSettingsUpdates && !cfg.Telegram.CombinedFeed {
 			GroupInfoEventHandler(v)
 		}
 
 	case *events.PushName:
 		PushNameEventHandler(v)
 
+	case *events.Contact:
+		ContactEventHandler(v)
+
+	case *events.HistorySync:
+		HistorySyncEventHandler(v)
+
+	case *events.JoinedGroup:
+		if cfg.WhatsApp.CreateTopicsForJoinedGroups {
+			JoinedGroupEventHandler(v)
+		}
+
 	case *events.UserAbout:
 		UserAboutEventHandler(v)
 
 	case *events.CallOffer:
 		CallOfferEventHandler(v)
 
+	case *events.ClearChat:
+		if cfg.WhatsApp.ChatClearAction == "notice" || cfg.WhatsApp.ChatClearAction == "delete" {
+			ClearChatEventHandler(v)
+		}
+
 	case *events.UndecryptableMessage:
 		UndecryptableMessageEventHandler(v)
 
+	case *events.MediaRetry:
+		MediaRetryEventHandler(v)
+
 	case *events.Message:
 
 		isEdited := false
@@ -72,6 +132,13 @@ func WhatsAppEventHandler(evt interface{}) {
 			return
 		}
 
+		if v.Message.GetPinInChatMessage() != nil {
+			if cfg.WhatsApp.PinMessageAction == "notice" || cfg.WhatsApp.PinMessageAction == "pin" {
+				PinInChatEventHandler(v)
+			}
+			return
+		}
+
 		if protoMsg := v.Message.GetProtocolMessage(); protoMsg != nil &&
 			protoMsg.GetType() == waE2E.ProtocolMessage_EPHEMERAL_SETTING {
 			if protoMsg.GetEphemeralExpiration() == 0 {
@@ -92,14 +159,15 @@ func WhatsAppEventHandler(evt interface{}) {
 				text = msg.GetConversation()
 			}
 		} else {
-			if extendedMessageText := v.Message.GetExtendedTextMessage().GetText(); extendedMessageText != "" {
-				text = extendedMessageText
-			} else {
-				text = v.Message.GetConversation()
-			}
+			text = waMessageText(v.Message)
 		}
 
-		if v.Info.IsFromMe {
+		if isReprocessResponse(v) {
+			defer finishReprocessRequest(v)
+			MessageFromOthersEventHandler(text, v, isEdited)
+		} else if v.Message.GetReactionMessage() != nil && debounceReaction(text, v) {
+			return
+		} else if v.Info.IsFromMe {
 			MessageFromMeEventHandler(text, v, isEdited)
 		} else {
 			MessageFromOthersEventHandler(text, v, isEdited)
@@ -119,6 +187,17 @@ func MessageFromMeEventHandler(text string, v *events.Message, isEdited bool) {
 		msgId = v.Info.ID
 	}
 
+	// Messages sent by the bridge are normally not echoed back, but copies
+	// synced from the account's other devices are, sometimes under the LID of
+	// the chat, which the duplicate check by chat and message ID would miss
+	if v.Info.Sender.Device == state.State.WhatsAppClient.Store.ID.Device || queue.WaSentByBridge(v.Info.ID) {
+		logger.Debug("returning because the message was sent by the bridge",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+		)
+		return
+	}
+
 	// Get ID of the current chat
 	if text == ".id" {
 
@@ -150,14 +229,48 @@ func MessageFromMeEventHandler(text string, v *events.Message, isEdited bool) {
 		}
 	}
 
-	if state.State.Config.WhatsApp.SendMyMessagesFromOtherDevices {
+	if messageOptedOut(text, v, msgId, isEdited) {
+		// Return if the message starts with no_bridge_prefix
+		logger.Debug("returning because message starts with no_bridge_prefix",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+		)
+		return
+	}
+
+	if state.State.Config().WhatsApp.SendMyMessagesFromOtherDevices {
 		MessageFromOthersEventHandler(text, v, isEdited)
+	} else {
+		utils.WaRecordChatActivity(v.Info.Chat, v.Info.Timestamp)
+	}
+}
+
+// waMessageContent returns the text of the message, or the caption of its
+// media if it has no text.
+func waMessageContent(text string, v *events.Message, isEdited bool) string {
+	if text != "" {
+		return text
 	}
+	msg := v.Message
+	if isEdited {
+		msg = msg.GetProtocolMessage().GetEditedMessage()
+	}
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	case msg.GetDocumentWithCaptionMessage() != nil:
+		return msg.GetDocumentWithCaptionMessage().GetMessage().GetDocumentMessage().GetCaption()
+	}
+	return ""
 }
 
 func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool) {
 	var (
-		cfg      = state.State.Config
+		cfg      = state.State.Config()
 		logger   = state.State.Logger
 		tgBot    = state.State.TelegramBot
 		waClient = state.State.WhatsAppClient
@@ -171,7 +284,11 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		msgId = v.Info.ID
 	}
 
-	if !isEdited {
+	reprocessing := isReprocessResponse(v) || isMediaRetryRebridge(v)
+	msgContent := waMessageContent(text, v, isEdited)
+	waOrigin := queue.WaOrigin{MsgId: msgId, ParticipantId: v.Info.MessageSource.Sender.String(), ChatId: v.Info.Chat.String(), Content: msgContent}
+
+	if !isEdited && !reprocessing {
 		// Return if duplicate event is emitted
 		tgChatId, _, _, _ := database.MsgIdGetTgFromWa(msgId, v.Info.Chat.String())
 		if tgChatId == cfg.Telegram.TargetChatID {
@@ -183,6 +300,17 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		}
 	}
 
+	if maxAge := cfg.WhatsApp.IgnoreMessagesOlderThanHours; maxAge > 0 && !reprocessing &&
+		time.Since(v.Info.Timestamp) > time.Duration(maxAge)*time.Hour {
+		// Return if the message is an old one replayed after reconnecting
+		logger.Debug("returning because message is older than ignore_messages_older_than_hours",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+			zap.Time("timestamp", v.Info.Timestamp),
+		)
+		return
+	}
+
 	if v.Info.Chat.String() == "status@broadcast" &&
 		(cfg.WhatsApp.SkipStatus ||
 			slices.Contains(cfg.WhatsApp.StatusIgnoredChats, v.Info.MessageSource.Sender.User)) {
@@ -201,6 +329,15 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		return
 	}
 
+	if !v.Info.IsFromMe && !senderIsAllowed(v.Info.MessageSource) {
+		// Return if the sender is not in the allowlist
+		logger.Debug("returning because message from a sender not in allowed_senders",
+			zap.String("event_id", v.Info.ID),
+			zap.String("sender_jid", v.Info.MessageSource.Sender.String()),
+		)
+		return
+	}
+
 	if waClient.Store.ChatSettings != nil {
 		chatSettings, err := waClient.Store.ChatSettings.GetChatSettings(context.Background(), v.Info.Chat)
 		if err == nil && chatSettings.Archived {
@@ -213,6 +350,38 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		}
 	}
 
+	// Checked against the activity before this message
+	defer utils.WaRecordChatActivity(v.Info.Chat, v.Info.Timestamp)
+
+	if !reprocessing && chatIsDormant(v) {
+		// Return if the chat has no topic and had no messages within active_chat_window_days
+		logger.Debug("returning because message is from a dormant chat without a topic",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+			zap.Time("timestamp", v.Info.Timestamp),
+		)
+		return
+	}
+
+	newChatRoute := routeNewChatMessage(text, v, isEdited)
+	if newChatRoute == newChatHeld {
+		// Return if the chat has no topic yet and hasn't sent enough messages for one
+		logger.Debug("holding back message until the chat reaches new_chat_min_messages",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+		)
+		return
+	}
+
+	if !chatRateLimitAdmit(text, v, isEdited) {
+		// Return if the chat is over chat_rate_limit_per_minute, the message was buffered or dropped
+		return
+	}
+
+	if !v.Info.IsFromMe {
+		utils.WaSyncContactsIfUnknown(v.Info.Sender)
+	}
+
 	replyMarkup := utils.TgBuildUrlButton(utils.WaGetContactName(v.Info.Sender), fmt.Sprintf("https://wa.me/%s", v.Info.MessageSource.Sender.ToNonAD().User))
 	if !isEdited {
 		if lowercaseText := strings.ToLower(text); !v.Info.IsFromMe && v.Info.IsGroup && slices.Contains(cfg.WhatsApp.TagAllAllowedGroups, v.Info.Chat.User) &&
@@ -225,6 +394,13 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		}
 	}
 
+	// The sender line can be hidden separately for groups and private chats,
+	// except in the combined feed where nothing else tells the messages apart
+	showSender := cfg.Telegram.CombinedFeed || !cfg.WhatsApp.HideSenderInPrivateChats
+	if v.Info.IsGroup && !cfg.Telegram.CombinedFeed {
+		showSender = !cfg.WhatsApp.HideSenderInGroups
+	}
+
 	var bridgedText string
 	if cfg.WhatsApp.SkipChatDetails {
 		logger.Debug("skipping to add chat details as configured",
@@ -232,20 +408,30 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		)
 		if v.Info.IsIncomingBroadcast() {
 			bridgedText += "👥: <b>(Broadcast)</b>\n"
-		} else if v.Info.IsFromMe {
+		} else if v.Info.IsFromMe && showSender {
 			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else if v.Info.IsGroup {
+		} else if v.Info.IsGroup && showSender {
 			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
 		}
+		if cfg.WhatsApp.ShowSenderNumberInGroups && v.Info.IsGroup && !v.Info.IsFromMe {
+			if number := utils.WaGetSenderPhoneNumber(v.Info.MessageSource); number != "" {
+				bridgedText += fmt.Sprintf("📞: <code>%s</code>\n", number)
+			}
+		}
 
 	} else {
 
-		if v.Info.IsFromMe {
+		if showSender && v.Info.IsFromMe {
 			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else {
+		} else if showSender {
 			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
 		}
-		if v.Info.IsIncomingBroadcast() {
+		if cfg.WhatsApp.ShowSenderNumberInGroups && v.Info.IsGroup && !v.Info.IsFromMe {
+			if number := utils.WaGetSenderPhoneNumber(v.Info.MessageSource); number != "" {
+				bridgedText += fmt.Sprintf("📞: <code>%s</code>\n", number)
+			}
+		}
+		if v.Info.Chat.IsBroadcastList() {
 			bridgedText += "👥: <b>(Broadcast)</b>\n"
 		} else if v.Info.IsGroup {
 			bridgedText += fmt.Sprintf("👥: <b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
@@ -259,7 +445,7 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		bridgedText += "<i>Edited</i>\n"
 	}
 
-	if time.Since(v.Info.Timestamp).Seconds() > 60 {
+	if cfg.WhatsApp.AlwaysShowTimestamp || time.Since(v.Info.Timestamp).Seconds() > 60 {
 		bridgedText += fmt.Sprintf("🕛: <b>%s</b>\n",
 			html.EscapeString(v.Info.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
 	}
@@ -268,6 +454,8 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		replyToMsgId  int64
 		threadId      int64
 		threadIdFound bool
+		replyToStatus bool
+		adThumbnail   []byte
 	)
 
 	if isEdited {
@@ -359,6 +547,11 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				zap.String("event_id", v.Info.ID),
 			)
 			contextInfo = v.Message.GetPollCreationMessageV3().GetContextInfo()
+		} else if v.Message.GetProductMessage() != nil {
+			logger.Debug("taking context info from ProductMessage",
+				zap.String("event_id", v.Info.ID),
+			)
+			contextInfo = v.Message.GetProductMessage().GetContextInfo()
 		} else {
 			logger.Debug("no context info found in any kind of messages",
 				zap.String("event_id", v.Info.ID),
@@ -371,6 +564,36 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				bridgedText += fmt.Sprintf("⏩: Forwarded %v times\n", contextInfo.GetForwardingScore())
 			}
 
+			// The chat was started from an ad, which WhatsApp shows above the message
+			if adReply := contextInfo.GetExternalAdReply(); adReply.GetTitle() != "" || adReply.GetBody() != "" {
+				bridgedText += "📢: <b>From an ad</b>"
+				if title := adReply.GetTitle(); title != "" {
+					bridgedText += fmt.Sprintf(" — %s", html.EscapeString(utils.SubString(title, 0, 200)))
+				}
+				bridgedText += "\n"
+				if body := adReply.GetBody(); body != "" {
+					bridgedText += fmt.Sprintf("<blockquote>%s</blockquote>\n", html.EscapeString(utils.SubString(body, 0, 300)))
+				}
+				if sourceUrl := adReply.GetSourceURL(); sourceUrl != "" {
+					bridgedText += fmt.Sprintf("🔗: %s\n", html.EscapeString(sourceUrl))
+				}
+				adThumbnail = adReply.GetThumbnail()
+			}
+
+			if threshold := cfg.WhatsApp.FrequentlyForwardedThreshold; threshold > 0 &&
+				contextInfo.GetForwardingScore() >= uint32(threshold) {
+				switch cfg.WhatsApp.FrequentlyForwardedAction {
+				case "warn":
+					bridgedText += "⚠️: <b>Frequently forwarded</b>\n"
+				case "skip":
+					logger.Debug("returning because message is frequently forwarded",
+						zap.String("event_id", v.Info.ID),
+						zap.Uint32("forwarding_score", contextInfo.GetForwardingScore()),
+					)
+					return
+				}
+			}
+
 			logger.Debug("checking if your account is mentioned in the message",
 				zap.String("event_id", v.Info.ID),
 			)
@@ -401,22 +624,48 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				zap.String("event_id", v.Info.ID),
 			)
 			stanzaId := contextInfo.GetStanzaID()
-			tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(stanzaId, v.Info.Chat.String())
-			if err == nil && tgChatId == cfg.Telegram.TargetChatID {
+			quotedChatId := waQuotedChatId(v.Info.Chat, contextInfo)
+			replyToStatus = quotedChatId == "status@broadcast"
+			crossChatQuote := !replyToStatus && quotedChatId != v.Info.Chat.String()
+			if tgThreadId, tgMsgId, found := waResolveQuote(v.Info.Chat, contextInfo); found {
 				replyToMsgId = tgMsgId
 				threadId = tgThreadId
 				threadIdFound = true
+			} else if stanzaId != "" && !replyToStatus && (crossChatQuote || cfg.WhatsApp.QuoteUnbridgedReplies) {
+				// The quoted message was never bridged, or is in the topic of
+				// another chat, so it is quoted instead
+				bridgedText += fmt.Sprintf("↩️: Replying to <b>%s</b>\n", html.EscapeString(waQuotedSenderName(contextInfo.GetParticipant())))
+				quotedMsg := &events.Message{Message: contextInfo.GetQuotedMessage()}
+				if quotedText := waMessageContent(waMessageText(quotedMsg.Message), quotedMsg, false); quotedText != "" {
+					bridgedText += fmt.Sprintf("<blockquote>%s</blockquote>\n", html.EscapeString(utils.SubString(quotedText, 0, 300)))
+				}
 			}
 		}
 	}
 
+	if replyToStatus {
+		// Replies to a mirrored status go to the Status topic, where the
+		// sender must be named. Others stay in the chat's topic.
+		if threadIdFound && !strings.Contains(bridgedText, "🧑: ") {
+			bridgedText = fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender))) + bridgedText
+		}
+		bridgedText += "<i>Replied to a status</i>\n"
+	}
+
 	if !strings.HasSuffix(bridgedText, "\n\n") {
 		bridgedText += "\n"
 	}
 
 	if !threadIdFound {
 		var err error
-		if v.Info.Chat.String() == "status@broadcast" {
+		if newChatRoute == newChatQuarantine {
+			threadId, err = utils.TgGetOrMakeThreadFromWa_String(utils.WaQuarantineChatId, cfg.Telegram.TargetChatID,
+				"Quarantine")
+			if err != nil {
+				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "failed to create/find thread id for 'quarantine'", err)
+				return
+			}
+		} else if v.Info.Chat.String() == "status@broadcast" {
 			threadId, err = utils.TgGetOrMakeThreadFromWa_String("status@broadcast", cfg.Telegram.TargetChatID,
 				"Status")
 			if err != nil {
@@ -436,6 +685,15 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					v.Info.MessageSource.Sender.ToNonAD().String()), err)
 				return
 			}
+		} else if v.Info.Chat.IsBroadcastList() {
+			// Sent by you to a broadcast list from another device, the list
+			// itself is not a chat so these get a shared topic
+			threadId, err = utils.TgGetOrMakeThreadFromWa_String("broadcast_lists", cfg.Telegram.TargetChatID,
+				"Broadcast Lists")
+			if err != nil {
+				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "failed to create/find thread id for 'broadcast_lists'", err)
+				return
+			}
 		} else if v.Info.IsGroup {
 			threadId, err = utils.TgGetOrMakeThreadFromWa(v.Info.Chat, cfg.Telegram.TargetChatID,
 				utils.WaGetGroupName(v.Info.Chat))
@@ -454,6 +712,54 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				return
 			}
 		}
+
+		// The message goes to the General topic because the topic couldn't be
+		// created, or to a topic shared by several chats, so the chat must be
+		// named in the header
+		if cfg.WhatsApp.SkipChatDetails && !v.Info.IsIncomingBroadcast() &&
+			(utils.TgIsGeneralThread(threadId) || newChatRoute == newChatQuarantine || threadIsShared(threadId)) {
+			if v.Info.IsGroup {
+				bridgedText = fmt.Sprintf("👥: <b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat))) + bridgedText
+			} else {
+				bridgedText = fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.Chat.ToNonAD()))) + bridgedText
+			}
+		}
+	}
+
+	var (
+		isMediaMsg   = true
+		mediaCaption string
+	)
+	switch {
+	case v.Message.GetImageMessage() != nil:
+		mediaCaption = v.Message.GetImageMessage().GetCaption()
+	case v.Message.GetVideoMessage() != nil:
+		mediaCaption = v.Message.GetVideoMessage().GetCaption()
+	case v.Message.GetDocumentMessage() != nil:
+		mediaCaption = v.Message.GetDocumentMessage().GetCaption()
+	case v.Message.GetPtvMessage() != nil, v.Message.GetAudioMessage() != nil, v.Message.GetStickerMessage() != nil:
+	default:
+		isMediaMsg = false
+	}
+
+	if isMediaMsg {
+		if mediaEnabled, _ := database.ChatThreadGetMediaEnabled(cfg.Telegram.TargetChatID, threadId); !mediaEnabled {
+			logger.Debug("skipping media download because media is disabled for the topic",
+				zap.String("event_id", v.Info.ID),
+				zap.Int64("thread_id", threadId),
+			)
+			bridgedText += html.EscapeString(cfg.WhatsApp.Placeholders.MediaOmitted)
+			if mediaCaption != "" {
+				bridgedText += "\n\n" + html.EscapeString(mediaCaption)
+			}
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				ReplyParameters: &gotgbot.ReplyParameters{
+					MessageId: replyToMsgId,
+				},
+				MessageThreadId: threadId,
+			}, waOrigin)
+			return
+		}
 	}
 
 	if v.Message.GetImageMessage() != nil {
@@ -465,44 +771,32 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipImages {
 			bridgedText += "\n<i>Skipping image because 'skip_images' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else if !cfg.Telegram.SelfHostedAPI && imageMsg.GetFileLength() > utils.UploadSizeLimit {
 			bridgedText += "\n<i>Couldn't send the photo as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else {
-			imageBytes, err := waClient.Download(context.Background(), imageMsg)
+			imageBytes, err := utils.WaDownload(imageMsg)
 			if err != nil {
-				bridgedText += "\n<i>Couldn't download the photo due to some errors</i>"
-				sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				bridgedText += mediaDownloadFailed(v, "photo", mediaCaption, err)
+				queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyParameters: &gotgbot.ReplyParameters{
 						MessageId: replyToMsgId,
 					},
 					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
+				}, waOrigin)
 				return
 			}
 
@@ -522,9 +816,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				HasSpoiler:      imageMsg.GetViewOnce(),
 				MessageThreadId: threadId,
 			})
-			if sentMsg.MessageId != 0 {
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 			return
 		}
@@ -538,44 +832,33 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipGIFs {
 			bridgedText += "\n<i>Skipping GIF because 'skip_gifs' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else if !cfg.Telegram.SelfHostedAPI && gifMsg.GetFileLength() > utils.UploadSizeLimit {
 			bridgedText += "\n<i>Couldn't send the GIF as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else {
-			gifBytes, err := waClient.Download(context.Background(), gifMsg)
+			gifData, cleanup, err := utils.WaDownloadMedia(gifMsg, gifMsg.GetFileLength())
+			defer cleanup()
 			if err != nil {
-				bridgedText += "\n<i>Couldn't download the GIF due to some errors</i>"
-				sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				bridgedText += mediaDownloadFailed(v, "GIF", mediaCaption, err)
+				queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyParameters: &gotgbot.ReplyParameters{
 						MessageId: replyToMsgId,
 					},
 					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
+				}, waOrigin)
 				return
 			}
 
@@ -589,7 +872,7 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 			fileToSend := gotgbot.FileReader{
 				Name: "animation.gif",
-				Data: bytes.NewReader(gifBytes),
+				Data: gifData,
 			}
 
 			sentMsg, _ := queue.TgSendAnimation(tgBot, cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendAnimationOpts{
@@ -599,9 +882,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				},
 				MessageThreadId: threadId,
 			})
-			if sentMsg.MessageId != 0 {
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 			return
 		}
@@ -623,44 +906,33 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipVideos {
 			bridgedText += "\n<i>Skipping video because 'skip_videos' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else if !cfg.Telegram.SelfHostedAPI && videoMsg.GetFileLength() > utils.UploadSizeLimit {
 			bridgedText += "\n<i>Couldn't send the video as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else {
-			videoBytes, err := waClient.Download(context.Background(), videoMsg)
+			videoData, cleanup, err := utils.WaDownloadMedia(videoMsg, videoMsg.GetFileLength())
+			defer cleanup()
 			if err != nil {
-				bridgedText += "\n<i>Couldn't download the video due to some errors</i>"
-				sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				bridgedText += mediaDownloadFailed(v, "video", mediaCaption, err)
+				queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyParameters: &gotgbot.ReplyParameters{
 						MessageId: replyToMsgId,
 					},
 					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
+				}, waOrigin)
 				return
 			}
 
@@ -674,7 +946,7 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 			fileToSend := gotgbot.FileReader{
 				Name: "video." + strings.Split(videoMsg.GetMimetype(), "/")[1],
-				Data: bytes.NewReader(videoBytes),
+				Data: videoData,
 			}
 
 			var sentMsg *gotgbot.Message = nil
@@ -696,9 +968,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					MessageThreadId: threadId,
 				})
 			}
-			if sentMsg.MessageId != 0 {
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 			return
 		}
@@ -712,63 +984,65 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipVoiceNotes {
 			bridgedText += "\n<i>Skipping voice note because 'skip_voice_notes' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else if !cfg.Telegram.SelfHostedAPI && audioMsg.GetFileLength() > utils.UploadSizeLimit {
 			bridgedText += "\n<i>Couldn't send the audio as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else {
-			audioBytes, err := waClient.Download(context.Background(), audioMsg)
+			audioBytes, err := utils.WaDownload(audioMsg)
 			if err != nil {
-				bridgedText += "\n<i>Couldn't download the audio due to some errors</i>"
-				sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				bridgedText += mediaDownloadFailed(v, "audio", "", err)
+				queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyParameters: &gotgbot.ReplyParameters{
 						MessageId: replyToMsgId,
 					},
 					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
+				}, waOrigin)
 				return
 			}
 
 			fileToSend := gotgbot.FileReader{
-				Name: "audio.ogg",
+				Name: "voice.ogg",
 				Data: bytes.NewReader(audioBytes),
 			}
 
-			sentMsg, _ := queue.TgSendAudio(tgBot, cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendAudioOpts{
+			// Telegram shows 0:00 for voice notes without a duration, and
+			// draws the waveform itself from the OGG/Opus file
+			duration := int64(audioMsg.GetSeconds())
+			if duration == 0 {
+				if probedDuration, err := utils.ProbeMediaDuration(audioBytes); err == nil {
+					duration = probedDuration
+				} else {
+					logger.Debug("failed to probe the duration of a voice note",
+						zap.String("event_id", v.Info.ID),
+						zap.Error(err),
+					)
+				}
+			}
+
+			sentMsg, _ := queue.TgSendVoice(tgBot, cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendVoiceOpts{
 				Caption:  bridgedText,
-				Duration: int64(audioMsg.GetSeconds()),
+				Duration: duration,
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
 			})
-			if sentMsg.MessageId != 0 {
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 			return
 		}
@@ -782,50 +1056,39 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipAudios {
 			bridgedText += "\n<i>Skipping audio because 'skip_audios' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else if !cfg.Telegram.SelfHostedAPI && audioMsg.GetFileLength() > utils.UploadSizeLimit {
 			bridgedText += "\n<i>Couldn't send the audio as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else {
-			audioBytes, err := waClient.Download(context.Background(), audioMsg)
+			audioData, cleanup, err := utils.WaDownloadMedia(audioMsg, audioMsg.GetFileLength())
+			defer cleanup()
 			if err != nil {
-				bridgedText += "\n<i>Couldn't download the audio due to some errors</i>"
-				sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				bridgedText += mediaDownloadFailed(v, "audio", "", err)
+				queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyParameters: &gotgbot.ReplyParameters{
 						MessageId: replyToMsgId,
 					},
 					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
+				}, waOrigin)
 				return
 			}
 
 			fileToSend := gotgbot.FileReader{
 				Name: "audio.m4a",
-				Data: bytes.NewReader(audioBytes),
+				Data: audioData,
 			}
 
 			sentMsg, _ := queue.TgSendAudio(tgBot, cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendAudioOpts{
@@ -836,9 +1099,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				},
 				MessageThreadId: threadId,
 			})
-			if sentMsg.MessageId != 0 {
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 			return
 		}
@@ -852,44 +1115,33 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipDocuments {
 			bridgedText += "\n<i>Skipping document because 'skip_documents' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else if !cfg.Telegram.SelfHostedAPI && documentMsg.GetFileLength() > utils.UploadSizeLimit {
 			bridgedText += "\n<i>Couldn't send the document as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else {
-			documentBytes, err := waClient.Download(context.Background(), documentMsg)
+			documentData, cleanup, err := utils.WaDownloadMedia(documentMsg, documentMsg.GetFileLength())
+			defer cleanup()
 			if err != nil {
-				bridgedText += "\n<i>Couldn't download the document due to some errors</i>"
-				sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				bridgedText += mediaDownloadFailed(v, "document", mediaCaption, err)
+				queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyParameters: &gotgbot.ReplyParameters{
 						MessageId: replyToMsgId,
 					},
 					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
+				}, waOrigin)
 				return
 			}
 
@@ -902,20 +1154,25 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			}
 
 			fileToSend := gotgbot.FileReader{
-				Name: documentMsg.GetFileName(),
-				Data: bytes.NewReader(documentBytes),
+				Name: utils.WaFormatDocumentFileName(documentMsg.GetFileName(), v.Info.Sender, v.Info.Timestamp),
+				Data: documentData,
 			}
 
-			sentMsg, _ := queue.TgSendDocument(tgBot, cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendDocumentOpts{
+			sendOpts := &gotgbot.SendDocumentOpts{
 				Caption: bridgedText,
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
+			}
+			if thumbnail := utils.WaGetDocumentThumbnail(documentMsg); thumbnail != nil {
+				sendOpts.Thumbnail = &gotgbot.FileReader{Name: "thumbnail.jpg", Data: bytes.NewReader(thumbnail)}
+			}
+
+			sentMsg, _ := queue.TgSendDocument(tgBot, cfg.Telegram.TargetChatID, &fileToSend, sendOpts)
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 			return
 		}
@@ -929,44 +1186,32 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipStickers {
 			bridgedText += "\n<i>Skipping sticker because 'skip_stickers' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else if !cfg.Telegram.SelfHostedAPI && stickerMsg.GetFileLength() > utils.UploadSizeLimit {
 			bridgedText += "\n<i>Couldn't send the sticker as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		} else {
-			stickerBytes, err := waClient.Download(context.Background(), stickerMsg)
+			stickerBytes, err := utils.WaDownload(stickerMsg)
 			if err != nil {
-				bridgedText += "\n<i>Couldn't download the sticker due to some errors</i>"
-				sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				bridgedText += mediaDownloadFailed(v, "sticker", "", err)
+				queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 					ReplyParameters: &gotgbot.ReplyParameters{
 						MessageId: replyToMsgId,
 					},
 					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
+				}, waOrigin)
 				return
 			}
 			if stickerMsg.GetIsAnimated() || stickerMsg.GetIsAvatar() {
@@ -988,9 +1233,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					MessageThreadId: threadId,
 					ReplyMarkup:     replyMarkup,
 				})
-				if sentMsg.MessageId != 0 {
+				if sentMsg != nil && sentMsg.MessageId != 0 {
 					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 				}
 				return
 
@@ -1003,9 +1248,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				MessageThreadId: threadId,
 				ReplyMarkup:     replyMarkup,
 			})
-			if sentMsg.MessageId != 0 {
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 		}
 
@@ -1014,16 +1259,12 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipContacts {
 			bridgedText += "\n<i>Skipping contact because 'skip_contacts' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		}
 
@@ -1031,16 +1272,12 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 		card, err := decoder.Decode()
 		if err != nil {
 			bridgedText += "\n<i>Couldn't send the vCard as failed to parse it</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		}
 
@@ -1063,9 +1300,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				MessageThreadId: threadId,
 				ReplyMarkup:     replyMarkup,
 			})
-		if sentMsg.MessageId != 0 {
+		if sentMsg != nil && sentMsg.MessageId != 0 {
 			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 		}
 		return
 
@@ -1075,16 +1312,12 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipContacts {
 			bridgedText += "\n<i>Skipping contact array because 'skip_contacts' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		}
 		for _, contactMsg := range contactsMsg.Contacts {
@@ -1110,9 +1343,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					MessageThreadId: threadId,
 					ReplyMarkup:     replyMarkup,
 				})
-			if sentMsg.MessageId != 0 {
+			if sentMsg != nil && sentMsg.MessageId != 0 {
 				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 			}
 		}
 		return
@@ -1123,16 +1356,12 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipLocations {
 			bridgedText += "\n<i>Skipping location because 'skip_locations' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		}
 		sentMsg, _ := queue.TgSendLocation(tgBot, cfg.Telegram.TargetChatID, locationMsg.GetDegreesLatitude(), locationMsg.GetDegreesLongitude(),
@@ -1143,9 +1372,9 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				},
 				MessageThreadId: threadId,
 			})
-		if sentMsg.MessageId != 0 {
+		if sentMsg != nil && sentMsg.MessageId != 0 {
 			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 		}
 
 		return
@@ -1156,29 +1385,21 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 
 		if cfg.WhatsApp.SkipLocations {
 			bridgedText += "\n<i>Skipping live location because 'skip_locations' set in config file</i>"
-			sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 				ReplyParameters: &gotgbot.ReplyParameters{
 					MessageId: replyToMsgId,
 				},
 				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+			}, waOrigin)
 			return
 		}
 
-		sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+		queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 			ReplyParameters: &gotgbot.ReplyParameters{
 				MessageId: replyToMsgId,
 			},
 			MessageThreadId: threadId,
-		})
-		if sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-		}
+		}, waOrigin)
 		return
 
 	} else if v.Message.GetPollCreationMessage() != nil || v.Message.GetPollCreationMessageV2() != nil || v.Message.GetPollCreationMessageV3() != nil {
@@ -1203,48 +1424,151 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			bridgedText += fmt.Sprintf("%v. %s\n", optionNum+1, html.EscapeString(option.GetOptionName()))
 		}
 
-		sentMsg, _ := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+		queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 			ReplyParameters: &gotgbot.ReplyParameters{
 				MessageId: replyToMsgId,
 			},
 			MessageThreadId: threadId,
-		})
-		if sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-		}
+		}, waOrigin)
 		return
 
-	} else {
-		if text == "" {
-			if reactionMsg := v.Message.GetReactionMessage(); cfg.Telegram.Reactions && reactionMsg != nil {
-				// Resolve LID to PN for private chats using new WhatsApp LID system
-				waChatIdForLookup := v.Info.Chat.String()
-				if v.Info.Chat.Server == waTypes.HiddenUserServer {
-					pn, err := waClient.Store.LIDs.GetPNForLID(context.Background(), v.Info.Chat.ToNonAD())
-					if err != nil {
-						logger.Warn(
-							"failed to get PN for LID when handling reaction",
-							zap.Error(err),
-							zap.String("lid", v.Info.Chat.String()),
-						)
-					} else {
-						waChatIdForLookup = pn.String()
-					}
-				}
+	} else if v.Message.GetProductMessage() != nil {
 
-				tgChatId, _, tgMsgId, err := database.MsgIdGetTgFromWa(reactionMsg.Key.GetID(), waChatIdForLookup)
-				if err != nil {
-					logger.Error(
-						"failed to get message ID mapping from database",
-						zap.Error(err),
-						zap.String("stanza_id", reactionMsg.Key.GetID()),
-						zap.String("chat_id", waChatIdForLookup),
-					)
-				} else if tgChatId == cfg.Telegram.TargetChatID {
+		productMsg := v.Message.GetProductMessage()
+		product := productMsg.GetProduct()
 
-					if *reactionMsg.Text != "" {
-						text = fmt.Sprintf(
+		bridgedText += "\n🛍️ <b>Product</b>\n"
+		if title := product.GetTitle(); title != "" {
+			bridgedText += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(title))
+		}
+		if price := product.GetPriceAmount1000(); price > 0 {
+			priceText := fmt.Sprintf("%.2f %s", float64(price)/1000, product.GetCurrencyCode())
+			if salePrice := product.GetSalePriceAmount1000(); salePrice > 0 && salePrice < price {
+				priceText = fmt.Sprintf("<s>%s</s> %.2f %s", priceText, float64(salePrice)/1000, product.GetCurrencyCode())
+			}
+			bridgedText += fmt.Sprintf("💰: %s\n", priceText)
+		}
+		if description := product.GetDescription(); description != "" {
+			if len(description) > 600 {
+				description = utils.SubString(description, 0, 600) + "..."
+			}
+			bridgedText += html.EscapeString(description) + "\n"
+		}
+		if productUrl := product.GetURL(); productUrl != "" {
+			bridgedText += fmt.Sprintf("🔗: %s\n", html.EscapeString(productUrl))
+		}
+		if body := productMsg.GetBody(); body != "" {
+			bridgedText += "\n" + html.EscapeString(body)
+		}
+
+		var sentMsg *gotgbot.Message
+		if productImage := product.GetProductImage(); productImage != nil && !cfg.WhatsApp.SkipImages && len(bridgedText) <= 1024 {
+			imageBytes, err := waClient.Download(context.Background(), productImage)
+			if err == nil {
+				sentMsg, _ = queue.TgSendPhoto(tgBot, cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(imageBytes)}, &gotgbot.SendPhotoOpts{
+					Caption: bridgedText,
+					ReplyParameters: &gotgbot.ReplyParameters{
+						MessageId: replyToMsgId,
+					},
+					MessageThreadId: threadId,
+				})
+			} else {
+				logger.Warn("failed to download product image",
+					zap.String("event_id", v.Info.ID),
+					zap.Error(err),
+				)
+			}
+		}
+		if sentMsg != nil && sentMsg.MessageId != 0 {
+			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
+		} else {
+			queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+				ReplyParameters: &gotgbot.ReplyParameters{
+					MessageId: replyToMsgId,
+				},
+				MessageThreadId: threadId,
+			}, waOrigin)
+		}
+		return
+
+	} else if summary, requestKey, ok := waPaymentSummary(v.Message); ok {
+
+		if !cfg.WhatsApp.BridgePayments {
+			logger.Debug("returning because payment and order messages are not bridged",
+				zap.String("event_id", v.Info.ID),
+			)
+			return
+		}
+
+		// Answers to a payment request are bridged as a reply to it
+		if replyToMsgId == 0 && requestKey.GetID() != "" {
+			tgChatId, _, tgMsgId, err := database.MsgIdGetTgFromWa(requestKey.GetID(), v.Info.Chat.String())
+			if err == nil && tgChatId == cfg.Telegram.TargetChatID {
+				replyToMsgId = tgMsgId
+			}
+		}
+
+		bridgedText += "\n" + summary
+
+		queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+			ReplyParameters: &gotgbot.ReplyParameters{
+				MessageId: replyToMsgId,
+			},
+			MessageThreadId: threadId,
+		}, waOrigin)
+		return
+
+	} else {
+		if text == "" {
+			if reactionMsg := v.Message.GetReactionMessage(); cfg.Telegram.Reactions && reactionMsg != nil {
+				// Resolve LID to PN for private chats using new WhatsApp LID system
+				waChatIdForLookup := v.Info.Chat.String()
+				if v.Info.Chat.Server == waTypes.HiddenUserServer {
+					pn, err := waClient.Store.LIDs.GetPNForLID(context.Background(), v.Info.Chat.ToNonAD())
+					if err != nil {
+						logger.Warn(
+							"failed to get PN for LID when handling reaction",
+							zap.Error(err),
+							zap.String("lid", v.Info.Chat.String()),
+						)
+					} else {
+						waChatIdForLookup = pn.String()
+					}
+				}
+
+				// Reactions to a status are looked up in the Status topic instead
+				reactedChatId := waChatIdForLookup
+				if reactionMsg.GetKey().GetRemoteJID() == "status@broadcast" {
+					reactedChatId = "status@broadcast"
+				}
+
+				tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(reactionMsg.Key.GetID(), reactedChatId)
+				if err != nil {
+					logger.Error(
+						"failed to get message ID mapping from database",
+						zap.Error(err),
+						zap.String("stanza_id", reactionMsg.Key.GetID()),
+						zap.String("chat_id", reactedChatId),
+					)
+				} else if tgChatId == cfg.Telegram.TargetChatID && cfg.Telegram.GroupReactionSummary && v.Info.IsGroup {
+					queueGroupReactionSummary(waChatIdForLookup, reactionMsg.Key.GetID(),
+						v.Info.MessageSource.Sender.ToNonAD().String(), reactionMsg.GetText(), tgMsgId, threadId)
+				} else if tgChatId == cfg.Telegram.TargetChatID {
+
+					if cfg.Telegram.NativeReactions && !v.Info.IsGroup && tgSetNativeReaction(tgMsgId, reactionMsg.GetText()) {
+						return
+					}
+
+					if reactedChatId == "status@broadcast" {
+						threadId = tgThreadId
+						if !strings.Contains(bridgedText, "🧑: ") {
+							bridgedText = fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender))) + bridgedText
+						}
+					}
+
+					if *reactionMsg.Text != "" {
+						text = fmt.Sprintf(
 							"<code>Reacted to this message with %s</code>",
 							html.EscapeString(*reactionMsg.Text),
 						)
@@ -1261,11 +1585,12 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 						MessageThreadId: threadId,
 					})
 					if err != nil {
+						utils.TgReportError(utils.ErrorCategorySend, "Failed to send a message to Telegram", err)
 						panic(fmt.Errorf("failed to send telegram message: %s", err))
 					}
-					if sentMsg.MessageId != 0 {
+					if sentMsg != nil && sentMsg.MessageId != 0 {
 						database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), waChatIdForLookup,
-							cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+							cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
 					}
 				}
 
@@ -1274,13 +1599,33 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 			return
 		}
 
+		if translated, ok := utils.TranslateWaText(v.Info.Chat, text); ok {
+			if cfg.Translation.Mode == "replace" {
+				text = translated
+			} else {
+				text += "\n\n🌐 " + translated
+			}
+		}
+
 		if len(text) > 4000 {
 			bridgedText += html.EscapeString(utils.SubString(text, 0, 4000)) + "..."
 		} else {
 			bridgedText += html.EscapeString(text)
 		}
 
-		if mentioned := v.Message.GetExtendedTextMessage().GetContextInfo().GetMentionedJID(); mentioned != nil {
+		// Edits are bridged as a reply to the original message, which Telegram
+		// doesn't flag as edited, so the content is marked explicitly
+		if isEdited && cfg.WhatsApp.EditedMarker != "" {
+			bridgedText += " <i>" + html.EscapeString(cfg.WhatsApp.EditedMarker) + "</i>"
+		}
+
+		if mentioned := v.Message.GetExtendedTextMessage().GetContextInfo().GetMentionedJID(); mentioned != nil &&
+			utils.FormattingGuardTriggered(len(text), len(mentioned)) {
+			logger.Warn("skipping mention processing for a long WhatsApp message",
+				zap.String("event_id", v.Info.ID),
+				zap.Int("mentions", len(mentioned)),
+			)
+		} else if mentioned != nil {
 			for _, jid := range mentioned {
 				parsedJid, _ := utils.WaParseJID(jid)
 				name := utils.WaGetContactName(parsedJid)
@@ -1294,13 +1639,54 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				)
 			}
 		}
-		sentMsg, err := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+
+		// Use the preview WhatsApp generated instead of letting Telegram fetch
+		// its own, which may look different or fail
+		var linkPreviewOpts *gotgbot.LinkPreviewOptions
+		if cfg.WhatsApp.LinkPreviewSource == "whatsapp" {
+			linkPreviewOpts = &gotgbot.LinkPreviewOptions{IsDisabled: true}
+			if extText := v.Message.GetExtendedTextMessage(); extText.GetTitle() != "" || extText.GetDescription() != "" {
+				bridgedText += "\n\n<blockquote>"
+				if title := extText.GetTitle(); title != "" {
+					bridgedText += "<b>" + html.EscapeString(title) + "</b>\n"
+				}
+				bridgedText += html.EscapeString(utils.SubString(extText.GetDescription(), 0, 300)) + "</blockquote>"
+			}
+		}
+
+		// Text messages started from an ad are sent along with its thumbnail
+		if len(adThumbnail) > 0 && !cfg.WhatsApp.SkipImages && len(bridgedText) <= 1024 {
+			sentMsg, err := queue.TgSendPhoto(tgBot, cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(adThumbnail)}, &gotgbot.SendPhotoOpts{
+				Caption: bridgedText,
+				ReplyParameters: &gotgbot.ReplyParameters{
+					MessageId: replyToMsgId,
+				},
+				MessageThreadId: threadId,
+			})
+			if err == nil {
+				if sentMsg != nil && sentMsg.MessageId != 0 {
+					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
+						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId, msgContent)
+				}
+				return
+			}
+			logger.Warn("failed to send the thumbnail of an ad, sending the message without it",
+				zap.String("event_id", v.Info.ID),
+				zap.Error(err),
+			)
+		}
+
+		_, err := queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 			ReplyParameters: &gotgbot.ReplyParameters{
 				MessageId: replyToMsgId,
 			},
-			MessageThreadId: threadId,
-		})
-		if err != nil {
+			MessageThreadId:    threadId,
+			LinkPreviewOptions: linkPreviewOpts,
+		}, waOrigin)
+		if errors.Is(err, queue.ErrTelegramUnreachable) {
+			// Sent and paired once Telegram is back
+			return
+		} else if err != nil {
 			// Check if topic was deleted and try to recreate
 			errStr := err.Error()
 			if strings.Contains(errStr, "message thread not found") || strings.Contains(errStr, "MESSAGE_THREAD_NOT_FOUND") || strings.Contains(errStr, "TOPIC_DELETED") || strings.Contains(errStr, "TOPIC_ID_INVALID") {
@@ -1319,10 +1705,11 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 					logger.Error("failed to recreate topic", zap.Error(recreateErr))
 				} else {
 					// logger.Info("topic recreated, retrying", zap.Int64("new_thread_id", newThreadId))
-					sentMsg, err = queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-						ReplyParameters: &gotgbot.ReplyParameters{},
-						MessageThreadId: newThreadId,
-					})
+					_, err = queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+						ReplyParameters:    &gotgbot.ReplyParameters{},
+						MessageThreadId:    newThreadId,
+						LinkPreviewOptions: linkPreviewOpts,
+					}, waOrigin)
 					if err != nil {
 						logger.Error("failed to resend telegram message after topic recreation", zap.Error(err))
 						return
@@ -1330,19 +1717,88 @@ func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool
 				}
 			} else {
 				logger.Error("failed to send telegram message", zap.Error(err))
+				utils.TgReportError(utils.ErrorCategorySend, "Failed to send a message to Telegram", err)
 				return
 			}
 		}
-		if sentMsg != nil && sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+	}
+}
+
+// waMessageText returns the text of a message that isn't an edit.
+func waMessageText(msg *waE2E.Message) string {
+	if extendedMessageText := msg.GetExtendedTextMessage().GetText(); extendedMessageText != "" {
+		return extendedMessageText
+	}
+	return msg.GetConversation()
+}
+
+// waQuotedChatId returns the chat of a quoted message, to look up its pair in.
+// It can differ from the chat it is quoted in, e.g. when a group message is
+// replied to in private.
+func waQuotedChatId(chat waTypes.JID, contextInfo *waE2E.ContextInfo) string {
+	if remoteJid := contextInfo.GetRemoteJID(); remoteJid != "" {
+		return remoteJid
+	}
+	return chat.String()
+}
+
+// waResolveQuote returns the bridged message that a message quotes, to reply
+// to it in Telegram. Quotes of another chat, e.g. a group message replied to
+// in private, aren't resolved, since the reply belongs in the topic of the
+// chat it was sent in. Only replies to statuses go to the Status topic.
+func waResolveQuote(chat waTypes.JID, contextInfo *waE2E.ContextInfo) (tgThreadId, tgMsgId int64, found bool) {
+	quotedChatId := waQuotedChatId(chat, contextInfo)
+	if contextInfo.GetStanzaID() == "" || (quotedChatId != chat.String() && quotedChatId != "status@broadcast") {
+		return 0, 0, false
+	}
+
+	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(contextInfo.GetStanzaID(), quotedChatId)
+	if err != nil || tgChatId != state.State.Config().Telegram.TargetChatID {
+		return 0, 0, false
+	}
+	return tgThreadId, tgMsgId, true
+}
+
+// waQuotedSenderName names the sender of a quoted message, which is "You" for
+// your own messages whichever device they were sent from.
+func waQuotedSenderName(participant string) string {
+	jid, ok := utils.WaParseJID(participant)
+	if participant == "" || !ok {
+		return "Unknown"
+	}
+	store := state.State.WhatsAppClient.Store
+	if (store.ID != nil && jid.User == store.ID.User) || jid.User == store.LID.User {
+		return "You"
+	}
+	return utils.WaGetContactName(jid.ToNonAD())
+}
+
+func threadIsShared(threadId int64) bool {
+	shared, _ := database.ChatThreadIsShared(state.State.Config().Telegram.TargetChatID, threadId)
+	return shared
+}
+
+// senderIsAllowed checks the sender against the allowed_senders list, which
+// can hold phone numbers or full JIDs. An empty list allows everyone.
+func senderIsAllowed(source waTypes.MessageSource) bool {
+	allowedSenders := state.State.Config().WhatsApp.AllowedSenders
+	if len(allowedSenders) == 0 {
+		return true
+	}
+
+	for _, allowed := range allowedSenders {
+		allowedUser, _, _ := strings.Cut(strings.TrimPrefix(allowed, "+"), "@")
+		if allowedUser == source.Sender.User ||
+			(!source.SenderAlt.IsEmpty() && allowedUser == source.SenderAlt.User) {
+			return true
 		}
 	}
+	return false
 }
 
 func UndecryptableMessageEventHandler(v *events.UndecryptableMessage) {
 	var (
-		cfg    = state.State.Config
+		cfg    = state.State.Config()
 		logger = state.State.Logger
 		tgBot  = state.State.TelegramBot
 		msgId  = v.Info.ID
@@ -1359,6 +1815,13 @@ func UndecryptableMessageEventHandler(v *events.UndecryptableMessage) {
 		return
 	}
 
+	// The sender line can be hidden separately for groups and private chats,
+	// except in the combined feed where nothing else tells the messages apart
+	showSender := cfg.Telegram.CombinedFeed || !cfg.WhatsApp.HideSenderInPrivateChats
+	if v.Info.IsGroup && !cfg.Telegram.CombinedFeed {
+		showSender = !cfg.WhatsApp.HideSenderInGroups
+	}
+
 	var bridgedText string
 	if cfg.WhatsApp.SkipChatDetails {
 		logger.Debug("skipping to add chat details as configured",
@@ -1366,19 +1829,29 @@ func UndecryptableMessageEventHandler(v *events.UndecryptableMessage) {
 		)
 		if v.Info.IsIncomingBroadcast() {
 			bridgedText += "👥: <b>(Broadcast)</b>\n"
-		} else if v.Info.IsFromMe {
+		} else if v.Info.IsFromMe && showSender {
 			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else if v.Info.IsGroup {
+		} else if v.Info.IsGroup && showSender {
 			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
 		}
+		if cfg.WhatsApp.ShowSenderNumberInGroups && v.Info.IsGroup && !v.Info.IsFromMe {
+			if number := utils.WaGetSenderPhoneNumber(v.Info.MessageSource); number != "" {
+				bridgedText += fmt.Sprintf("📞: <code>%s</code>\n", number)
+			}
+		}
 
 	} else {
 
-		if v.Info.IsFromMe {
+		if showSender && v.Info.IsFromMe {
 			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else {
+		} else if showSender {
 			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
 		}
+		if cfg.WhatsApp.ShowSenderNumberInGroups && v.Info.IsGroup && !v.Info.IsFromMe {
+			if number := utils.WaGetSenderPhoneNumber(v.Info.MessageSource); number != "" {
+				bridgedText += fmt.Sprintf("📞: <code>%s</code>\n", number)
+			}
+		}
 		if v.Info.IsIncomingBroadcast() {
 			bridgedText += "👥: <b>(Broadcast)</b>\n"
 		} else if v.Info.IsGroup {
@@ -1389,7 +1862,7 @@ func UndecryptableMessageEventHandler(v *events.UndecryptableMessage) {
 
 	}
 
-	if time.Since(v.Info.Timestamp).Seconds() > 60 {
+	if cfg.WhatsApp.AlwaysShowTimestamp || time.Since(v.Info.Timestamp).Seconds() > 60 {
 		bridgedText += fmt.Sprintf("🕛: <b>%s</b>\n",
 			html.EscapeString(v.Info.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
 	}
@@ -1439,21 +1912,27 @@ func UndecryptableMessageEventHandler(v *events.UndecryptableMessage) {
 		}
 	}
 
-	sentMsg, err := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
+	// Same as for other messages, the chat must be named when it has no topic
+	if cfg.WhatsApp.SkipChatDetails && !v.Info.IsIncomingBroadcast() && utils.TgIsGeneralThread(threadId) {
+		if v.Info.IsGroup {
+			bridgedText = fmt.Sprintf("👥: <b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat))) + bridgedText
+		} else {
+			bridgedText = fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.Chat.ToNonAD()))) + bridgedText
+		}
+	}
+
+	_, err = queue.TgSendBridgedMessage(tgBot, cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
 		MessageThreadId: threadId,
-	})
-	if err != nil {
+	}, queue.WaOrigin{MsgId: msgId, ParticipantId: v.Info.MessageSource.Sender.String(), ChatId: v.Info.Chat.String()})
+	if err != nil && !errors.Is(err, queue.ErrTelegramUnreachable) {
+		utils.TgReportError(utils.ErrorCategorySend, "Failed to send a message to Telegram", err)
 		panic(fmt.Errorf("failed to send telegram message: %s", err))
 	}
-	if sentMsg.MessageId != 0 {
-		database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-			cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-	}
 }
 
 func CallOfferEventHandler(v *events.CallOffer) {
 	var (
-		cfg   = state.State.Config
+		cfg   = state.State.Config()
 		tgBot = state.State.TelegramBot
 	)
 
@@ -1491,11 +1970,102 @@ func PushNameEventHandler(v *events.PushName) {
 	)
 
 	database.ContactUpdatePushName(v.JID.User, v.JID.Server, v.NewPushName)
+	scheduleTopicRename(v.JID)
+}
+
+// ContactEventHandler stores the new name of a contact saved or renamed in
+// your address book and renames the topic of their chat.
+func ContactEventHandler(v *events.Contact) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	if v.FromFullSync || v.Action == nil {
+		return
+	}
+
+	logger.Debug("new contact update",
+		zap.String("jid", v.JID.String()),
+		zap.String("full_name", v.Action.GetFullName()),
+	)
+
+	database.ContactUpdateFullName(v.JID.User, v.JID.Server, v.Action.GetFullName())
+	scheduleTopicRename(v.JID)
+}
+
+// ClearChatEventHandler mirrors a chat being cleared on WhatsApp either by
+// deleting the bridged messages in its topic or by posting a notice there.
+func ClearChatEventHandler(v *events.ClearChat) {
+	var (
+		cfg      = state.State.Config()
+		logger   = state.State.Logger
+		tgBot    = state.State.TelegramBot
+		waClient = state.State.WhatsAppClient
+	)
+	defer logger.Sync()
+
+	// Clears replayed during a full app state sync already happened in the past
+	if v.FromFullSync {
+		return
+	}
+
+	chatJID := v.JID.ToNonAD()
+	if chatJID.Server == waTypes.HiddenUserServer {
+		pn, err := waClient.Store.LIDs.GetPNForLID(context.Background(), chatJID)
+		if err == nil && !pn.IsEmpty() {
+			chatJID = pn
+		}
+	}
+
+	tgThreadId, threadFound, err := database.ChatThreadGetTgFromWa(chatJID.String(), cfg.Telegram.TargetChatID)
+	if err != nil || !threadFound || tgThreadId == 0 {
+		logger.Debug("no thread found for a cleared WhatsApp chat",
+			zap.String("chat", v.JID.String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if cfg.WhatsApp.ChatClearAction == "delete" {
+		// Bots can only delete messages that are less than 48 hours old
+		tgMsgIds, err := database.MsgIdGetTgMsgIdsByThreadIdSince(cfg.Telegram.TargetChatID, tgThreadId,
+			time.Now().UTC().Add(-48*time.Hour))
+		if err != nil {
+			logger.Error("failed to get bridged messages of a cleared WhatsApp chat",
+				zap.String("chat", v.JID.String()),
+				zap.Error(err),
+			)
+			return
+		}
+
+		// deleteMessages accepts at most 100 messages per call
+		for start := 0; start < len(tgMsgIds); start += 100 {
+			end := min(start+100, len(tgMsgIds))
+			if _, err := queue.TgDeleteMessages(tgBot, cfg.Telegram.TargetChatID, tgMsgIds[start:end], nil); err != nil {
+				logger.Warn("failed to delete bridged messages of a cleared WhatsApp chat",
+					zap.String("chat", v.JID.String()),
+					zap.Error(err),
+				)
+			}
+		}
+
+		if _, err := database.MsgIdDeletePairsByThreadId(cfg.Telegram.TargetChatID, tgThreadId); err != nil {
+			logger.Error("failed to delete message pairs of a cleared WhatsApp chat",
+				zap.String("chat", v.JID.String()),
+				zap.Error(err),
+			)
+		}
+		return
+	}
+
+	queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, fmt.Sprintf("<i>The chat was cleared on WhatsApp at %s</i>",
+		html.EscapeString(v.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat))), &gotgbot.SendMessageOpts{
+		MessageThreadId: tgThreadId,
+	})
 }
 
 func UserAboutEventHandler(v *events.UserAbout) {
 	var (
-		cfg      = state.State.Config
+		cfg      = state.State.Config()
 		logger   = state.State.Logger
 		tgBot    = state.State.TelegramBot
 		waClient = state.State.WhatsAppClient
@@ -1576,7 +2146,7 @@ func UserAboutEventHandler(v *events.UserAbout) {
 
 func RevokedMessageEventHandler(v *events.Message) {
 	var (
-		cfg         = state.State.Config
+		cfg         = state.State.Config()
 		tgBot       = state.State.TelegramBot
 		protocolMsg = v.Message.GetProtocolMessage()
 		waMsgId     = protocolMsg.GetKey().GetID()
@@ -1601,10 +2171,9 @@ func RevokedMessageEventHandler(v *events.Message) {
 		return
 	}
 
-	queue.TgSendMessage(tgBot, tgChatId, fmt.Sprintf(
-		"<i>This message was revoked by %s</i>",
-		html.EscapeString(deleterName),
-	), &gotgbot.SendMessageOpts{
+	revokedText := strings.ReplaceAll(cfg.WhatsApp.Placeholders.Revoked, "{name}", deleterName)
+
+	queue.TgSendMessage(tgBot, tgChatId, "<i>"+html.EscapeString(revokedText)+"</i>", &gotgbot.SendMessageOpts{
 		MessageThreadId: tgThreadId,
 		ReplyParameters: &gotgbot.ReplyParameters{
 			MessageId: tgMsgId,
@@ -1612,9 +2181,74 @@ func RevokedMessageEventHandler(v *events.Message) {
 	})
 }
 
+func PinInChatEventHandler(v *events.Message) {
+	var (
+		cfg     = state.State.Config()
+		logger  = state.State.Logger
+		tgBot   = state.State.TelegramBot
+		pinMsg  = v.Message.GetPinInChatMessage()
+		waMsgId = pinMsg.GetKey().GetID()
+		pinned  = pinMsg.GetType() == waE2E.PinInChatMessage_PIN_FOR_ALL
+	)
+	defer logger.Sync()
+
+	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(waMsgId, v.Info.Chat.String())
+	if err != nil || tgChatId == 0 || tgMsgId == 0 {
+		logger.Debug("no bridged message found for a WhatsApp pin",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if cfg.WhatsApp.PinMessageAction == "pin" {
+		if pinned {
+			_, err = queue.TgPinChatMessage(tgBot, tgChatId, tgMsgId, &gotgbot.PinChatMessageOpts{DisableNotification: true})
+		} else {
+			_, err = queue.TgUnpinChatMessage(tgBot, tgChatId, &gotgbot.UnpinChatMessageOpts{MessageId: &tgMsgId})
+		}
+		if utils.TgIsMessageNotFound(err) {
+			logger.Debug("the pinned message was deleted on Telegram, forgetting it",
+				zap.String("event_id", v.Info.ID),
+				zap.Int64("tg_msg_id", tgMsgId),
+			)
+			database.MsgIdDeletePair(tgChatId, tgMsgId)
+		} else if err != nil {
+			logger.Warn("failed to mirror a WhatsApp pin on Telegram",
+				zap.String("event_id", v.Info.ID),
+				zap.Bool("pinned", pinned),
+				zap.Error(err),
+			)
+		}
+		return
+	}
+
+	var pinnerName string
+	if v.Info.IsFromMe {
+		pinnerName = "You"
+	} else {
+		pinnerName = utils.WaGetContactName(v.Info.MessageSource.Sender)
+	}
+
+	action := "pinned"
+	if !pinned {
+		action = "unpinned"
+	}
+
+	queue.TgSendMessage(tgBot, tgChatId, fmt.Sprintf("📌 <i>%s %s a message</i>",
+		html.EscapeString(pinnerName), action), &gotgbot.SendMessageOpts{
+		MessageThreadId: tgThreadId,
+		ReplyParameters: &gotgbot.ReplyParameters{
+			MessageId:                tgMsgId,
+			AllowSendingWithoutReply: true,
+		},
+	})
+}
+
 func PictureEventHandler(v *events.Picture) {
 	var (
-		cfg      = state.State.Config
+		cfg      = state.State.Config()
 		logger   = state.State.Logger
 		tgBot    = state.State.TelegramBot
 		waClient = state.State.WhatsAppClient
@@ -1678,6 +2312,7 @@ func PictureEventHandler(v *events.Picture) {
 				queue.TgUnpinChatMessage(tgBot, cfg.Telegram.TargetChatID, &gotgbot.UnpinChatMessageOpts{MessageId: &prevPinId})
 				database.ChatThreadSetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID, 0)
 			}
+			utils.ForgetSentWaProfilePic(waChatIdString)
 			updateText := fmt.Sprintf("The profile picture was removed by %s", html.EscapeString(changer))
 			err = utils.TgSendTextById(
 				tgBot, cfg.Telegram.TargetChatID, tgThreadId,
@@ -1688,47 +2323,8 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 		} else {
-			pictureInfo, err := waClient.GetProfilePictureInfo(
-				context.Background(),
-				v.JID,
-				&whatsmeow.GetProfilePictureParams{
-					Preview: false,
-				},
-			)
-			if err != nil {
-				logger.Error("failed to get profile picture info", zap.Error(err), zap.String("group", v.JID.String()))
-				return
-			}
-			if pictureInfo == nil {
-				logger.Error("failed to get profile picture info, received null", zap.String("group", v.JID.String()))
-				return
-			}
-
-			newPictureBytes, err := utils.DownloadFileBytesByURL(pictureInfo.URL)
-			if err != nil {
-				logger.Error("failed to download profile picture", zap.Error(err), zap.String("group", v.JID.String()))
-				return
-			}
-
-			// Unpin previous profile picture before sending the new one
-			if prevPinId, _ := database.ChatThreadGetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID); prevPinId != 0 {
-				queue.TgUnpinChatMessage(tgBot, cfg.Telegram.TargetChatID, &gotgbot.UnpinChatMessageOpts{MessageId: &prevPinId})
-				database.ChatThreadSetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID, 0)
-			}
-
-			sentMsg, err := queue.TgSendPhoto(tgBot, cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
-				MessageThreadId: tgThreadId,
-				Caption:         fmt.Sprintf("The profile picture was updated by %s", html.EscapeString(changer)),
-			})
-			if err != nil {
-				logger.Error("failed to send message to the group", zap.Error(err))
-				return
-			}
-			if _, errPin := queue.TgPinChatMessage(tgBot, cfg.Telegram.TargetChatID, sentMsg.MessageId, &gotgbot.PinChatMessageOpts{DisableNotification: true}); errPin != nil {
-				logger.Warn("failed to pin new profile picture", zap.Error(errPin))
-			} else {
-				database.ChatThreadSetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID, sentMsg.MessageId)
-			}
+			utils.SendWaProfilePicToTopic(v.JID, waChatIdString, cfg.Telegram.TargetChatID, tgThreadId,
+				fmt.Sprintf("The profile picture was updated by %s", html.EscapeString(changer)))
 		}
 	} else if v.JID.Server == waTypes.DefaultUserServer {
 		tgThreadId, err = utils.TgGetOrMakeThreadFromWa(v.JID.ToNonAD(), cfg.Telegram.TargetChatID, utils.WaGetContactName(v.JID.ToNonAD()))
@@ -1746,6 +2342,7 @@ func PictureEventHandler(v *events.Picture) {
 				queue.TgUnpinChatMessage(tgBot, cfg.Telegram.TargetChatID, &gotgbot.UnpinChatMessageOpts{MessageId: &prevPinId})
 				database.ChatThreadSetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID, 0)
 			}
+			utils.ForgetSentWaProfilePic(waChatIdString)
 			updateText := "The profile picture was removed"
 			err = utils.TgSendTextById(
 				tgBot, cfg.Telegram.TargetChatID, tgThreadId,
@@ -1756,47 +2353,8 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 		} else {
-			pictureInfo, err := waClient.GetProfilePictureInfo(
-				context.Background(),
-				v.JID,
-				&whatsmeow.GetProfilePictureParams{
-					Preview: false,
-				},
-			)
-			if err != nil {
-				logger.Error("failed to get profile picture info", zap.Error(err), zap.String("group", v.JID.String()))
-				return
-			}
-			if pictureInfo == nil {
-				logger.Error("failed to get profile picture info, received null", zap.String("group", v.JID.String()))
-				return
-			}
-
-			newPictureBytes, err := utils.DownloadFileBytesByURL(pictureInfo.URL)
-			if err != nil {
-				logger.Error("failed to download profile picture", zap.Error(err), zap.String("group", v.JID.String()))
-				return
-			}
-
-			// Unpin previous profile picture before sending the new one
-			if prevPinId, _ := database.ChatThreadGetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID); prevPinId != 0 {
-				queue.TgUnpinChatMessage(tgBot, cfg.Telegram.TargetChatID, &gotgbot.UnpinChatMessageOpts{MessageId: &prevPinId})
-				database.ChatThreadSetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID, 0)
-			}
-
-			sentMsg, err := queue.TgSendPhoto(tgBot, cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
-				MessageThreadId: tgThreadId,
-				Caption:         "The profile picture was updated",
-			})
-			if err != nil {
-				logger.Error("failed to send message to the group", zap.Error(err))
-				return
-			}
-			if _, errPin := queue.TgPinChatMessage(tgBot, cfg.Telegram.TargetChatID, sentMsg.MessageId, &gotgbot.PinChatMessageOpts{DisableNotification: true}); errPin != nil {
-				logger.Warn("failed to pin new profile picture", zap.Error(errPin))
-			} else {
-				database.ChatThreadSetPinnedMsgId(waChatIdString, cfg.Telegram.TargetChatID, sentMsg.MessageId)
-			}
+			utils.SendWaProfilePicToTopic(v.JID, waChatIdString, cfg.Telegram.TargetChatID, tgThreadId,
+				"The profile picture was updated")
 		}
 	} else {
 		logger.Warn(
@@ -1806,9 +2364,88 @@ func PictureEventHandler(v *events.Picture) {
 	}
 }
 
+// JoinedGroupEventHandler creates the topic of a group you were added to or
+// created, so that it shows up before anyone writes in it.
+func JoinedGroupEventHandler(v *events.JoinedGroup) {
+	var (
+		cfg    = state.State.Config()
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	groupJID := v.JID.ToNonAD()
+	createdByYou := v.CreateKey != ""
+
+	if slices.Contains(cfg.WhatsApp.IgnoreChats, groupJID.User) {
+		logger.Debug("not creating a topic for a joined group because it is ignored",
+			zap.String("chat", groupJID.String()),
+		)
+		return
+	}
+	if v.Sender != nil && !createdByYou {
+		source := waTypes.MessageSource{Sender: *v.Sender}
+		if v.SenderPN != nil {
+			source.SenderAlt = *v.SenderPN
+		}
+		if !senderIsAllowed(source) {
+			logger.Debug("not creating a topic for a group you were added to by a sender not in allowed_senders",
+				zap.String("chat", groupJID.String()),
+				zap.String("sender_jid", v.Sender.String()),
+			)
+			return
+		}
+	}
+	// Groups others add you to have to earn their topic like any other new chat
+	if cfg.WhatsApp.NewChatMinMessages > 1 && !createdByYou {
+		logger.Debug("not creating a topic for a joined group until it reaches new_chat_min_messages",
+			zap.String("chat", groupJID.String()),
+		)
+		return
+	}
+
+	groupName := v.GroupName.Name
+	if groupName == "" {
+		groupName = utils.WaGetGroupName(groupJID)
+	}
+
+	tgThreadId, err := utils.TgGetOrMakeThreadFromWa(groupJID, cfg.Telegram.TargetChatID, groupName)
+	if err != nil {
+		logger.Warn("failed to create a new thread for a joined WhatsApp group",
+			zap.String("chat", groupJID.String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	var noticeText string
+	switch {
+	case createdByYou:
+		noticeText = "You created this group"
+	case v.Sender != nil:
+		noticeText = fmt.Sprintf("You were added to this group by %s", html.EscapeString(utils.WaGetContactName(v.Sender.ToNonAD())))
+	case v.Reason == "invite":
+		noticeText = "You joined this group using an invite link"
+	default:
+		noticeText = "You joined this group"
+	}
+	if cfg.WhatsApp.JoinedGroupDetails {
+		noticeText += fmt.Sprintf("\n\n👥: <b>%s</b>\n👤: %d members", html.EscapeString(groupName), len(v.Participants))
+		if v.Topic != "" {
+			noticeText += fmt.Sprintf("\n\n<blockquote>%s</blockquote>", html.EscapeString(v.Topic))
+		}
+	} else if utils.TgIsGeneralThread(tgThreadId) {
+		noticeText += fmt.Sprintf(": <b>%s</b>", html.EscapeString(groupName))
+	}
+
+	if err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, noticeText); err != nil {
+		logger.Error("failed to send message to the target chat", zap.Error(err))
+	}
+}
+
 func GroupInfoEventHandler(v *events.GroupInfo) {
 	var (
-		cfg      = state.State.Config
+		cfg      = state.State.Config()
 		logger   = state.State.Logger
 		tgBot    = state.State.TelegramBot
 		waClient = state.State.WhatsAppClient
@@ -1877,6 +2514,43 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 		}
 	}
 
+	if v.Topic != nil && (cfg.WhatsApp.GroupDescriptionAction == "notice" || cfg.WhatsApp.GroupDescriptionAction == "pin") {
+		var authorInfo string
+		if !v.Topic.TopicSetBy.IsEmpty() {
+			authorName := utils.WaGetContactName(v.Topic.TopicSetBy)
+			authorInfo = fmt.Sprintf(" by %s", html.EscapeString(authorName))
+		}
+
+		var updateText string
+		if v.Topic.TopicDeleted || v.Topic.Topic == "" {
+			updateText = fmt.Sprintf("The group description has been removed%s", authorInfo)
+		} else {
+			updateText = fmt.Sprintf("The group description has been changed%s:\n\n<blockquote>%s</blockquote>",
+				authorInfo, html.EscapeString(v.Topic.Topic))
+		}
+
+		sentMsg, err := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, updateText, &gotgbot.SendMessageOpts{
+			MessageThreadId: tgThreadId,
+		})
+		if err != nil {
+			logger.Error("failed to send message", zap.Error(err))
+		} else if cfg.WhatsApp.GroupDescriptionAction == "pin" {
+			// Keep only the latest description pinned
+			waChatIdString := v.JID.ToNonAD().String()
+			if prevPinId, _ := database.ChatThreadGetDescriptionMsgId(waChatIdString, cfg.Telegram.TargetChatID); prevPinId != 0 {
+				queue.TgUnpinChatMessage(tgBot, cfg.Telegram.TargetChatID, &gotgbot.UnpinChatMessageOpts{MessageId: &prevPinId})
+				database.ChatThreadSetDescriptionMsgId(waChatIdString, cfg.Telegram.TargetChatID, 0)
+			}
+			if !v.Topic.TopicDeleted && v.Topic.Topic != "" {
+				if _, err := queue.TgPinChatMessage(tgBot, cfg.Telegram.TargetChatID, sentMsg.MessageId, &gotgbot.PinChatMessageOpts{DisableNotification: true}); err != nil {
+					logger.Warn("failed to pin the group description", zap.Error(err))
+				} else {
+					database.ChatThreadSetDescriptionMsgId(waChatIdString, cfg.Telegram.TargetChatID, sentMsg.MessageId)
+				}
+			}
+		}
+	}
+
 	if v.Ephemeral != nil {
 		var authorInfo string
 		if v.Sender != nil {
@@ -2075,7 +2749,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 			tgBot,
 			cfg.Telegram.TargetChatID, tgThreadId,
 			&gotgbot.EditForumTopicOpts{
-				Name: v.Name.Name,
+				Name: utils.TgFormatTopicName(v.JID.ToNonAD().String(), v.Name.Name),
 			},
 		)
 		if err != nil {
@@ -2102,7 +2776,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 
 func LogoutHandler(v *events.LoggedOut) {
 	var (
-		cfg    = state.State.Config
+		cfg    = state.State.Config()
 		logger = state.State.Logger
 		tgBot  = state.State.TelegramBot
 	)
@@ -2111,5 +2785,104 @@ func LogoutHandler(v *events.LoggedOut) {
 	updateText := "You have been logged out from WhatsApp:\n\n"
 	updateText += fmt.Sprintf("<b>Reason:</b> %s", html.EscapeString(v.Reason.String()))
 
+	if cfg.Telegram.ConnectionNotifications {
+		notifyAdmins(updateText)
+		return
+	}
 	utils.TgSendTextById(tgBot, cfg.Telegram.OwnerID, 0, updateText)
 }
+
+// waDisconnected is set once the connection drops, so that only reconnections
+// are announced and not the first connection after starting.
+var waDisconnected atomic.Bool
+
+func ConnectionStateEventHandler(evt interface{}) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var (
+		updateText  string
+		reportText  string
+		reportError error
+	)
+	switch v := evt.(type) {
+	case *events.Connected:
+		if !waDisconnected.Swap(false) {
+			return
+		}
+		updateText = "Reconnected to WhatsApp"
+		reportText = updateText
+	case *events.Disconnected:
+		if waDisconnected.Swap(true) {
+			return
+		}
+		updateText = "Disconnected from WhatsApp, trying to reconnect:\n\n<b>Reason:</b> network"
+		reportText = "Disconnected from WhatsApp, trying to reconnect"
+	case *events.StreamReplaced:
+		waDisconnected.Store(true)
+		updateText = "Disconnected from WhatsApp:\n\n<b>Reason:</b> the session was opened somewhere else"
+		reportText = "Disconnected from WhatsApp, the session was opened somewhere else"
+	case *events.ConnectFailure:
+		waDisconnected.Store(true)
+		updateText = "Failed to connect to WhatsApp:\n\n"
+		updateText += fmt.Sprintf("<b>Reason:</b> %s", html.EscapeString(v.Reason.String()))
+		if v.Message != "" {
+			updateText += fmt.Sprintf(" (%s)", html.EscapeString(v.Message))
+		}
+		reportText = "Failed to connect to WhatsApp"
+		reportError = fmt.Errorf("%s %s", v.Reason.String(), v.Message)
+	default:
+		return
+	}
+
+	logger.Info("WhatsApp connection state changed",
+		zap.String("type", fmt.Sprintf("%T", evt)),
+	)
+	utils.TgReportError(utils.ErrorCategoryConnection, reportText, reportError)
+	if state.State.Config().Telegram.ConnectionNotifications {
+		notifyAdmins(updateText)
+	}
+}
+
+// TemporaryBanEventHandler pauses sending to WhatsApp for the duration of the
+// ban, and always notifies the admins as the account may need attention.
+func TemporaryBanEventHandler(v *events.TemporaryBan) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	waDisconnected.Store(true)
+	queue.WaPause(v.Expire)
+
+	logger.Warn("WhatsApp account is temporarily banned",
+		zap.Int("code", int(v.Code)),
+		zap.Duration("expire", v.Expire),
+	)
+
+	updateText := "Your WhatsApp account is temporarily banned, sending is paused until the ban expires:\n\n"
+	updateText += fmt.Sprintf("<b>Reason:</b> %s", html.EscapeString(v.String()))
+	utils.TgReportError(utils.ErrorCategoryBan, "WhatsApp account is temporarily banned", fmt.Errorf("%s", v.String()))
+	notifyAdmins(updateText)
+}
+
+// notifyAdmins sends the text to the owner and sudo users in private.
+func notifyAdmins(text string) {
+	var (
+		cfg   = state.State.Config()
+		tgBot = state.State.TelegramBot
+	)
+
+	adminIds := append([]int64{cfg.Telegram.OwnerID}, cfg.Telegram.SudoUsersID...)
+	notified := make(map[int64]bool, len(adminIds))
+	for _, adminId := range adminIds {
+		if adminId == 0 || notified[adminId] {
+			continue
+		}
+		notified[adminId] = true
+		if _, err := queue.TgSendMessage(tgBot, adminId, text, nil); err != nil {
+			state.State.Logger.Warn("failed to notify an admin about the WhatsApp connection",
+				zap.Int64("admin_id", adminId),
+				zap.Error(err),
+			)
+		}
+	}
+}
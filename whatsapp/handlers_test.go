@@ -0,0 +1,117 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"watgbridge/database"
+	"watgbridge/internal/testutil"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestWaQuotedSenderNameFromMe(t *testing.T) {
+	ownJID := waTypes.NewADJID("911234567890", 0, 12)
+	state.State.WhatsAppClient = &whatsmeow.Client{Store: &store.Device{
+		ID:  &ownJID,
+		LID: waTypes.NewJID("123456789012345", waTypes.HiddenUserServer),
+	}}
+
+	tests := []struct {
+		name        string
+		participant string
+	}{
+		{"primary device", "911234567890@s.whatsapp.net"},
+		{"linked device", "911234567890:12@s.whatsapp.net"},
+		{"lid", "123456789012345@lid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := waQuotedSenderName(tt.participant); got != "You" {
+				t.Errorf("waQuotedSenderName(%q) = %q, want %q", tt.participant, got, "You")
+			}
+		})
+	}
+
+	if got := waQuotedSenderName(""); got != "Unknown" {
+		t.Errorf("waQuotedSenderName(\"\") = %q, want %q", got, "Unknown")
+	}
+}
+
+func TestWaResolveQuote(t *testing.T) {
+	const tgChatId = int64(-1001234567890)
+	testutil.Setup(t, func(cfg *state.Config) {
+		cfg.Telegram.TargetChatID = tgChatId
+	})
+
+	groupChat := waTypes.NewJID("120363012345678901", waTypes.GroupServer)
+	privateChat := waTypes.NewJID("919876543210", waTypes.DefaultUserServer)
+
+	// A message bridged from the group to its topic, and one from the private
+	// chat to its own topic
+	const groupMsgId, privateMsgId = "3EB0C767D71D7A5C2E4F", "3EB0A1B2C3D4E5F60718"
+	if err := database.MsgIdAddNewPair(groupMsgId, privateChat.String(), groupChat.String(),
+		tgChatId, 42, 7, ""); err != nil {
+		t.Fatalf("failed to add the pair: %v", err)
+	}
+	if err := database.MsgIdAddNewPair(privateMsgId, privateChat.String(), privateChat.String(),
+		tgChatId, 43, 8, ""); err != nil {
+		t.Fatalf("failed to add the pair: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		contextInfo *waE2E.ContextInfo
+		wantFound   bool
+		wantThread  int64
+		wantMsg     int64
+	}{
+		{
+			name: "same chat",
+			contextInfo: &waE2E.ContextInfo{
+				StanzaID:  proto.String(privateMsgId),
+				RemoteJID: proto.String(privateChat.String()),
+			},
+			wantFound:  true,
+			wantThread: 8,
+			wantMsg:    43,
+		},
+		{
+			name:        "same chat without remote jid",
+			contextInfo: &waE2E.ContextInfo{StanzaID: proto.String(privateMsgId)},
+			wantFound:   true,
+			wantThread:  8,
+			wantMsg:     43,
+		},
+		{
+			// Replied to in private, so it must stay in the private topic
+			// instead of going to the group's
+			name: "group message quoted in private",
+			contextInfo: &waE2E.ContextInfo{
+				StanzaID:    proto.String(groupMsgId),
+				Participant: proto.String(privateChat.String()),
+				RemoteJID:   proto.String(groupChat.String()),
+			},
+		},
+		{
+			name: "chat that isn't bridged",
+			contextInfo: &waE2E.ContextInfo{
+				StanzaID:  proto.String("3EB0FFFFFFFFFFFFFFFF"),
+				RemoteJID: proto.String("120363099999999999@g.us"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threadId, msgId, found := waResolveQuote(privateChat, tt.contextInfo)
+			if found != tt.wantFound || threadId != tt.wantThread || msgId != tt.wantMsg {
+				t.Errorf("waResolveQuote() = %d, %d, %v, want %d, %d, %v",
+					threadId, msgId, found, tt.wantThread, tt.wantMsg, tt.wantFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"watgbridge/queue"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// While in maintenance mode (/maintenance on) WhatsApp events are kept in
+// memory instead of being handled, and the WhatsApp send queue stops draining.
+// The Telegram queue keeps running so that commands are still answered. The
+// events are handled in order once maintenance mode is turned off, and until
+// they are all done maintenance mode is draining: new events are still
+// buffered, and it can't be turned on or off again.
+
+const maintenanceBufferSize = 5000
+
+var (
+	ErrMaintenanceOn       = errors.New("maintenance mode is already on")
+	ErrMaintenanceOff      = errors.New("maintenance mode is not on")
+	ErrMaintenanceDraining = errors.New("the WhatsApp updates received during maintenance mode are still being bridged")
+)
+
+var (
+	maintenanceMu       sync.Mutex
+	maintenanceOn       bool
+	maintenanceDraining bool
+	maintenanceBuffer   []interface{}
+)
+
+// InMaintenanceMode reports whether maintenance mode is on, which includes
+// while it is draining.
+func InMaintenanceMode() bool {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	return maintenanceOn
+}
+
+// MaintenanceDraining reports whether maintenance mode was turned off but the
+// events received in the meantime are still being handled.
+func MaintenanceDraining() bool {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	return maintenanceDraining
+}
+
+// EnterMaintenanceMode stops bridging until LeaveMaintenanceMode is called.
+func EnterMaintenanceMode() error {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	if maintenanceDraining {
+		return ErrMaintenanceDraining
+	}
+	if maintenanceOn {
+		return ErrMaintenanceOn
+	}
+	maintenanceOn = true
+	queue.PauseWaWorker()
+	return nil
+}
+
+// LeaveMaintenanceMode resumes the WhatsApp send queue and handles the events received
+// in the meantime in the background. It returns the number of those events.
+func LeaveMaintenanceMode() (int, error) {
+	maintenanceMu.Lock()
+	if maintenanceDraining {
+		maintenanceMu.Unlock()
+		return 0, ErrMaintenanceDraining
+	}
+	if !maintenanceOn {
+		maintenanceMu.Unlock()
+		return 0, ErrMaintenanceOff
+	}
+	maintenanceDraining = true
+	buffered := len(maintenanceBuffer)
+	maintenanceMu.Unlock()
+
+	queue.ResumeWaWorker()
+
+	go func() {
+		for {
+			maintenanceMu.Lock()
+			evts := maintenanceBuffer
+			maintenanceBuffer = nil
+			if len(evts) == 0 {
+				// New events are only handled directly once the older ones are done
+				maintenanceOn = false
+				maintenanceDraining = false
+				maintenanceMu.Unlock()
+				return
+			}
+			maintenanceMu.Unlock()
+
+			for _, evt := range evts {
+				handleRecovering(evt, func() { handleWhatsAppEvent(evt) })
+			}
+		}
+	}()
+
+	return buffered, nil
+}
+
+// bufferDuringMaintenance keeps the event for later if maintenance mode is on.
+// Events that only update local state are still handled right away.
+func bufferDuringMaintenance(evt interface{}) bool {
+	switch evt.(type) {
+	case *events.Receipt, *events.PushName, *events.Contact:
+		return false
+	}
+
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	if !maintenanceOn {
+		return false
+	}
+
+	if len(maintenanceBuffer) >= maintenanceBufferSize {
+		state.State.Logger.Warn("maintenance buffer is full, dropping WhatsApp event",
+			zap.String("type", fmt.Sprintf("%T", evt)),
+		)
+		return true
+	}
+	maintenanceBuffer = append(maintenanceBuffer, evt)
+	return true
+}
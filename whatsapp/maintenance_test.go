@@ -0,0 +1,48 @@
+package whatsapp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceModeTransitions(t *testing.T) {
+	if _, err := LeaveMaintenanceMode(); !errors.Is(err, ErrMaintenanceOff) {
+		t.Fatalf("LeaveMaintenanceMode() while off = %v, want %v", err, ErrMaintenanceOff)
+	}
+	if err := EnterMaintenanceMode(); err != nil {
+		t.Fatalf("EnterMaintenanceMode() = %v", err)
+	}
+	if err := EnterMaintenanceMode(); !errors.Is(err, ErrMaintenanceOn) {
+		t.Fatalf("EnterMaintenanceMode() while on = %v, want %v", err, ErrMaintenanceOn)
+	}
+
+	// Pretend the buffered events are still being handled
+	maintenanceMu.Lock()
+	maintenanceDraining = true
+	maintenanceMu.Unlock()
+
+	if err := EnterMaintenanceMode(); !errors.Is(err, ErrMaintenanceDraining) {
+		t.Errorf("EnterMaintenanceMode() while draining = %v, want %v", err, ErrMaintenanceDraining)
+	}
+	if _, err := LeaveMaintenanceMode(); !errors.Is(err, ErrMaintenanceDraining) {
+		t.Errorf("LeaveMaintenanceMode() while draining = %v, want %v", err, ErrMaintenanceDraining)
+	}
+
+	maintenanceMu.Lock()
+	maintenanceDraining = false
+	maintenanceMu.Unlock()
+
+	if buffered, err := LeaveMaintenanceMode(); err != nil || buffered != 0 {
+		t.Fatalf("LeaveMaintenanceMode() = %d, %v, want 0, nil", buffered, err)
+	}
+	for deadline := time.Now().Add(time.Second); InMaintenanceMode(); {
+		if time.Now().After(deadline) {
+			t.Fatal("maintenance mode is still on after draining")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if MaintenanceDraining() {
+		t.Error("maintenance mode is still draining after it was turned off")
+	}
+}
@@ -0,0 +1,198 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/queue"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Media that expired on WhatsApp's servers is bridged as a placeholder. When
+// request_media_reupload is set, the sender's phone is also asked to upload it
+// again, and if it does within mediaRetryTimeout the message is bridged again
+// with the new upload, replacing the placeholder.
+
+const mediaRetryTimeout = 5 * time.Minute
+
+type pendingMediaRetry struct {
+	evt      *events.Message
+	mediaKey []byte
+	timer    *time.Timer
+}
+
+var (
+	mediaRetriesMu     sync.Mutex
+	mediaRetries       = make(map[string]*pendingMediaRetry) // Message ID -> request
+	mediaRetryRebridge = make(map[*events.Message]bool)      // Messages being bridged again
+)
+
+// waDownloadableMedia returns the media of the message that can be
+// downloaded, or nil if it has none.
+func waDownloadableMedia(msg *waE2E.Message) whatsmeow.DownloadableMessage {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage()
+	case msg.GetPtvMessage() != nil:
+		return msg.GetPtvMessage()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage()
+	}
+	return nil
+}
+
+// mediaDownloadFailed reports a failed media download and returns the
+// placeholder to bridge instead, which keeps the caption of the media.
+func mediaDownloadFailed(v *events.Message, kind, caption string, err error) string {
+	utils.TgReportError(utils.ErrorCategoryDownload, fmt.Sprintf("Failed to download %s from WhatsApp", kind), err)
+
+	text := fmt.Sprintf("\n<i>Couldn't download the %s due to some errors</i>", kind)
+	if requestMediaReupload(v, err) {
+		text = fmt.Sprintf("\n<i>The %s is no longer available on WhatsApp's servers, asked the sender's phone to upload it again</i>", kind)
+	}
+	if caption != "" {
+		text += "\n\n" + html.EscapeString(caption)
+	}
+	return text
+}
+
+// requestMediaReupload asks the sender's phone to upload expired media again,
+// and reports whether it did.
+func requestMediaReupload(v *events.Message, err error) bool {
+	if !state.State.Config().WhatsApp.RequestMediaReupload || !utils.WaMediaExpired(err) {
+		return false
+	}
+
+	media := waDownloadableMedia(v.Message)
+	if media == nil || len(media.GetMediaKey()) == 0 {
+		return false
+	}
+	mediaKey := media.GetMediaKey()
+
+	if err := state.State.WhatsAppClient.SendMediaRetryReceipt(context.Background(), &v.Info, mediaKey); err != nil {
+		state.State.Logger.Warn("failed to ask for expired media to be uploaded again",
+			zap.String("event_id", v.Info.ID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	msgId := v.Info.ID
+	mediaRetriesMu.Lock()
+	defer mediaRetriesMu.Unlock()
+	if pending, found := mediaRetries[msgId]; found {
+		pending.timer.Stop()
+	}
+	mediaRetries[msgId] = &pendingMediaRetry{
+		evt:      v,
+		mediaKey: mediaKey,
+		timer: time.AfterFunc(mediaRetryTimeout, func() {
+			mediaRetriesMu.Lock()
+			delete(mediaRetries, msgId)
+			mediaRetriesMu.Unlock()
+		}),
+	}
+	return true
+}
+
+// isMediaRetryRebridge reports whether the message is being bridged again
+// after its media was uploaded again, so it must not be skipped as a duplicate.
+func isMediaRetryRebridge(v *events.Message) bool {
+	mediaRetriesMu.Lock()
+	defer mediaRetriesMu.Unlock()
+	return mediaRetryRebridge[v]
+}
+
+// MediaRetryEventHandler bridges a message again once the sender's phone has
+// uploaded its expired media again.
+func MediaRetryEventHandler(evt *events.MediaRetry) {
+	var (
+		cfg    = state.State.Config()
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	mediaRetriesMu.Lock()
+	pending, found := mediaRetries[evt.MessageID]
+	if found {
+		pending.timer.Stop()
+		delete(mediaRetries, evt.MessageID)
+	}
+	mediaRetriesMu.Unlock()
+	if !found {
+		return
+	}
+
+	notif, err := whatsmeow.DecryptMediaRetryNotification(evt, pending.mediaKey)
+	if err != nil {
+		logger.Warn("failed to get the media uploaded again",
+			zap.String("event_id", evt.MessageID),
+			zap.Error(err),
+		)
+		return
+	} else if notif.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS || notif.GetDirectPath() == "" {
+		logger.Warn("the sender's phone couldn't upload the media again",
+			zap.String("event_id", evt.MessageID),
+			zap.String("result", notif.GetResult().String()),
+		)
+		return
+	}
+
+	v := pending.evt
+	directPath := proto.String(notif.GetDirectPath())
+	switch media := waDownloadableMedia(v.Message).(type) {
+	case *waE2E.ImageMessage:
+		media.DirectPath = directPath
+	case *waE2E.VideoMessage:
+		media.DirectPath = directPath
+	case *waE2E.AudioMessage:
+		media.DirectPath = directPath
+	case *waE2E.DocumentMessage:
+		media.DirectPath = directPath
+	case *waE2E.StickerMessage:
+		media.DirectPath = directPath
+	default:
+		return
+	}
+
+	placeholderChatId, _, placeholderMsgId, _ := database.MsgIdGetTgFromWa(v.Info.ID, v.Info.Chat.String())
+
+	mediaRetriesMu.Lock()
+	mediaRetryRebridge[v] = true
+	mediaRetriesMu.Unlock()
+	defer func() {
+		mediaRetriesMu.Lock()
+		delete(mediaRetryRebridge, v)
+		mediaRetriesMu.Unlock()
+	}()
+
+	logger.Info("bridging a message again after its media was uploaded again",
+		zap.String("event_id", v.Info.ID),
+	)
+	MessageFromOthersEventHandler("", v, false)
+
+	// The pair now points to the new message, so the placeholder can go
+	newChatId, _, newMsgId, _ := database.MsgIdGetTgFromWa(v.Info.ID, v.Info.Chat.String())
+	if placeholderMsgId != 0 && placeholderChatId == cfg.Telegram.TargetChatID && newMsgId != placeholderMsgId && newChatId == placeholderChatId {
+		queue.TgDeleteMessages(tgBot, placeholderChatId, []int64{placeholderMsgId}, nil)
+	}
+}
@@ -0,0 +1,195 @@
+package whatsapp
+
+import (
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// Chats without a topic can be required to send new_chat_min_messages
+// messages within new_chat_window_minutes before a topic is created for them,
+// so that one-off spam doesn't leave a topic behind. Their messages are held
+// in memory until then, and are dropped or sent to a shared quarantine topic
+// if the window passes first.
+
+type newChatRoute int
+
+const (
+	newChatMakeTopic newChatRoute = iota
+	newChatHeld
+	newChatQuarantine
+)
+
+type heldMessage struct {
+	text     string
+	v        *events.Message
+	isEdited bool
+}
+
+type pendingNewChat struct {
+	messages    []heldMessage
+	timer       *time.Timer
+	released    bool // Threshold reached, the held messages are being bridged
+	quarantined bool // Window passed, the held messages are being sent to the quarantine topic
+}
+
+var (
+	pendingNewChatsMu sync.Mutex
+	pendingNewChats   = make(map[string]*pendingNewChat)
+)
+
+// chatIsDormant reports whether the chat has no topic and either its last
+// message before this one, or this message itself, is older than
+// active_chat_window_days, in which case no topic is created for it. Messages
+// of chats that already have one are still bridged.
+func chatIsDormant(v *events.Message) bool {
+	cfg := state.State.Config()
+	window := time.Duration(cfg.WhatsApp.ActiveChatWindowDays) * 24 * time.Hour
+	if window <= 0 || v.Info.Chat.Server == waTypes.BroadcastServer {
+		return false
+	}
+
+	waChatId, err := utils.WaChatIdForThread(v.Info.Chat)
+	if err != nil {
+		return false
+	}
+	_, threadFound, err := database.ChatThreadGetTgFromWa(waChatId, cfg.Telegram.TargetChatID)
+	if err != nil || threadFound {
+		return false
+	}
+	return time.Since(v.Info.Timestamp) > window || utils.WaChatIsDormant(waChatId)
+}
+
+// HistorySyncEventHandler records when the synced chats last had a message,
+// so that chats that were already dormant don't get a topic.
+func HistorySyncEventHandler(v *events.HistorySync) {
+	for _, conv := range v.Data.GetConversations() {
+		chat, err := waTypes.ParseJID(conv.GetID())
+		if err != nil || conv.GetLastMsgTimestamp() == 0 {
+			continue
+		}
+		utils.WaRecordChatActivity(chat, time.Unix(int64(conv.GetLastMsgTimestamp()), 0))
+	}
+}
+
+// routeNewChatMessage decides whether the message can be bridged right away,
+// and holds it back if its chat hasn't reached new_chat_min_messages yet.
+func routeNewChatMessage(text string, v *events.Message, isEdited bool) newChatRoute {
+	cfg := state.State.Config()
+	if cfg.WhatsApp.NewChatMinMessages <= 1 || v.Info.Chat.Server == waTypes.BroadcastServer {
+		return newChatMakeTopic
+	}
+
+	waChatId, err := utils.WaChatIdForThread(v.Info.Chat)
+	if err != nil {
+		return newChatMakeTopic
+	}
+	_, threadFound, err := database.ChatThreadGetTgFromWa(waChatId, cfg.Telegram.TargetChatID)
+	if err != nil || threadFound {
+		return newChatMakeTopic
+	}
+
+	pendingNewChatsMu.Lock()
+
+	pending, found := pendingNewChats[waChatId]
+	if found && pending.released {
+		pendingNewChatsMu.Unlock()
+		return newChatMakeTopic
+	} else if found && pending.quarantined {
+		pendingNewChatsMu.Unlock()
+		return newChatQuarantine
+	}
+
+	// Your own messages mean the chat isn't spam
+	if v.Info.IsFromMe {
+		pendingNewChatsMu.Unlock()
+		if found {
+			releasePendingNewChat(waChatId)
+		}
+		return newChatMakeTopic
+	}
+
+	if !found {
+		pending = &pendingNewChat{}
+		pendingNewChats[waChatId] = pending
+		if window := cfg.WhatsApp.NewChatWindowMinutes; window > 0 {
+			pending.timer = time.AfterFunc(time.Duration(window)*time.Minute, func() { expirePendingNewChat(waChatId) })
+		}
+	}
+
+	if len(pending.messages)+1 < cfg.WhatsApp.NewChatMinMessages {
+		pending.messages = append(pending.messages, heldMessage{text, v, isEdited})
+		pendingNewChatsMu.Unlock()
+		return newChatHeld
+	}
+
+	pendingNewChatsMu.Unlock()
+	releasePendingNewChat(waChatId)
+	return newChatMakeTopic
+}
+
+// releasePendingNewChat bridges the held messages of the chat, in the order
+// they were received, before the message that released them.
+func releasePendingNewChat(waChatId string) {
+	pendingNewChatsMu.Lock()
+	pending, found := pendingNewChats[waChatId]
+	if !found || pending.released || pending.quarantined {
+		pendingNewChatsMu.Unlock()
+		return
+	}
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+	pending.released = true
+	pendingNewChatsMu.Unlock()
+
+	for _, msg := range pending.messages {
+		handleRecovering(msg.v, func() { MessageFromOthersEventHandler(msg.text, msg.v, msg.isEdited) })
+	}
+
+	pendingNewChatsMu.Lock()
+	delete(pendingNewChats, waChatId)
+	pendingNewChatsMu.Unlock()
+}
+
+func expirePendingNewChat(waChatId string) {
+	var (
+		cfg    = state.State.Config()
+		logger = state.State.Logger
+	)
+
+	pendingNewChatsMu.Lock()
+	pending, found := pendingNewChats[waChatId]
+	if !found || pending.released {
+		pendingNewChatsMu.Unlock()
+		return
+	}
+
+	if !cfg.WhatsApp.NewChatQuarantine {
+		delete(pendingNewChats, waChatId)
+		pendingNewChatsMu.Unlock()
+		logger.Debug("dropping held messages of a chat that didn't reach new_chat_min_messages",
+			zap.String("chat_jid", waChatId),
+			zap.Int("messages", len(pending.messages)),
+		)
+		return
+	}
+
+	pending.quarantined = true
+	pendingNewChatsMu.Unlock()
+
+	for _, msg := range pending.messages {
+		handleRecovering(msg.v, func() { MessageFromOthersEventHandler(msg.text, msg.v, msg.isEdited) })
+	}
+
+	pendingNewChatsMu.Lock()
+	delete(pendingNewChats, waChatId)
+	pendingNewChatsMu.Unlock()
+}
@@ -0,0 +1,89 @@
+package whatsapp
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/queue"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Messages you send from your other devices starting with no_bridge_prefix
+// are not bridged. Their later edits aren't either, even if the edit removed
+// the prefix, including the edit sent when strip_no_bridge_prefix is set.
+
+const optedOutMessageTTL = 24 * time.Hour
+
+var (
+	optedOutMessagesMu sync.Mutex
+	optedOutMessages   = make(map[string]time.Time) // Message ID -> time it was skipped
+)
+
+// messageOptedOut reports whether the message from you must not be bridged,
+// and remembers it so that its edits are skipped too.
+func messageOptedOut(text string, v *events.Message, msgId string, isEdited bool) bool {
+	prefix := state.State.Config().WhatsApp.NoBridgePrefix
+	if prefix == "" {
+		return false
+	}
+
+	optedOutMessagesMu.Lock()
+	defer optedOutMessagesMu.Unlock()
+
+	for id, skippedAt := range optedOutMessages {
+		if time.Since(skippedAt) > optedOutMessageTTL {
+			delete(optedOutMessages, id)
+		}
+	}
+
+	if _, found := optedOutMessages[msgId]; found {
+		return true
+	}
+	if !strings.HasPrefix(strings.TrimSpace(text), prefix) {
+		return false
+	}
+
+	optedOutMessages[msgId] = time.Now()
+	if !isEdited && state.State.Config().WhatsApp.StripNoBridgePrefix {
+		go stripNoBridgePrefix(text, v, prefix)
+	}
+	return true
+}
+
+// stripNoBridgePrefix edits the message on WhatsApp to remove the prefix.
+func stripNoBridgePrefix(text string, v *events.Message, prefix string) {
+	var (
+		logger   = state.State.Logger
+		waClient = state.State.WhatsAppClient
+	)
+
+	stripped := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), prefix))
+	if stripped == "" {
+		return
+	}
+
+	var newContent *waE2E.Message
+	if v.Message.GetExtendedTextMessage() != nil {
+		extendedText := proto.Clone(v.Message.GetExtendedTextMessage()).(*waE2E.ExtendedTextMessage)
+		extendedText.Text = proto.String(stripped)
+		newContent = &waE2E.Message{ExtendedTextMessage: extendedText}
+	} else {
+		newContent = &waE2E.Message{Conversation: proto.String(stripped)}
+	}
+
+	_, err := queue.WaSend(context.Background(), v.Info.Chat, waClient.BuildEdit(v.Info.Chat, v.Info.ID, newContent))
+	if err != nil {
+		logger.Warn("failed to remove no_bridge_prefix from a message",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,106 @@
+package whatsapp
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// waFormatMoney formats an amount given in thousandths of the currency.
+func waFormatMoney(amount1000 int64, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", float64(amount1000)/1000, currency))
+}
+
+func waPaymentNote(note *waE2E.Message) string {
+	if text := note.GetExtendedTextMessage().GetText(); text != "" {
+		return text
+	}
+	return note.GetConversation()
+}
+
+// waPaymentSummary describes a payment or order message, along with the key
+// of the payment request it refers to if any. It reports false for other
+// messages.
+func waPaymentSummary(msg *waE2E.Message) (string, *waCommon.MessageKey, bool) {
+	var (
+		cfg     = state.State.Config()
+		summary string
+		note    string
+		key     *waCommon.MessageKey
+	)
+
+	switch {
+	case msg.GetRequestPaymentMessage() != nil:
+		request := msg.GetRequestPaymentMessage()
+		summary = "💸 <b>Payment request</b>\n"
+		if amount := request.GetAmount(); amount.GetValue() > 0 && amount.GetOffset() > 0 {
+			summary += fmt.Sprintf("💰: %s\n", html.EscapeString(waFormatMoney(
+				amount.GetValue()*1000/int64(amount.GetOffset()), amount.GetCurrencyCode())))
+		} else if request.GetAmount1000() > 0 {
+			summary += fmt.Sprintf("💰: %s\n", html.EscapeString(waFormatMoney(
+				int64(request.GetAmount1000()), request.GetCurrencyCodeIso4217())))
+		}
+		if expiry := request.GetExpiryTimestamp(); expiry > 0 {
+			summary += fmt.Sprintf("⌛: Expires at %s\n", html.EscapeString(
+				time.Unix(expiry, 0).In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+		}
+		note = waPaymentNote(request.GetNoteMessage())
+
+	case msg.GetSendPaymentMessage() != nil:
+		payment := msg.GetSendPaymentMessage()
+		summary = "💸 <b>Payment sent</b>\n"
+		if payment.GetRequestMessageKey() != nil {
+			summary += "<i>In response to a payment request</i>\n"
+		}
+		note = waPaymentNote(payment.GetNoteMessage())
+		key = payment.GetRequestMessageKey()
+
+	case msg.GetDeclinePaymentRequestMessage() != nil:
+		summary = "💸 <b>Payment request declined</b>\n"
+		key = msg.GetDeclinePaymentRequestMessage().GetKey()
+
+	case msg.GetCancelPaymentRequestMessage() != nil:
+		summary = "💸 <b>Payment request cancelled</b>\n"
+		key = msg.GetCancelPaymentRequestMessage().GetKey()
+
+	case msg.GetPaymentInviteMessage() != nil:
+		summary = "💸 <b>Invitation to set up payments</b>\n"
+
+	case msg.GetOrderMessage() != nil:
+		order := msg.GetOrderMessage()
+		summary = "🧾 <b>Order</b>\n"
+		if title := order.GetOrderTitle(); title != "" {
+			summary += fmt.Sprintf("<b>%s</b>\n", html.EscapeString(title))
+		}
+		if itemCount := order.GetItemCount(); itemCount > 0 {
+			summary += fmt.Sprintf("📦: %d items\n", itemCount)
+		}
+		if total := order.GetTotalAmount1000(); total > 0 {
+			summary += fmt.Sprintf("💰: %s\n", html.EscapeString(waFormatMoney(total, order.GetTotalCurrencyCode())))
+		}
+		if order.Status != nil {
+			status := strings.ToLower(strings.ReplaceAll(order.GetStatus().String(), "_", " "))
+			summary += fmt.Sprintf("📋: %s\n", html.EscapeString(status))
+		}
+		note = order.GetMessage()
+		key = order.GetOrderRequestMessageID()
+
+	default:
+		return "", nil, false
+	}
+
+	if note != "" {
+		if len(note) > 600 {
+			note = utils.SubString(note, 0, 600) + "..."
+		}
+		summary += "\n" + html.EscapeString(note)
+	}
+	return summary, key, true
+}
@@ -0,0 +1,63 @@
+package whatsapp
+
+import (
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// When reaction_debounce_ms is set, a reaction is held back for that long and
+// replaced by any later reaction of the same sender to the same message, so a
+// sender changing their mind a few times only causes one update on Telegram.
+
+type pendingReaction struct {
+	text  string
+	evt   *events.Message
+	timer *time.Timer
+}
+
+var (
+	pendingReactionsMu sync.Mutex
+	pendingReactions   = make(map[string]*pendingReaction) // Chat/reacted message/sender -> latest reaction
+)
+
+// debounceReaction holds back the reaction if enabled, and reports whether it
+// did, in which case it is bridged later.
+func debounceReaction(text string, v *events.Message) bool {
+	delay := time.Duration(state.State.Config().Telegram.ReactionDebounceMs) * time.Millisecond
+	if delay <= 0 {
+		return false
+	}
+
+	reactionMsg := v.Message.GetReactionMessage()
+	key := v.Info.Chat.String() + "/" + reactionMsg.GetKey().GetID() + "/" + v.Info.Sender.ToNonAD().String()
+
+	pendingReactionsMu.Lock()
+	defer pendingReactionsMu.Unlock()
+
+	if pending, found := pendingReactions[key]; found {
+		pending.timer.Stop()
+	}
+	pending := &pendingReaction{text: text, evt: v}
+	pending.timer = time.AfterFunc(delay, func() {
+		pendingReactionsMu.Lock()
+		if pendingReactions[key] != pending {
+			pendingReactionsMu.Unlock()
+			return
+		}
+		delete(pendingReactions, key)
+		pendingReactionsMu.Unlock()
+
+		defer recoverEventPanic(pending.evt)
+		if pending.evt.Info.IsFromMe {
+			MessageFromMeEventHandler(pending.text, pending.evt, false)
+		} else {
+			MessageFromOthersEventHandler(pending.text, pending.evt, false)
+		}
+	})
+	pendingReactions[key] = pending
+	return true
+}
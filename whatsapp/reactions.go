@@ -0,0 +1,230 @@
+package whatsapp
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/queue"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+// Reactions in WhatsApp groups are collected per message and bridged as a
+// single summary reply (e.g. "👍 x3, ❤️ x1") that is edited as reactions
+// change, instead of sending one Telegram message per reaction.
+
+const (
+	reactionSummaryDebounce = 5 * time.Second
+	reactionSummaryTTL      = 24 * time.Hour
+)
+
+type reactionSummary struct {
+	reactions    map[string]string // Sender JID -> emoji
+	tgMsgId      int64             // Bridged message that was reacted to
+	tgThreadId   int64
+	summaryMsgId int64 // Summary reply, 0 until it is sent
+	timer        *time.Timer
+	updatedAt    time.Time
+
+	// Only one flush of a summary runs at a time, otherwise two of them could
+	// both send a new summary. A flush that comes in meanwhile is run after
+	// it, and edits the summary the first one sent.
+	flushing     bool
+	flushPending bool
+}
+
+var (
+	reactionSummariesMu sync.Mutex
+	reactionSummaries   = make(map[string]*reactionSummary)
+)
+
+// queueGroupReactionSummary records a reaction (an empty emoji revokes it) and
+// schedules the summary of the reacted message to be sent or updated once no
+// new reactions arrived for reactionSummaryDebounce.
+func queueGroupReactionSummary(waChatId, stanzaId, sender, emoji string, tgMsgId, tgThreadId int64) {
+	key := waChatId + "/" + stanzaId
+
+	reactionSummariesMu.Lock()
+	defer reactionSummariesMu.Unlock()
+
+	for k, summary := range reactionSummaries {
+		if time.Since(summary.updatedAt) > reactionSummaryTTL {
+			delete(reactionSummaries, k)
+		}
+	}
+
+	summary, found := reactionSummaries[key]
+	if !found {
+		summary = &reactionSummary{
+			reactions:  make(map[string]string),
+			tgMsgId:    tgMsgId,
+			tgThreadId: tgThreadId,
+		}
+		reactionSummaries[key] = summary
+	}
+
+	if emoji == "" {
+		delete(summary.reactions, sender)
+	} else {
+		summary.reactions[sender] = emoji
+	}
+	summary.updatedAt = time.Now()
+
+	if summary.timer != nil {
+		summary.timer.Stop()
+	}
+	summary.timer = time.AfterFunc(reactionSummaryDebounce, func() { flushGroupReactionSummary(key) })
+}
+
+func flushGroupReactionSummary(key string) {
+	var (
+		cfg    = state.State.Config()
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+
+	reactionSummariesMu.Lock()
+	summary, found := reactionSummaries[key]
+	if !found {
+		reactionSummariesMu.Unlock()
+		return
+	}
+	if summary.flushing {
+		summary.flushPending = true
+		reactionSummariesMu.Unlock()
+		return
+	}
+	summary.flushing = true
+	counts := make(map[string]int)
+	for _, emoji := range summary.reactions {
+		counts[emoji]++
+	}
+	tgMsgId, tgThreadId, summaryMsgId := summary.tgMsgId, summary.tgThreadId, summary.summaryMsgId
+	reactionSummariesMu.Unlock()
+
+	defer func() {
+		reactionSummariesMu.Lock()
+		summary.flushing = false
+		pending := summary.flushPending
+		summary.flushPending = false
+		reactionSummariesMu.Unlock()
+
+		if pending {
+			flushGroupReactionSummary(key)
+		}
+	}()
+
+	if len(counts) == 0 && summaryMsgId == 0 {
+		return
+	}
+
+	emojis := make([]string, 0, len(counts))
+	for emoji := range counts {
+		emojis = append(emojis, emoji)
+	}
+	sort.Slice(emojis, func(i, j int) bool {
+		if counts[emojis[i]] != counts[emojis[j]] {
+			return counts[emojis[i]] > counts[emojis[j]]
+		}
+		return emojis[i] < emojis[j]
+	})
+
+	var text string
+	if len(emojis) == 0 {
+		text = "<code>No reactions to this message</code>"
+	} else {
+		parts := make([]string, 0, len(emojis))
+		for _, emoji := range emojis {
+			parts = append(parts, fmt.Sprintf("%s x%d", html.EscapeString(emoji), counts[emoji]))
+		}
+		text = fmt.Sprintf("<code>Reactions: %s</code>", strings.Join(parts, ", "))
+	}
+
+	if summaryMsgId != 0 {
+		_, err := queue.TgEditMessageText(tgBot, text, &gotgbot.EditMessageTextOpts{
+			ChatId:    cfg.Telegram.TargetChatID,
+			MessageId: summaryMsgId,
+		})
+		if utils.TgIsMessageNotFound(err) {
+			// The summary was deleted on Telegram, the next update sends a new one
+			logger.Debug("group reaction summary was deleted, forgetting it", zap.String("key", key))
+			reactionSummariesMu.Lock()
+			if summary.summaryMsgId == summaryMsgId {
+				summary.summaryMsgId = 0
+			}
+			reactionSummariesMu.Unlock()
+		} else if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+			logger.Warn("failed to edit group reaction summary",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+		}
+		return
+	}
+
+	sentMsg, err := queue.TgSendMessage(tgBot, cfg.Telegram.TargetChatID, text, &gotgbot.SendMessageOpts{
+		ReplyParameters: &gotgbot.ReplyParameters{
+			MessageId: tgMsgId,
+		},
+		MessageThreadId: tgThreadId,
+	})
+	if err != nil {
+		logger.Warn("failed to send group reaction summary",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return
+	}
+
+	reactionSummariesMu.Lock()
+	summary.summaryMsgId = sentMsg.MessageId
+	reactionSummariesMu.Unlock()
+}
+
+// tgSetNativeReaction sets the reaction on the bridged message itself, mapping
+// it to one Telegram allows. It returns false if the reaction has to be
+// bridged as a text reply instead. An empty emoji removes the reaction.
+func tgSetNativeReaction(tgMsgId int64, emoji string) bool {
+	var (
+		cfg    = state.State.Config()
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+
+	reaction := []gotgbot.ReactionType{}
+	if emoji != "" {
+		tgEmoji, ok := utils.TgReactionForWaEmoji(emoji)
+		if !ok {
+			return false
+		}
+		reaction = append(reaction, gotgbot.ReactionTypeEmoji{Emoji: tgEmoji})
+	}
+
+	_, err := queue.TgSetMessageReaction(tgBot, cfg.Telegram.TargetChatID, tgMsgId, &gotgbot.SetMessageReactionOpts{
+		Reaction: reaction,
+	})
+	if utils.TgIsMessageNotFound(err) {
+		// Nothing left to react to, and a text reaction would have nothing to reply to
+		logger.Debug("the reacted message was deleted on Telegram, forgetting it",
+			zap.Int64("tg_msg_id", tgMsgId),
+		)
+		database.MsgIdDeletePair(cfg.Telegram.TargetChatID, tgMsgId)
+		return true
+	} else if err != nil {
+		logger.Warn("failed to set reaction on the bridged message, sending it as text instead",
+			zap.Int64("tg_msg_id", tgMsgId),
+			zap.String("emoji", emoji),
+			zap.Error(err),
+		)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package whatsapp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"watgbridge/internal/testutil"
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+func TestFlushGroupReactionSummaryConcurrent(t *testing.T) {
+	testutil.SetConfig(t, func(cfg *state.Config) {
+		cfg.Telegram.TargetChatID = -1001234567890
+	})
+	state.State.Logger = zap.NewNop()
+	botClient := testutil.UseFakeBot(t)
+	botClient.Delay = 20 * time.Millisecond
+
+	const waChatId, stanzaId = "120363012345678901@g.us", "3EB0C767D71D7A5C2E4F"
+	key := waChatId + "/" + stanzaId
+	queueGroupReactionSummary(waChatId, stanzaId, "919876543210@s.whatsapp.net", "👍", 42, 7)
+	t.Cleanup(func() {
+		reactionSummariesMu.Lock()
+		reactionSummaries[key].timer.Stop()
+		delete(reactionSummaries, key)
+		reactionSummariesMu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			flushGroupReactionSummary(key)
+		}()
+	}
+	wg.Wait()
+
+	if sent := len(botClient.Requests("sendMessage")); sent != 1 {
+		t.Errorf("sent %d summaries, want 1", sent)
+	}
+	if edited := len(botClient.Requests("editMessageText")); edited != 1 {
+		t.Errorf("edited the summary %d times, want 1", edited)
+	}
+}
@@ -0,0 +1,60 @@
+package whatsapp
+
+import (
+	"fmt"
+	"html"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+// A panic while handling a WhatsApp event, e.g. because of a malformed message
+// or a failed send, only drops that event. Events handled from timers and
+// background goroutines would otherwise take the whole bridge down.
+
+const panicNoticeCooldown = 10 * time.Minute
+
+var (
+	lastPanicNoticeMu sync.Mutex
+	lastPanicNotice   time.Time
+)
+
+// recoverEventPanic logs a panic in the handling of the event and, if
+// notify_admins_on_panic is set, tells the admins about it. It must be called
+// with defer.
+func recoverEventPanic(evt any) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	state.State.Logger.Error("recovered from a panic while handling a WhatsApp event",
+		zap.String("event_type", fmt.Sprintf("%T", evt)),
+		zap.Any("panic", r),
+		zap.Stack("stack"),
+	)
+
+	if !state.State.Config().WhatsApp.NotifyAdminsOnPanic {
+		return
+	}
+
+	lastPanicNoticeMu.Lock()
+	if time.Since(lastPanicNotice) < panicNoticeCooldown {
+		lastPanicNoticeMu.Unlock()
+		return
+	}
+	lastPanicNotice = time.Now()
+	lastPanicNoticeMu.Unlock()
+
+	notifyAdmins(fmt.Sprintf("Handling a WhatsApp event (<code>%s</code>) failed and it was skipped:\n\n<code>%s</code>\n\nCheck the logs for details, further failures are not reported for %s",
+		html.EscapeString(fmt.Sprintf("%T", evt)), html.EscapeString(fmt.Sprint(r)), panicNoticeCooldown))
+}
+
+// handleRecovering runs handle, recovering from a panic in it.
+func handleRecovering(evt any, handle func()) {
+	defer recoverEventPanic(evt)
+	handle()
+}
@@ -0,0 +1,30 @@
+package whatsapp
+
+import (
+	"slices"
+	"testing"
+
+	"watgbridge/internal/testutil"
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+func TestHandleRecoveringSurvivesPanic(t *testing.T) {
+	testutil.SetConfig(t, nil)
+	state.State.Logger = zap.NewNop()
+
+	var handled []string
+	for _, evt := range []string{"first", "malformed", "last"} {
+		handleRecovering(evt, func() {
+			if evt == "malformed" {
+				panic("malformed message")
+			}
+			handled = append(handled, evt)
+		})
+	}
+
+	if want := []string{"first", "last"}; !slices.Equal(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+}
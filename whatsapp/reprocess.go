@@ -0,0 +1,87 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// A message that was bridged incorrectly can be bridged again by asking the
+// phone to resend it. The resent copy arrives as a normal message event with
+// UnavailableRequestID set, and is bridged as a new message even though it
+// was seen before. If the phone doesn't have the message anymore nothing
+// arrives, so the request is given up on after reprocessTimeout.
+
+const reprocessTimeout = time.Minute
+
+var (
+	reprocessRequestsMu sync.Mutex
+	reprocessRequests   = make(map[string]*time.Timer) // Request message ID -> timeout
+)
+
+// RequestReprocess asks the phone to resend the message. onTimeout is called
+// if it isn't received within reprocessTimeout.
+func RequestReprocess(chat, sender waTypes.JID, msgId string, onTimeout func()) error {
+	waClient := state.State.WhatsAppClient
+	if waClient.Store.ID == nil {
+		return whatsmeow.ErrNotLoggedIn
+	}
+
+	// The phone may answer before the send returns, so the request must be
+	// known by then
+	requestId := waClient.GenerateMessageID()
+	reprocessRequestsMu.Lock()
+	reprocessRequests[requestId] = time.AfterFunc(reprocessTimeout, func() {
+		reprocessRequestsMu.Lock()
+		_, pending := reprocessRequests[requestId]
+		delete(reprocessRequests, requestId)
+		reprocessRequestsMu.Unlock()
+		if pending {
+			onTimeout()
+		}
+	})
+	reprocessRequestsMu.Unlock()
+
+	_, err := waClient.SendMessage(context.Background(), waClient.Store.ID.ToNonAD(),
+		waClient.BuildUnavailableMessageRequest(chat, sender, msgId),
+		whatsmeow.SendRequestExtra{ID: requestId, Peer: true})
+	if err != nil {
+		reprocessRequestsMu.Lock()
+		if timer, found := reprocessRequests[requestId]; found {
+			timer.Stop()
+			delete(reprocessRequests, requestId)
+		}
+		reprocessRequestsMu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// isReprocessResponse reports whether the message was resent in response to
+// RequestReprocess, in which case it has to be bridged again.
+func isReprocessResponse(v *events.Message) bool {
+	if v.UnavailableRequestID == "" {
+		return false
+	}
+	reprocessRequestsMu.Lock()
+	defer reprocessRequestsMu.Unlock()
+	_, found := reprocessRequests[v.UnavailableRequestID]
+	return found
+}
+
+// finishReprocessRequest forgets the request once its response was handled.
+func finishReprocessRequest(v *events.Message) {
+	reprocessRequestsMu.Lock()
+	defer reprocessRequestsMu.Unlock()
+	if timer, found := reprocessRequests[v.UnavailableRequestID]; found {
+		timer.Stop()
+		delete(reprocessRequests, v.UnavailableRequestID)
+	}
+}
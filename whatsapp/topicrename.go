@@ -0,0 +1,104 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// When a contact's push name or saved name changes, the topic of their private
+// chat is renamed once topic_rename_debounce_seconds pass without another
+// change, so a name that is noticed on every message or changed several times
+// in a row only renames the topic once.
+
+var (
+	topicRenameMu     sync.Mutex
+	topicRenameTimers = make(map[string]*time.Timer) // WhatsApp chat ID -> pending rename
+	topicRenameNames  = make(map[string]string)      // WhatsApp chat ID -> last name set by a rename
+)
+
+// scheduleTopicRename renames the topic of the contact's private chat after
+// the debounce delay, restarting the delay if a rename is already pending.
+func scheduleTopicRename(jid waTypes.JID) {
+	cfg := state.State.Config()
+	if !cfg.WhatsApp.RenameTopicsOnNameChange || !cfg.BridgeWaToTg {
+		return
+	}
+
+	chatJID := jid.ToNonAD()
+	if chatJID.Server == waTypes.HiddenUserServer {
+		pn, err := state.State.WhatsAppClient.Store.LIDs.GetPNForLID(context.Background(), chatJID)
+		if err == nil && !pn.IsEmpty() {
+			chatJID = pn
+		}
+	}
+	if chatJID.Server != waTypes.DefaultUserServer {
+		return
+	}
+	waChatId := chatJID.String()
+
+	delay := time.Duration(cfg.WhatsApp.TopicRenameDebounceSeconds) * time.Second
+
+	topicRenameMu.Lock()
+	defer topicRenameMu.Unlock()
+
+	if timer, found := topicRenameTimers[waChatId]; found {
+		timer.Stop()
+	}
+	topicRenameTimers[waChatId] = time.AfterFunc(delay, func() {
+		topicRenameMu.Lock()
+		delete(topicRenameTimers, waChatId)
+		topicRenameMu.Unlock()
+
+		renameTopic(chatJID)
+	})
+}
+
+func renameTopic(chatJID waTypes.JID) {
+	var (
+		cfg      = state.State.Config()
+		logger   = state.State.Logger
+		tgBot    = state.State.TelegramBot
+		waChatId = chatJID.String()
+	)
+	defer logger.Sync()
+
+	tgThreadId, threadFound, err := database.ChatThreadGetTgFromWa(waChatId, cfg.Telegram.TargetChatID)
+	if err != nil || !threadFound || tgThreadId == 0 {
+		return
+	}
+
+	newName := utils.TgFormatTopicName(waChatId, utils.WaGetContactName(chatJID))
+
+	topicRenameMu.Lock()
+	unchanged := topicRenameNames[waChatId] == newName
+	topicRenameMu.Unlock()
+	if unchanged {
+		return
+	}
+
+	if err = utils.TgEditForumTopicName(tgBot, cfg.Telegram.TargetChatID, tgThreadId, newName); err != nil {
+		logger.Warn("failed to rename the topic of a contact whose name changed",
+			zap.String("chat", waChatId),
+			zap.Int64("thread_id", tgThreadId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	topicRenameMu.Lock()
+	topicRenameNames[waChatId] = newName
+	topicRenameMu.Unlock()
+
+	logger.Debug("renamed the topic of a contact whose name changed",
+		zap.String("chat", waChatId),
+		zap.String("name", newName),
+	)
+}